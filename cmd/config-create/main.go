@@ -1,13 +1,17 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"time"
 
 	"github.com/mr55p-dev/app-utils/config"
+	"github.com/mr55p-dev/app-utils/lib/manager"
 	"github.com/mr55p-dev/app-utils/lib/portainer"
 	"github.com/mr55p-dev/gonk"
 )
@@ -16,53 +20,57 @@ type CliConfig struct {
 	PortainerHost       string
 	PortainerToken      string
 	PortainerEndpointId string
+	PortainerCaBundle   string
+	// PortainerCredentials is an env file with PORTAINER_USERNAME and
+	// PORTAINER_PASSWORD, which are read from the environment when unset.
+	PortainerCredentials string
 }
 
 type StackDataFile struct {
 	Id int `json:"Id"`
 }
 
-func iter(basePath string, cli *portainer.Client) {
+func iter(ctx context.Context, basePath string, cli *portainer.Client) {
 	configFile, err := config.NewFromFile(basePath)
 	if err != nil {
 		panic(err)
 	}
-	name := configFile.App
-	composeFile, err := os.Open(filepath.Join(basePath, "docker-compose.yml"))
+	composeFile, err := os.ReadFile(filepath.Join(basePath, "docker-compose.yml"))
 	if err != nil {
 		panic(err)
 	}
-	defer composeFile.Close()
-	envFile, err := os.Open(filepath.Join(basePath, "stack.env"))
+	envFile, err := os.ReadFile(filepath.Join(basePath, "stack.env"))
 	if err != nil {
 		panic(err)
 	}
-	defer envFile.Close()
-	envPairs, err := portainer.ReadEnvironment(envFile)
-	if err != nil {
-		panic(err)
+	app := &manager.App{
+		ID:          filepath.Base(basePath),
+		Path:        basePath,
+		ComposeFile: composeFile,
+		EnvFile:     envFile,
+		PortainerId: getStackId(basePath),
+		AppYaml:     configFile,
 	}
 
-	stackId := getStackId(basePath)
-	if stackId == 0 {
+	if app.PortainerId == 0 {
 		fmt.Println("No existing stack found. Creating a new one.")
-		res, err := cli.CreateStack(name, composeFile, envPairs)
-		if err != nil {
-			panic(err)
-		}
-		fmt.Println("Created stack", name, "with id", res.Id)
-		data, err := json.Marshal(res)
-		err = os.WriteFile(filepath.Join(basePath, ".stack"), data, 0o644)
-		if err != nil {
-			panic(err)
-		}
 	} else {
-		fmt.Println("Updating existing stack with id", stackId)
-		res, err := cli.UpdateStack(stackId, composeFile, envPairs)
-		if err != nil {
-			panic(err)
-		}
-		fmt.Println("Updated stack", res)
+		fmt.Println("Updating existing stack with id", app.PortainerId)
+	}
+	res, err := manager.Publish(ctx, cli, app, portainer.DeployOptions{Prune: true})
+	if err != nil {
+		panic(err)
+	}
+	if res.Created {
+		fmt.Println("Created stack", configFile.App, "with id", res.StackId)
+	} else {
+		fmt.Println("Updated stack", res.StackId)
+	}
+	if res.Git {
+		fmt.Println("Deployed from", configFile.Portainer.Git.URL, "at commit", res.Commit)
+	}
+	if res.Webhook != "" {
+		fmt.Println("Redeploy webhook:", res.Webhook)
 	}
 }
 
@@ -72,15 +80,29 @@ func main() {
 		panic("Failed to load portainer data from env")
 	}
 
+	httpClient, err := portainer.NewHTTPClient(config.PortainerCaBundle, false)
+	if err != nil {
+		panic(err)
+	}
+	creds, err := portainer.LoadCredentials(config.PortainerCredentials)
+	if err != nil {
+		panic(err)
+	}
 	cli := &portainer.Client{
-		Scheme:     "https",
-		Host:       config.PortainerHost,
-		ApiKey:     config.PortainerToken,
-		EndpointId: config.PortainerEndpointId,
+		Scheme:      "https",
+		Host:        config.PortainerHost,
+		ApiKey:      config.PortainerToken,
+		Credentials: creds,
+		EndpointId:  config.PortainerEndpointId,
+		HTTPClient:  httpClient,
+		Timeout:     time.Minute,
+		Retries:     2,
 	}
 
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
 	for _, basePath := range flag.Args() {
-		iter(basePath, cli)
+		iter(ctx, basePath, cli)
 	}
 
 }
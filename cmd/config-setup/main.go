@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"errors"
 	"flag"
 	"fmt"
@@ -8,6 +9,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 	"text/template"
 
 	"github.com/mr55p-dev/app-utils/config"
@@ -25,6 +27,37 @@ type Config struct {
 var includeNignx = flag.Bool("include-nginx", true, "Generates NGINX config")
 var includeEnv = flag.Bool("include-env", true, "Generate environment files")
 var extensionsFile = flag.String("extensions", "./env-extensions.yml", "Path to extensions file")
+var domains = flag.String("domains", "home.pagemail.io", "Comma separated base domains for nginx hosts")
+var sslCertPath = flag.String("ssl-cert", "", "Path to ssl cert, /etc/letsencrypt/live/<first domain>/fullchain.pem when empty")
+var sslCertKeyPath = flag.String("ssl-key", "", "Path to ssl cert key, /etc/letsencrypt/live/<first domain>/privkey.pem when empty")
+var sslDHParamPath = flag.String("ssl-dhparam", "/etc/arr/dhparam.txt", "Path to dhparams.txt file")
+var authEndpoint = flag.String("auth-endpoint", "http://vouch.internal:9090/validate", "URL nginx uses to validate requests to protected hosts")
+var authLoginURL = flag.String("auth-login-url", "", "URL unauthenticated users are redirected to, https://vouch.<first domain>/login when empty")
+
+// nginxOptions returns the domains, certificate and vouch settings the nginx
+// template is rendered with. Paths and URLs left unset are derived from the
+// first domain.
+func nginxOptions() []generate.NginxOption {
+	domainList := config.SplitList(*domains)
+	first := ""
+	if len(domainList) > 0 {
+		first = strings.TrimPrefix(domainList[0], ".")
+	}
+	orDefault := func(val, def string) string {
+		if val != "" {
+			return val
+		}
+		return def
+	}
+	return []generate.NginxOption{
+		generate.WithDomains(domainList...),
+		generate.WithData("SSLCertPath", orDefault(*sslCertPath, "/etc/letsencrypt/live/"+first+"/fullchain.pem")),
+		generate.WithData("SSLCertKeyPath", orDefault(*sslCertKeyPath, "/etc/letsencrypt/live/"+first+"/privkey.pem")),
+		generate.WithData("SSLDHParamPath", *sslDHParamPath),
+		generate.WithData("AuthEndpoint", *authEndpoint),
+		generate.WithData("AuthLoginURL", orDefault(*authLoginURL, "https://vouch."+first+"/login")),
+	}
+}
 
 func main() {
 	flag.Parse()
@@ -69,7 +102,7 @@ func (cfg *Config) doNginx() error {
 		return errors.New("Template is nil")
 	}
 
-	nginxData, err := generate.Nginx(nginxTemplate, cfg.appConfig.Nginx)
+	nginxData, err := generate.Nginx(nginxTemplate, cfg.appConfig.Nginx, nginxOptions()...)
 	if err != nil {
 		return fmt.Errorf("Error when executing nginx template: %w", err)
 	}
@@ -87,14 +120,18 @@ func (cfg *Config) doNginx() error {
 }
 
 func (cfg *Config) doEnvFile() error {
-	envData, err := generate.Environment(cfg.appConfig, cfg.extensions)
+	env, err := generate.NewStackEnv(cfg.appConfig, cfg.extensions)
 	if err != nil {
 		return fmt.Errorf("Failed to load env data: %w", err)
 	}
-	b, err := io.ReadAll(envData)
-	if err != nil {
-		return fmt.Errorf("Failed to read from environment reader: %w", err)
+	for _, conflict := range env.Conflicts() {
+		log.Printf("Warning: env conflict %s", conflict.Conflict())
+	}
+	envData := new(bytes.Buffer)
+	if _, err := env.WriteTo(envData); err != nil {
+		return fmt.Errorf("Failed to write env data: %w", err)
 	}
+	b := envData.Bytes()
 	err = os.WriteFile(filepath.Join(cfg.baseDir, "stack.env"), b, 0644)
 	if err != nil {
 		return fmt.Errorf("Failed to write stack.env: %w", err)
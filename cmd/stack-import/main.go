@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mr55p-dev/app-utils/lib/manager"
+	"github.com/mr55p-dev/app-utils/lib/portainer"
+	"github.com/mr55p-dev/gonk"
+)
+
+type CliConfig struct {
+	PortainerHost       string
+	PortainerToken      string
+	PortainerEndpointId string
+	PortainerCaBundle   string
+	// PortainerCredentials is an env file with PORTAINER_USERNAME and
+	// PORTAINER_PASSWORD, which are read from the environment when unset.
+	PortainerCredentials string
+}
+
+var appsDir = flag.String("apps", "/etc/gold/apps", "Path to apps directory")
+var list = flag.Bool("list", false, "List the stacks in portainer instead of importing")
+var name = flag.String("name", "", "App name to import a single stack as, defaults to the stack name")
+
+// findStack resolves arg, either a stack ID or name, against stacks.
+func findStack(stacks []portainer.Stack, arg string) (portainer.Stack, error) {
+	id, err := strconv.Atoi(arg)
+	for _, stack := range stacks {
+		if (err == nil && stack.Id == id) || stack.Name == arg {
+			return stack, nil
+		}
+	}
+	return portainer.Stack{}, fmt.Errorf("No stack matching %s", arg)
+}
+
+// endpointName returns the name of the endpoint with id, or the id itself if
+// the name is unknown.
+func endpointName(endpoints map[int]string, id int) string {
+	if name, ok := endpoints[id]; ok {
+		return name
+	}
+	return strconv.Itoa(id)
+}
+
+func printStacks(stacks []portainer.Stack, imported map[int]string, endpoints map[int]string) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tENDPOINT\tTYPE\tSTATUS\tUPDATED\tAPP")
+	for _, stack := range stacks {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			stack.Id, stack.Name, endpointName(endpoints, stack.EndpointId), stack.Type, stack.Status,
+			stack.Updated().Format(time.DateTime), imported[stack.Id])
+	}
+	w.Flush()
+}
+
+func main() {
+	flag.Parse()
+	config := new(CliConfig)
+	if err := gonk.LoadConfig(config, gonk.EnvLoader("")); err != nil {
+		panic("Failed to load portainer data from env")
+	}
+	httpClient, err := portainer.NewHTTPClient(config.PortainerCaBundle, false)
+	if err != nil {
+		panic(err)
+	}
+	creds, err := portainer.LoadCredentials(config.PortainerCredentials)
+	if err != nil {
+		panic(err)
+	}
+	cli := &portainer.Client{
+		Scheme:      "https",
+		Host:        config.PortainerHost,
+		ApiKey:      config.PortainerToken,
+		Credentials: creds,
+		EndpointId:  config.PortainerEndpointId,
+		HTTPClient:  httpClient,
+		Timeout:     time.Minute,
+		Retries:     2,
+	}
+	apps, err := manager.New(*appsDir)
+	if err != nil {
+		panic(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	stacks, err := cli.ListStacks(ctx)
+	if err != nil {
+		log.Fatalf("Failed to list stacks: %s", err)
+	}
+	imported, err := apps.ImportedStacks()
+	if err != nil {
+		log.Fatalf("Failed to read apps: %s", err)
+	}
+	if *list || flag.NArg() == 0 {
+		endpoints, err := cli.EndpointNames(ctx)
+		if err != nil {
+			log.Printf("Failed to list endpoints: %s", err)
+		}
+		printStacks(stacks, imported, endpoints)
+		return
+	}
+	if *name != "" && flag.NArg() > 1 {
+		log.Fatal("-name can only be used when importing a single stack")
+	}
+
+	failed := false
+	for _, arg := range flag.Args() {
+		stack, err := findStack(stacks, arg)
+		if err != nil {
+			log.Println(err)
+			failed = true
+			continue
+		}
+		if app, ok := imported[stack.Id]; ok {
+			log.Printf("Stack %s is already imported as %s", stack.Name, app)
+			continue
+		}
+		appName := stack.Name
+		if *name != "" {
+			appName = *name
+		}
+		if err := apps.Import(ctx, appName, stack.Id, cli); err != nil {
+			log.Printf("Failed to import stack %s: %s", stack.Name, err)
+			failed = true
+			continue
+		}
+		log.Printf("Imported stack %s (%d) as %s", stack.Name, stack.Id, appName)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
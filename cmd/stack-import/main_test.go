@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mr55p-dev/app-utils/lib/portainer"
+)
+
+func TestFindStack(t *testing.T) {
+	stacks := []portainer.Stack{{Id: 1, Name: "web"}, {Id: 2, Name: "api"}, {Id: 3, Name: "db"}}
+	tests := []struct {
+		arg    string
+		wantId int
+	}{
+		{"web", 1},
+		{"2", 2},
+		{"db", 3},
+	}
+	for _, tc := range tests {
+		stack, err := findStack(stacks, tc.arg)
+		if err != nil || stack.Id != tc.wantId {
+			t.Errorf("findStack(%q) returned %+v, %v, want stack %d", tc.arg, stack, err, tc.wantId)
+		}
+	}
+	if _, err := findStack(stacks, "missing"); err == nil {
+		t.Error("Expected an error for an unknown stack")
+	}
+}
+
+func TestEndpointName(t *testing.T) {
+	endpoints := map[int]string{1: "local"}
+	if got := endpointName(endpoints, 1); got != "local" {
+		t.Errorf("Got %q, want local", got)
+	}
+	if got := endpointName(endpoints, 7); got != "7" {
+		t.Errorf("Got %q, want the ID for an unknown endpoint", got)
+	}
+}
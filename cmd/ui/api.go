@@ -0,0 +1,470 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/mr55p-dev/app-utils/config"
+	"github.com/mr55p-dev/app-utils/lib/compose"
+	"github.com/mr55p-dev/app-utils/lib/generate"
+	"github.com/mr55p-dev/app-utils/lib/manager"
+	"github.com/mr55p-dev/app-utils/lib/nginx"
+	"github.com/mr55p-dev/app-utils/lib/portainer"
+	"gopkg.in/yaml.v3"
+)
+
+const apiPrefix = "/api/v1"
+
+// apiRoute describes a single JSON endpoint. The request and response values
+// are only used to describe the body types in the OpenAPI document.
+type apiRoute struct {
+	Method   string
+	Path     string
+	Summary  string
+	Status   int
+	Request  any
+	Response any
+	Handler  echo.HandlerFunc
+}
+
+type apiError struct {
+	Error   string `json:"error"`
+	Details any    `json:"details,omitempty"`
+}
+
+type apiMessage struct {
+	Message string `json:"message"`
+}
+
+type apiContent struct {
+	Content string `json:"content"`
+}
+
+type apiAppSummary struct {
+	ID          string       `json:"id"`
+	NginxStatus nginx.Status `json:"nginxStatus"`
+	PortainerId int          `json:"portainerId,omitempty"`
+}
+
+type apiVhost struct {
+	config.NginxBlock
+	Names []string `json:"names"`
+}
+
+type apiApp struct {
+	ID          string            `json:"id"`
+	Path        string            `json:"path"`
+	NginxStatus nginx.Status      `json:"nginxStatus"`
+	PortainerId int               `json:"portainerId,omitempty"`
+	AppYaml     *config.AppConfig `json:"appYaml,omitempty"`
+	Vhosts      []apiVhost        `json:"vhosts"`
+	RawAppYaml  string            `json:"rawAppYaml"`
+	ComposeFile string            `json:"composeFile"`
+	EnvFile     string            `json:"envFile"`
+}
+
+type apiEnvConflict struct {
+	Key       string `json:"key"`
+	Source    string `json:"source"`
+	Overrides string `json:"overrides"`
+}
+
+type apiConfigUpdate struct {
+	apiApp
+	EnvConflicts []apiEnvConflict `json:"envConflicts"`
+}
+
+func apiEnvConflicts(conflicts []generate.EnvValue) []apiEnvConflict {
+	out := make([]apiEnvConflict, len(conflicts))
+	for i, conflict := range conflicts {
+		out[i] = apiEnvConflict{conflict.Key, conflict.Source, conflict.Overrides}
+	}
+	return out
+}
+
+type apiCsrf struct {
+	Header string `json:"header"`
+	Token  string `json:"token"`
+}
+
+type apiCreateApp struct {
+	Name string `json:"name"`
+	Host string `json:"host"`
+	Port int    `json:"port"`
+}
+
+type apiImportStack struct {
+	Name string `json:"name"`
+}
+
+type apiDeleteApp struct {
+	ComposeDown     bool `json:"composeDown"`
+	PortainerDelete bool `json:"portainerDelete"`
+}
+
+func apiFail(c echo.Context, code int, message string, details any) error {
+	return c.JSON(code, apiError{Error: message, Details: details})
+}
+
+func (h *Handler) apiRoutes() []apiRoute {
+	routes := []apiRoute{
+		{
+			Method: http.MethodGet, Path: "/csrf", Summary: "Get a CSRF token for cookie or basic auth sessions",
+			Response: apiCsrf{}, Handler: h.apiCsrfToken,
+		},
+		{
+			Method: http.MethodGet, Path: "/apps", Summary: "List apps",
+			Response: []apiAppSummary{}, Handler: h.apiListApps,
+		},
+		{
+			Method: http.MethodPost, Path: "/apps", Summary: "Create an app", Status: http.StatusCreated,
+			Request: apiCreateApp{}, Response: apiApp{}, Handler: h.apiCreateApp,
+		},
+		{
+			Method: http.MethodGet, Path: "/apps/:id", Summary: "Get an app",
+			Response: apiApp{}, Handler: h.apiGetApp,
+		},
+		{
+			Method: http.MethodDelete, Path: "/apps/:id", Summary: "Archive an app",
+			Request: apiDeleteApp{}, Response: manager.DeleteReport{}, Handler: h.apiDeleteApp,
+		},
+		{
+			Method: http.MethodPut, Path: "/apps/:id/config", Summary: "Replace app.yml",
+			Request: apiContent{}, Response: apiConfigUpdate{}, Handler: h.apiUpdateConfig,
+		},
+		{
+			Method: http.MethodPut, Path: "/apps/:id/compose", Summary: "Replace docker-compose.yml",
+			Request: apiContent{}, Response: apiApp{}, Handler: h.apiUpdateCompose,
+		},
+		{
+			Method: http.MethodGet, Path: "/apps/:id/containers", Summary: "List the app's containers",
+			Response: []compose.PsEntry{}, Handler: h.apiContainers,
+		},
+		{
+			Method: http.MethodPost, Path: "/apps/:id/compose/up", Summary: "Run compose up",
+			Response: apiMessage{}, Handler: h.apiComposeUp,
+		},
+		{
+			Method: http.MethodPost, Path: "/apps/:id/nginx/enable", Summary: "Install and enable the nginx unit",
+			Response: apiApp{}, Handler: h.apiNginxEnable,
+		},
+		{
+			Method: http.MethodPost, Path: "/apps/:id/nginx/disable", Summary: "Remove the nginx unit and reload nginx",
+			Response: apiApp{}, Handler: h.apiNginxDisable,
+		},
+		{
+			Method: http.MethodGet, Path: "/apps/:id/portainer", Summary: "Get the app's portainer stack and its endpoint",
+			Response: stackInfo{}, Handler: h.apiPortainerStack,
+		},
+		{
+			Method: http.MethodGet, Path: "/apps/:id/portainer/plan", Summary: "Preview the changes publishing the stack would make",
+			Response: manager.Plan{}, Handler: h.apiPortainerPlan,
+		},
+		{
+			Method: http.MethodPost, Path: "/apps/:id/portainer/publish", Summary: "Publish the stack to portainer as previewed by a plan, creating it if the app has none",
+			Request: apiPublish{}, Response: manager.PublishResult{}, Handler: h.apiPortainerPublish,
+		},
+		{
+			Method: http.MethodPost, Path: "/apps/:id/portainer/redeploy", Summary: "Redeploy the app's git stack from the latest commit",
+			Request: portainer.DeployOptions{}, Response: manager.PublishResult{}, Handler: h.apiPortainerRedeploy,
+		},
+		{
+			Method: http.MethodPost, Path: "/apps/:id/portainer/start", Summary: "Start the app's portainer stack",
+			Response: portainer.Stack{}, Handler: h.apiPortainerState("start"),
+		},
+		{
+			Method: http.MethodPost, Path: "/apps/:id/portainer/stop", Summary: "Stop the app's portainer stack",
+			Response: portainer.Stack{}, Handler: h.apiPortainerState("stop"),
+		},
+		{
+			Method: http.MethodDelete, Path: "/apps/:id/portainer", Summary: "Delete the app's portainer stack, keeping the app",
+			Response: apiApp{}, Handler: h.apiPortainerDelete,
+		},
+		{
+			Method: http.MethodPost, Path: "/nginx/reload", Summary: "Validate and reload nginx",
+			Response: apiMessage{}, Handler: h.apiNginxReload,
+		},
+		{
+			Method: http.MethodGet, Path: "/portainer/endpoints", Summary: "List portainer endpoints",
+			Response: []portainer.Endpoint{}, Handler: h.apiListEndpoints,
+		},
+		{
+			Method: http.MethodGet, Path: "/portainer/stacks", Summary: "List portainer stacks and the apps they were imported as",
+			Response: []importableStack{}, Handler: h.apiListStacks,
+		},
+		{
+			Method: http.MethodPost, Path: "/portainer/stacks/:stackId/import", Summary: "Import a portainer stack as a new app",
+			Status: http.StatusCreated, Request: apiImportStack{}, Response: apiApp{}, Handler: h.apiImportStack,
+		},
+	}
+	for _, action := range composeActions {
+		routes = append(routes, apiRoute{
+			Method: http.MethodPost, Path: "/apps/:id/compose/" + action.Name, Summary: action.Summary,
+			Request: composeRequest{}, Response: apiMessage{}, Handler: h.apiComposeAction(action),
+		})
+	}
+	return routes
+}
+
+// registerApi mounts the JSON API and its OpenAPI document on e.
+func (h *Handler) registerApi(e *echo.Echo) {
+	routes := h.apiRoutes()
+	api := e.Group(apiPrefix)
+	for _, route := range routes {
+		handler := route.Handler
+		if hasAppParam(route.Path) {
+			handler = h.apiLoadApp(handler)
+		}
+		api.Add(route.Method, route.Path, handler)
+	}
+
+	document := openApiDocument(apiPrefix, routes)
+	api.GET("/openapi.json", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, document)
+	})
+}
+
+func (h *Handler) apiLoadApp(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		id := c.Param("id")
+		if !h.apps.Exists(id) {
+			return apiFail(c, http.StatusNotFound, fmt.Sprintf("App not found: %s", id), nil)
+		}
+		app, err := h.apps.Get(id)
+		if err != nil {
+			return apiFail(c, http.StatusInternalServerError, "Failed to load app", nil)
+		}
+		c.Set("app", app)
+		return next(c)
+	}
+}
+
+func (h *Handler) apiApp(app *manager.App) apiApp {
+	vhosts := make([]apiVhost, 0)
+	for _, host := range h.vhosts(app) {
+		vhosts = append(vhosts, apiVhost{host.NginxBlock, host.Names})
+	}
+	return apiApp{
+		ID:          app.ID,
+		Path:        app.Path,
+		NginxStatus: h.nginx.Status(app.ID),
+		PortainerId: app.PortainerId,
+		AppYaml:     app.AppYaml,
+		Vhosts:      vhosts,
+		RawAppYaml:  string(app.RawAppYaml),
+		ComposeFile: string(app.ComposeFile),
+		EnvFile:     string(app.EnvFile),
+	}
+}
+
+func (h *Handler) apiReloadApp(c echo.Context, id string) error {
+	app, err := h.apps.Get(id)
+	if err != nil {
+		return apiFail(c, http.StatusInternalServerError, "Failed to load app", nil)
+	}
+	return c.JSON(http.StatusOK, h.apiApp(app))
+}
+
+func apiNginxFailure(c echo.Context, err error, message string) error {
+	testErr := new(nginx.TestError)
+	if errors.As(err, &testErr) {
+		return apiFail(c, http.StatusUnprocessableEntity, fmt.Sprintf("%s: %s", message, testErr), testErr.Messages)
+	}
+	return apiFail(c, errorStatus(err, http.StatusInternalServerError), fmt.Sprintf("%s: %s", message, err), nil)
+}
+
+func (h *Handler) apiListApps(c echo.Context) error {
+	ids, err := h.apps.List()
+	if err != nil {
+		c.Logger().Error("Failed to get stacks", "error", err)
+		return apiFail(c, http.StatusInternalServerError, "Failed to list apps", nil)
+	}
+	apps := make([]apiAppSummary, 0, len(ids))
+	for _, id := range ids {
+		summary := apiAppSummary{ID: id, NginxStatus: h.nginx.Status(id)}
+		if app, err := h.apps.Get(id); err == nil {
+			summary.PortainerId = app.PortainerId
+		}
+		apps = append(apps, summary)
+	}
+	return c.JSON(http.StatusOK, apps)
+}
+
+func (h *Handler) apiCreateApp(c echo.Context) error {
+	req := new(apiCreateApp)
+	if err := c.Bind(req); err != nil {
+		return apiFail(c, http.StatusBadRequest, "Invalid request body", nil)
+	}
+	appConfig, err := newAppConfig(req.Name, req.Host, req.Port)
+	if err != nil {
+		return apiFail(c, http.StatusBadRequest, err.Error(), nil)
+	}
+
+	err = h.apps.Create(req.Name, appConfig)
+	if errors.Is(err, manager.ErrAppExists) {
+		return apiFail(c, http.StatusConflict, fmt.Sprintf("App %s already exists", req.Name), nil)
+	}
+	if err != nil {
+		c.Logger().Error("Failed to create app", "error", err)
+		return apiFail(c, http.StatusInternalServerError, "Could not create app", nil)
+	}
+
+	app, err := h.apps.Get(req.Name)
+	if err != nil {
+		return apiFail(c, http.StatusInternalServerError, "Failed to load app", nil)
+	}
+	c.Response().Header().Set(echo.HeaderLocation, fmt.Sprintf("%s/apps/%s", apiPrefix, req.Name))
+	return c.JSON(http.StatusCreated, h.apiApp(app))
+}
+
+func (h *Handler) apiGetApp(c echo.Context) error {
+	app := c.Get("app").(*manager.App)
+	return c.JSON(http.StatusOK, h.apiApp(app))
+}
+
+func (h *Handler) apiDeleteApp(c echo.Context) error {
+	app := c.Get("app").(*manager.App)
+	req := new(apiDeleteApp)
+	if err := c.Bind(req); err != nil {
+		return apiFail(c, http.StatusBadRequest, "Invalid request body", nil)
+	}
+
+	opts := []manager.DeleteFn{manager.WithUnitRemover(h.nginx)}
+	if req.ComposeDown {
+		opts = append(opts, manager.WithComposeDown(h.compose, compose.DownOptions{RemoveOrphans: true}))
+	}
+	if req.PortainerDelete {
+		opts = append(opts, manager.WithStackDelete(stackDeleter{h.portainer}))
+	}
+	ctx, cancel := h.requestContext(c)
+	defer cancel()
+	report, err := h.apps.Delete(ctx, app.ID, opts...)
+	if err != nil {
+		c.Logger().Error("Failed to delete app", "app", app.ID, "error", err)
+		return apiFail(c, errorStatus(err, http.StatusInternalServerError), fmt.Sprintf("Failed to delete app: %s", err), report)
+	}
+	return c.JSON(http.StatusOK, report)
+}
+
+func (h *Handler) apiUpdateConfig(c echo.Context) error {
+	app := c.Get("app").(*manager.App)
+	req := new(apiContent)
+	if err := c.Bind(req); err != nil {
+		return apiFail(c, http.StatusBadRequest, "Invalid request body", nil)
+	}
+	if _, err := config.NewFromBytes([]byte(req.Content)); err != nil {
+		return apiFail(c, http.StatusBadRequest, fmt.Sprintf("Invalid app.yml: %s", err), nil)
+	}
+
+	conflicts, err := h.apps.Update(app.ID, []byte(req.Content))
+	conflictErr := new(generate.ConflictError)
+	if errors.As(err, &conflictErr) {
+		return apiFail(c, http.StatusUnprocessableEntity, "Env keys are defined by more than one source",
+			apiEnvConflicts(conflictErr.Conflicts))
+	}
+	if err != nil {
+		c.Logger().Error("Could not update yaml", "error", err)
+		return apiFail(c, http.StatusInternalServerError, fmt.Sprintf("Could not update app: %s", err), nil)
+	}
+	updated, err := h.apps.Get(app.ID)
+	if err != nil {
+		return apiFail(c, http.StatusInternalServerError, "Failed to load app", nil)
+	}
+	return c.JSON(http.StatusOK, apiConfigUpdate{h.apiApp(updated), apiEnvConflicts(conflicts)})
+}
+
+func (h *Handler) apiUpdateCompose(c echo.Context) error {
+	app := c.Get("app").(*manager.App)
+	req := new(apiContent)
+	if err := c.Bind(req); err != nil {
+		return apiFail(c, http.StatusBadRequest, "Invalid request body", nil)
+	}
+	iface := make(map[string]any)
+	if err := yaml.Unmarshal([]byte(req.Content), &iface); err != nil {
+		return apiFail(c, http.StatusBadRequest, fmt.Sprintf("Invalid docker-compose.yml: %s", err), nil)
+	}
+
+	if err := h.apps.UpdateCompose(app.ID, []byte(req.Content)); err != nil {
+		c.Logger().Error("Could not update yaml", "error", err)
+		return apiFail(c, http.StatusInternalServerError, "Could not update compose file", nil)
+	}
+	return h.apiReloadApp(c, app.ID)
+}
+
+func (h *Handler) apiComposeUp(c echo.Context) error {
+	app := c.Get("app").(*manager.App)
+	ctx, cancel := h.requestContext(c)
+	defer cancel()
+	if err := h.compose.Up(ctx, app.Path); err != nil {
+		return apiFail(c, errorStatus(err, http.StatusInternalServerError), "Could not update stack", composeStderr(err))
+	}
+	return c.JSON(http.StatusOK, apiMessage{"Restarted the containers"})
+}
+
+func (h *Handler) apiNginxEnable(c echo.Context) error {
+	app := c.Get("app").(*manager.App)
+	if app.AppYaml == nil {
+		return apiFail(c, http.StatusConflict, "App has no valid app.yml", nil)
+	}
+	ctx, cancel := h.requestContext(c)
+	defer cancel()
+	if err := h.nginx.CreateAndInstallUnits(ctx, app.ID, app.AppYaml.Nginx); err != nil {
+		return apiNginxFailure(c, err, "Failed to create unit")
+	}
+	return c.JSON(http.StatusOK, h.apiApp(app))
+}
+
+func (h *Handler) apiNginxDisable(c echo.Context) error {
+	app := c.Get("app").(*manager.App)
+	err := h.nginx.RemoveUnit(app.ID)
+	if errors.Is(err, nginx.ErrUnitNotFound) {
+		return apiFail(c, http.StatusNotFound, "Unit not found", nil)
+	}
+	if err != nil {
+		return apiFail(c, http.StatusInternalServerError, fmt.Sprintf("Failed to remove unit: %s", err), nil)
+	}
+	ctx, cancel := h.requestContext(c)
+	defer cancel()
+	if err := h.nginx.Reload(ctx); err != nil {
+		return apiNginxFailure(c, err, "Removed unit but failed to reload nginx")
+	}
+	return c.JSON(http.StatusOK, h.apiApp(app))
+}
+
+func (h *Handler) apiNginxReload(c echo.Context) error {
+	ctx, cancel := h.requestContext(c)
+	defer cancel()
+	if err := h.nginx.Reload(ctx); err != nil {
+		return apiNginxFailure(c, err, "Failed to reload nginx")
+	}
+	return c.JSON(http.StatusOK, apiMessage{"Reloaded nginx"})
+}
+
+// apiPublish holds the options for a publish. Plan, the digest of a plan from
+// the plan endpoint, is required and makes the publish fail if the app or its
+// stack changed since.
+type apiPublish struct {
+	portainer.DeployOptions
+	Plan string `json:"plan"`
+}
+
+func (h *Handler) apiPortainerPublish(c echo.Context) error {
+	app := c.Get("app").(*manager.App)
+	req := new(apiPublish)
+	if err := c.Bind(req); err != nil {
+		return apiFail(c, http.StatusBadRequest, "Invalid request body", nil)
+	}
+	if req.Plan == "" {
+		return apiFail(c, http.StatusBadRequest, "Preview the changes before publishing", nil)
+	}
+	ctx, cancel := h.requestContext(c)
+	defer cancel()
+	res, err := manager.PublishPlanned(ctx, h.portainer, app, req.Plan, req.DeployOptions)
+	if err != nil {
+		status, message := stackFailure(err)
+		return apiFail(c, status, fmt.Sprintf("Portainer update failed: %s", message), nil)
+	}
+	return c.JSON(http.StatusOK, res)
+}
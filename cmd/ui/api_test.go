@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/mr55p-dev/app-utils/lib/runner/runnertest"
+)
+
+const testAppYaml = `app: demo
+nginx:
+  - externalhost: web
+    ipv4: 10.0.0.2
+    port: 8080
+`
+
+func jsonRequest(method, target, body string) *http.Request {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	return req
+}
+
+// apiContentBody encodes content as the body of a config or compose update.
+func apiContentBody(t *testing.T, content string) string {
+	t.Helper()
+	body, err := json.Marshal(apiContent{content})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+// addNginxApp adds the app demo with an nginx block for the host web.
+func (s *testServer) addNginxApp(t *testing.T) string {
+	t.Helper()
+	dir := s.addApp(t, "demo", "")
+	if err := os.WriteFile(filepath.Join(dir, "app.yml"), []byte(testAppYaml), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestApiApps(t *testing.T) {
+	srv := newTestServer(t)
+	srv.addNginxApp(t)
+
+	rec := srv.do(httptest.NewRequest(http.MethodGet, "/api/v1/apps", nil))
+	var apps []apiAppSummary
+	if err := json.Unmarshal(rec.Body.Bytes(), &apps); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("Got status %d and %s: %v", rec.Code, rec.Body, err)
+	}
+	if want := []apiAppSummary{{ID: "demo", NginxStatus: "Disabled"}}; !reflect.DeepEqual(apps, want) {
+		t.Errorf("Got apps %+v, want %+v", apps, want)
+	}
+
+	rec = srv.do(httptest.NewRequest(http.MethodGet, "/api/v1/apps/demo", nil))
+	app := new(apiApp)
+	if err := json.Unmarshal(rec.Body.Bytes(), app); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("Got status %d and %s: %v", rec.Code, rec.Body, err)
+	}
+	if app.ID != "demo" || app.ComposeFile != testCompose || app.RawAppYaml != testAppYaml {
+		t.Errorf("Got app %+v", app)
+	}
+
+	rec = srv.do(httptest.NewRequest(http.MethodGet, "/api/v1/apps/missing", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Got status %d for an unknown app, want 404", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), `"error":"App not found: missing"`) {
+		t.Errorf("Got body %s", rec.Body)
+	}
+}
+
+func TestApiUpdates(t *testing.T) {
+	srv := newTestServer(t)
+	dir := srv.addNginxApp(t)
+
+	conflicting := testAppYaml + "runtime:\n  env-conflicts: error\n  env:\n    CFG_IPV4_WEB: 10.0.0.3\n"
+	changedCompose := testCompose + "    restart: always\n"
+	tests := []struct {
+		name       string
+		target     string
+		body       string
+		wantStatus int
+	}{
+		{"config", "/api/v1/apps/demo/config", apiContentBody(t, testAppYaml+"runtime:\n  env:\n    A: \"1\"\n"), http.StatusOK},
+		{"config bad body", "/api/v1/apps/demo/config", "{", http.StatusBadRequest},
+		{"config bad yaml", "/api/v1/apps/demo/config", apiContentBody(t, "nginx: ["), http.StatusBadRequest},
+		{"config env conflict", "/api/v1/apps/demo/config", apiContentBody(t, conflicting), http.StatusUnprocessableEntity},
+		{"config unknown app", "/api/v1/apps/missing/config", apiContentBody(t, testAppYaml), http.StatusNotFound},
+		{"compose", "/api/v1/apps/demo/compose", apiContentBody(t, changedCompose), http.StatusOK},
+		{"compose bad body", "/api/v1/apps/demo/compose", `{"content":1}`, http.StatusBadRequest},
+		{"compose bad yaml", "/api/v1/apps/demo/compose", apiContentBody(t, "services: ["), http.StatusBadRequest},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := srv.do(jsonRequest(http.MethodPut, tc.target, tc.body))
+			if rec.Code != tc.wantStatus {
+				t.Errorf("Got status %d, want %d: %s", rec.Code, tc.wantStatus, rec.Body)
+			}
+		})
+	}
+
+	if data, _ := os.ReadFile(filepath.Join(dir, "stack.env")); !strings.Contains(string(data), "A=1\n") {
+		t.Errorf("stack.env was not regenerated from the config update:\n%s", data)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "app.yml")); string(data) == conflicting {
+		t.Error("app.yml was written despite the env conflict")
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "docker-compose.yml")); string(data) != changedCompose {
+		t.Errorf("Got docker-compose.yml %q", data)
+	}
+}
+
+func TestApiCreateApp(t *testing.T) {
+	srv := newTestServer(t)
+
+	rec := srv.do(jsonRequest(http.MethodPost, "/api/v1/apps", `{"name":"demo","host":"10.0.0.2","port":8080}`))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Got status %d: %s", rec.Code, rec.Body)
+	}
+	rec = srv.do(jsonRequest(http.MethodPost, "/api/v1/apps", `{"name":"demo","host":"10.0.0.2","port":8080}`))
+	if rec.Code != http.StatusConflict {
+		t.Errorf("Got status %d creating an existing app, want 409", rec.Code)
+	}
+	rec = srv.do(jsonRequest(http.MethodPost, "/api/v1/apps", `{"name":`))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Got status %d for a bad body, want 400", rec.Code)
+	}
+}
+
+func TestApiNginx(t *testing.T) {
+	srv := newTestServer(t)
+	srv.addNginxApp(t)
+	unit := filepath.Join(srv.nginx, "demo.gold.nginx.conf")
+
+	rec := srv.do(httptest.NewRequest(http.MethodPost, "/api/v1/apps/demo/nginx/enable", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Got status %d enabling: %s", rec.Code, rec.Body)
+	}
+	if _, err := os.Stat(unit); err != nil {
+		t.Errorf("Unit was not installed: %s", err)
+	}
+
+	rec = srv.do(httptest.NewRequest(http.MethodPost, "/api/v1/apps/demo/nginx/disable", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Got status %d disabling: %s", rec.Code, rec.Body)
+	}
+	if _, err := os.Stat(unit); !os.IsNotExist(err) {
+		t.Errorf("Unit was not removed: %v", err)
+	}
+	want := []string{"nginx -t", "nginx -s reload", "nginx -t", "nginx -s reload"}
+	if got := srv.runner.Commands(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Got commands %v, want %v", got, want)
+	}
+
+	rec = srv.do(httptest.NewRequest(http.MethodPost, "/api/v1/apps/demo/nginx/disable", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Got status %d disabling a missing unit, want 404", rec.Code)
+	}
+	rec = srv.do(httptest.NewRequest(http.MethodPost, "/api/v1/nginx/reload", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Got status %d reloading: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestApiNginxDisableReloadFailure(t *testing.T) {
+	srv := newTestServer(t)
+	srv.addNginxApp(t)
+	if err := os.WriteFile(filepath.Join(srv.nginx, "demo.gold.nginx.conf"), []byte("server {}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	srv.runner.On("nginx -t", runnertest.Response{
+		Stderr: "nginx: [emerg] unknown directive \"proxy_pas\" in /etc/nginx/sites-enabled/other.gold.nginx.conf:14\n",
+		Err:    runnertest.ErrExit,
+	})
+
+	rec := srv.do(httptest.NewRequest(http.MethodPost, "/api/v1/apps/demo/nginx/disable", nil))
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Got status %d, want 422: %s", rec.Code, rec.Body)
+	}
+	if !strings.Contains(rec.Body.String(), "proxy_pas") {
+		t.Errorf("Response does not describe the nginx error: %s", rec.Body)
+	}
+}
+
+func TestApiComposeUp(t *testing.T) {
+	srv := newTestServer(t)
+	srv.addApp(t, "demo", "")
+
+	rec := srv.do(httptest.NewRequest(http.MethodPost, "/api/v1/apps/demo/compose/up", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Got status %d: %s", rec.Code, rec.Body)
+	}
+	if got := srv.runner.Commands(); len(got) != 1 || got[0] != "docker compose up -d" {
+		t.Errorf("Got commands %v, want compose up", got)
+	}
+
+	srv.runner.On("docker compose up", runnertest.Response{Stderr: "no such image", Err: runnertest.ErrExit})
+	rec = srv.do(httptest.NewRequest(http.MethodPost, "/api/v1/apps/demo/compose/up", nil))
+	if rec.Code != http.StatusInternalServerError || !strings.Contains(rec.Body.String(), "no such image") {
+		t.Errorf("Got status %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestOpenApiDocumentsRoutes(t *testing.T) {
+	srv := newTestServer(t)
+	rec := srv.do(httptest.NewRequest(http.MethodGet, "/api/v1/openapi.json", nil))
+	var document struct {
+		Paths map[string]map[string]any `json:"paths"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &document); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("Got status %d and %s: %v", rec.Code, rec.Body, err)
+	}
+
+	documented := 0
+	for _, route := range srv.Routes() {
+		if !strings.HasPrefix(route.Path, apiPrefix+"/") || route.Path == apiPrefix+"/openapi.json" {
+			continue
+		}
+		segments := strings.Split(route.Path, "/")
+		for i, segment := range segments {
+			if name, ok := strings.CutPrefix(segment, ":"); ok {
+				segments[i] = "{" + name + "}"
+			}
+		}
+		path := strings.Join(segments, "/")
+		if _, ok := document.Paths[path][strings.ToLower(route.Method)]; !ok {
+			t.Errorf("%s %s is not in the OpenAPI document", route.Method, path)
+		}
+		documented++
+	}
+	if want := len(new(Handler).apiRoutes()); documented != want {
+		t.Errorf("Found %d API routes, want %d", documented, want)
+	}
+}
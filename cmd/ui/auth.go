@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var errBadCredentials = errors.New("Invalid credentials")
+
+type authUser struct {
+	Name   string
+	Method string
+}
+
+// authenticator identifies the user making a request. It returns a nil user
+// when the request carries no credentials for this method, and an error when
+// credentials were presented but are not valid.
+type authenticator interface {
+	authenticate(c echo.Context) (*authUser, error)
+}
+
+// proxyAuth trusts a user header set by a forward-auth proxy, but only for
+// requests whose direct peer is one of the trusted proxy addresses.
+type proxyAuth struct {
+	trusted []*net.IPNet
+	headers []string
+}
+
+func newProxyAuth(cidrs, headers []string) (*proxyAuth, error) {
+	auth := &proxyAuth{headers: headers}
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			if strings.Contains(cidr, ":") {
+				cidr += "/128"
+			} else {
+				cidr += "/32"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid proxy address %s: %w", cidr, err)
+		}
+		auth.trusted = append(auth.trusted, ipNet)
+	}
+	return auth, nil
+}
+
+func (a *proxyAuth) authenticate(c echo.Context) (*authUser, error) {
+	host, _, err := net.SplitHostPort(c.Request().RemoteAddr)
+	if err != nil {
+		return nil, nil
+	}
+	ip := net.ParseIP(host)
+	trusted := false
+	for _, ipNet := range a.trusted {
+		if ip != nil && ipNet.Contains(ip) {
+			trusted = true
+			break
+		}
+	}
+	if !trusted {
+		return nil, nil
+	}
+	for _, header := range a.headers {
+		if user := c.Request().Header.Get(header); user != "" {
+			return &authUser{Name: user, Method: "proxy"}, nil
+		}
+	}
+	return nil, nil
+}
+
+// passwordAuth checks HTTP basic credentials against bcrypt hashes loaded
+// from an htpasswd style file of user:hash lines. Unknown users are checked
+// against a dummy hash of the same cost, so the time taken does not reveal
+// which users exist.
+type passwordAuth struct {
+	users map[string][]byte
+	dummy []byte
+}
+
+func readCredentialFile(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open %s: %w", path, err)
+	}
+	defer file.Close()
+
+	entries := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, secret, ok := strings.Cut(line, ":")
+		if !ok || name == "" || secret == "" {
+			return nil, fmt.Errorf("%s:%d: expected name:secret", path, lineNo)
+		}
+		entries[name] = secret
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Failed to read %s: %w", path, err)
+	}
+	return entries, nil
+}
+
+func newPasswordAuth(path string) (*passwordAuth, error) {
+	entries, err := readCredentialFile(path)
+	if err != nil {
+		return nil, err
+	}
+	auth := &passwordAuth{users: make(map[string][]byte)}
+	cost := bcrypt.MinCost
+	for user, hash := range entries {
+		userCost, err := bcrypt.Cost([]byte(hash))
+		if err != nil {
+			return nil, fmt.Errorf("Password for %s is not a bcrypt hash: %w", user, err)
+		}
+		cost = max(cost, userCost)
+		auth.users[user] = []byte(hash)
+	}
+	auth.dummy, err = bcrypt.GenerateFromPassword([]byte("dummy"), cost)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create dummy hash: %w", err)
+	}
+	return auth, nil
+}
+
+func (a *passwordAuth) authenticate(c echo.Context) (*authUser, error) {
+	user, password, ok := c.Request().BasicAuth()
+	if !ok {
+		return nil, nil
+	}
+	hash, known := a.users[user]
+	if !known {
+		hash = a.dummy
+	}
+	if err := bcrypt.CompareHashAndPassword(hash, []byte(password)); err != nil || !known {
+		return nil, errBadCredentials
+	}
+	return &authUser{Name: user, Method: "password"}, nil
+}
+
+// tokenAuth accepts bearer tokens for scripted access. Tokens are read from
+// a file of name:token lines and only their digests are kept in memory.
+type tokenAuth struct {
+	tokens map[[sha256.Size]byte]string
+}
+
+func newTokenAuth(path string) (*tokenAuth, error) {
+	entries, err := readCredentialFile(path)
+	if err != nil {
+		return nil, err
+	}
+	auth := &tokenAuth{tokens: make(map[[sha256.Size]byte]string)}
+	for name, token := range entries {
+		auth.tokens[sha256.Sum256([]byte(token))] = name
+	}
+	return auth, nil
+}
+
+func (a *tokenAuth) authenticate(c echo.Context) (*authUser, error) {
+	header := c.Request().Header.Get(echo.HeaderAuthorization)
+	token, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok {
+		return nil, nil
+	}
+	digest := sha256.Sum256([]byte(token))
+	for known, name := range a.tokens {
+		if subtle.ConstantTimeCompare(known[:], digest[:]) == 1 {
+			return &authUser{Name: name, Method: "token"}, nil
+		}
+	}
+	return nil, errBadCredentials
+}
+
+// authMiddleware requires every request, apart from those to the public
+// paths, to be identified by one of methods.
+func authMiddleware(methods []authenticator, public ...string) echo.MiddlewareFunc {
+	challenge := false
+	for _, method := range methods {
+		if _, ok := method.(*passwordAuth); ok {
+			challenge = true
+		}
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			for _, path := range public {
+				if c.Request().URL.Path == path {
+					return next(c)
+				}
+			}
+
+			for _, method := range methods {
+				user, err := method.authenticate(c)
+				if err != nil {
+					c.Logger().Warn("Rejected credentials", "path", c.Request().URL.Path, "error", err)
+					return unauthorized(c, challenge)
+				}
+				if user != nil {
+					c.Set("user", user)
+					return next(c)
+				}
+			}
+			return unauthorized(c, challenge)
+		}
+	}
+}
+
+func unauthorized(c echo.Context, challenge bool) error {
+	if challenge {
+		c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Basic realm="gold"`)
+	}
+	if strings.HasPrefix(c.Request().URL.Path, apiPrefix) {
+		return apiFail(c, http.StatusUnauthorized, "Authentication required", nil)
+	}
+	return c.String(http.StatusUnauthorized, "Authentication required")
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// writeCredentials writes lines to a file in a temporary directory.
+func writeCredentials(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "credentials")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func hashPassword(t *testing.T, password string, cost int) string {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), cost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(hash)
+}
+
+// newAuthServer serves the name and method of the authenticated user, with
+// proxy, token and password auth enabled.
+func newAuthServer(t *testing.T) *echo.Echo {
+	t.Helper()
+	proxy, err := newProxyAuth([]string{"10.0.0.0/8", "192.168.1.5"}, []string{"Remote-User"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := newTokenAuth(writeCredentials(t, "ci:secret-token\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	users, err := newPasswordAuth(writeCredentials(t, "admin:"+hashPassword(t, "hunter2", bcrypt.MinCost)+"\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := echo.New()
+	e.Use(authMiddleware([]authenticator{proxy, tokens, users}, "/healthz"))
+	whoami := func(c echo.Context) error {
+		user, _ := c.Get("user").(*authUser)
+		if user == nil {
+			return c.String(http.StatusOK, "anonymous")
+		}
+		return c.String(http.StatusOK, user.Name+" "+user.Method)
+	}
+	e.GET("/healthz", whoami)
+	e.GET("/whoami", whoami)
+	e.GET(apiPrefix+"/whoami", whoami)
+	return e
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	e := newAuthServer(t)
+	cases := []struct {
+		name       string
+		path       string
+		remoteAddr string
+		headers    map[string]string
+		basic      []string
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "password", basic: []string{"admin", "hunter2"}, wantStatus: http.StatusOK, wantBody: "admin password"},
+		{name: "wrong password", basic: []string{"admin", "wrong"}, wantStatus: http.StatusUnauthorized},
+		{name: "unknown user", basic: []string{"nobody", "hunter2"}, wantStatus: http.StatusUnauthorized},
+		{
+			name:       "token",
+			headers:    map[string]string{"Authorization": "Bearer secret-token"},
+			wantStatus: http.StatusOK, wantBody: "ci token",
+		},
+		{
+			name:       "wrong token",
+			headers:    map[string]string{"Authorization": "Bearer guessed"},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "trusted proxy",
+			remoteAddr: "10.1.2.3:4000",
+			headers:    map[string]string{"Remote-User": "alice"},
+			wantStatus: http.StatusOK, wantBody: "alice proxy",
+		},
+		{
+			name:       "trusted proxy address",
+			remoteAddr: "192.168.1.5:4000",
+			headers:    map[string]string{"Remote-User": "alice"},
+			wantStatus: http.StatusOK, wantBody: "alice proxy",
+		},
+		{
+			name:       "trusted proxy without user",
+			remoteAddr: "10.1.2.3:4000",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "untrusted peer",
+			remoteAddr: "192.168.1.6:4000",
+			headers:    map[string]string{"Remote-User": "alice"},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "spoofed forwarded headers",
+			remoteAddr: "203.0.113.7:4000",
+			headers: map[string]string{
+				"Remote-User":     "alice",
+				"X-Forwarded-For": "10.1.2.3",
+				"X-Real-IP":       "10.1.2.3",
+				"Forwarded":       "for=10.1.2.3",
+			},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{name: "no credentials", wantStatus: http.StatusUnauthorized},
+		{name: "public path", path: "/healthz", wantStatus: http.StatusOK, wantBody: "anonymous"},
+		{name: "api", path: apiPrefix + "/whoami", wantStatus: http.StatusUnauthorized, wantBody: `{"error":"Authentication required"}` + "\n"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := tc.path
+			if path == "" {
+				path = "/whoami"
+			}
+			req := httptest.NewRequest(http.MethodGet, path, nil)
+			if tc.remoteAddr != "" {
+				req.RemoteAddr = tc.remoteAddr
+			}
+			for name, value := range tc.headers {
+				req.Header.Set(name, value)
+			}
+			if tc.basic != nil {
+				req.SetBasicAuth(tc.basic[0], tc.basic[1])
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			if rec.Code != tc.wantStatus {
+				t.Errorf("Got status %d, want %d", rec.Code, tc.wantStatus)
+			}
+			if tc.wantBody != "" && rec.Body.String() != tc.wantBody {
+				t.Errorf("Got body %q, want %q", rec.Body, tc.wantBody)
+			}
+			if rec.Code == http.StatusUnauthorized && rec.Header().Get(echo.HeaderWWWAuthenticate) == "" {
+				t.Error("No basic auth challenge sent")
+			}
+		})
+	}
+}
+
+func TestPasswordAuthDummyHash(t *testing.T) {
+	cost := bcrypt.MinCost + 1
+	auth, err := newPasswordAuth(writeCredentials(t, "admin:"+hashPassword(t, "hunter2", cost)+"\n"))
+	if err != nil {
+		t.Fatalf("newPasswordAuth returned error: %s", err)
+	}
+	if got, err := bcrypt.Cost(auth.dummy); err != nil || got != cost {
+		t.Errorf("Got dummy hash cost %d, %v, want %d", got, err, cost)
+	}
+	if _, err := newPasswordAuth(writeCredentials(t, "admin:plaintext\n")); err == nil {
+		t.Error("Expected an error for a password that is not hashed")
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// statusClientClosedRequest is the non-standard status nginx logs when the
+// client goes away before a response is sent.
+const statusClientClosedRequest = 499
+
+// requestContext returns the request's context bounded by the configured
+// timeout. It is cancelled when the client disconnects, which stops any
+// compose or nginx command and portainer request started with it.
+func (h *Handler) requestContext(c echo.Context) (context.Context, context.CancelFunc) {
+	ctx := c.Request().Context()
+	if h.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, h.timeout)
+}
+
+// errorStatus returns the status for an operation that failed with err, so
+// timeouts and cancellations are not reported as failures of the operation
+// itself.
+func errorStatus(err error, fallback int) int {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, context.Canceled):
+		return statusClientClosedRequest
+	}
+	return fallback
+}
+
+// contextFailure describes err if it was caused by the request timing out or
+// being cancelled, and returns an empty string otherwise.
+func contextFailure(err error) string {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return "Timed out waiting for the operation to finish"
+	case errors.Is(err, context.Canceled):
+		return "Operation was cancelled"
+	}
+	return ""
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// newCsrfServer serves a form and a handler it posts to behind the auth,
+// origin and CSRF middleware, as main installs them.
+func newCsrfServer(t *testing.T) *echo.Echo {
+	t.Helper()
+	tokens, err := newTokenAuth(writeCredentials(t, "ci:secret-token\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	users, err := newPasswordAuth(writeCredentials(t, "admin:"+hashPassword(t, "hunter2", bcrypt.MinCost)+"\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := echo.New()
+	e.Use(
+		authMiddleware([]authenticator{tokens, users}),
+		originMiddleware([]string{"https://admin.example.com"}),
+		csrfMiddleware(false),
+	)
+	e.GET("/form", func(c echo.Context) error {
+		return c.String(http.StatusOK, c.Get(middleware.DefaultCSRFConfig.ContextKey).(string))
+	})
+	submit := func(c echo.Context) error {
+		return c.String(http.StatusOK, "submitted")
+	}
+	e.POST("/submit", submit)
+	e.POST(apiPrefix+"/submit", submit)
+	return e
+}
+
+// csrfToken loads the form as admin and returns the cookie and the token it
+// carries.
+func csrfToken(t *testing.T, e *echo.Echo) (*http.Cookie, string) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/form", nil)
+	req.SetBasicAuth("admin", "hunter2")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == "_csrf" {
+			return cookie, rec.Body.String()
+		}
+	}
+	t.Fatalf("No CSRF cookie set by %d response", rec.Code)
+	return nil, ""
+}
+
+func TestCsrfMiddleware(t *testing.T) {
+	e := newCsrfServer(t)
+	cookie, token := csrfToken(t, e)
+
+	cases := []struct {
+		name       string
+		path       string
+		form       url.Values
+		headers    map[string]string
+		basic      bool
+		wantStatus int
+	}{
+		{name: "form without token", basic: true, wantStatus: http.StatusForbidden},
+		{name: "form with wrong token", basic: true, form: url.Values{"_csrf": {"forged"}}, wantStatus: http.StatusForbidden},
+		{name: "form with token", basic: true, form: url.Values{"_csrf": {token}}, wantStatus: http.StatusOK},
+		{
+			name:       "htmx with token",
+			basic:      true,
+			headers:    map[string]string{"HX-Request": "true", csrfHeader: token},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "same origin",
+			basic:      true,
+			form:       url.Values{"_csrf": {token}},
+			headers:    map[string]string{"Origin": "http://example.com"},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "allowed origin",
+			basic:      true,
+			form:       url.Values{"_csrf": {token}},
+			headers:    map[string]string{"Origin": "https://admin.example.com"},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "bad origin",
+			basic:      true,
+			form:       url.Values{"_csrf": {token}},
+			headers:    map[string]string{"Origin": "https://evil.example.net"},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "bad referer",
+			basic:      true,
+			form:       url.Values{"_csrf": {token}},
+			headers:    map[string]string{"Referer": "https://evil.example.net/page"},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "opaque origin",
+			basic:      true,
+			form:       url.Values{"_csrf": {token}},
+			headers:    map[string]string{"Origin": "null"},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "bearer token",
+			headers:    map[string]string{"Authorization": "Bearer secret-token", "Origin": "https://evil.example.net"},
+			wantStatus: http.StatusOK,
+		},
+		{name: "api with basic auth", path: apiPrefix + "/submit", basic: true, wantStatus: http.StatusForbidden},
+		{
+			name:       "api with bearer token",
+			path:       apiPrefix + "/submit",
+			headers:    map[string]string{"Authorization": "Bearer secret-token"},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "api with invalid bearer token",
+			path:       apiPrefix + "/submit",
+			headers:    map[string]string{"Authorization": "Bearer guessed"},
+			wantStatus: http.StatusUnauthorized,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := tc.path
+			if path == "" {
+				path = "/submit"
+			}
+			req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(tc.form.Encode()))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+			for name, value := range tc.headers {
+				req.Header.Set(name, value)
+			}
+			if tc.basic {
+				req.SetBasicAuth("admin", "hunter2")
+				req.AddCookie(cookie)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			if rec.Code != tc.wantStatus {
+				t.Errorf("Got status %d, want %d: %s", rec.Code, tc.wantStatus, rec.Body)
+			}
+		})
+	}
+}
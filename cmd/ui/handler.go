@@ -1,10 +1,18 @@
 package main
 
 import (
+	"errors"
+	"fmt"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/labstack/echo/v4"
+	"github.com/mr55p-dev/app-utils/config"
 	"github.com/mr55p-dev/app-utils/lib/compose"
+	"github.com/mr55p-dev/app-utils/lib/generate"
 	"github.com/mr55p-dev/app-utils/lib/manager"
 	"github.com/mr55p-dev/app-utils/lib/nginx"
 	"github.com/mr55p-dev/app-utils/lib/portainer"
@@ -16,6 +24,9 @@ type Handler struct {
 	compose   *compose.Client
 	nginx     *nginx.Client
 	portainer *portainer.Client
+	// timeout bounds the commands and portainer requests made while
+	// handling a request.
+	timeout time.Duration
 }
 
 func (h *Handler) root(c echo.Context) error {
@@ -32,6 +43,68 @@ func (h *Handler) create(c echo.Context) error {
 	return c.Render(http.StatusOK, "create.html", nil)
 }
 
+func alert(c echo.Context, code int, kind, message string) error {
+	return c.Render(code, "alert.html", map[string]string{
+		"Type":    kind,
+		"Message": message,
+	})
+}
+
+// newAppConfig validates the details collected when creating an app and
+// returns the initial app.yml for it.
+func newAppConfig(name, host string, port int) (config.AppConfig, error) {
+	if !manager.ValidName(name) {
+		return config.AppConfig{}, errors.New("App name must be lowercase letters, digits, '-' or '_'")
+	}
+	if ip := net.ParseIP(host); ip == nil || ip.To4() == nil {
+		return config.AppConfig{}, errors.New("Host must be an IPv4 address")
+	}
+	if port < 1 || port > 65535 {
+		return config.AppConfig{}, errors.New("Port must be a number between 1 and 65535")
+	}
+	return config.AppConfig{
+		App: name,
+		Nginx: []config.NginxBlock{{
+			ExternalHost: name,
+			Protocol:     "http",
+			IPv4:         host,
+			Port:         port,
+		}},
+	}, nil
+}
+
+func (h *Handler) createApp(c echo.Context) error {
+	name := c.FormValue("appName")
+	if name == "" {
+		name = c.Param("id")
+	}
+	port, err := strconv.Atoi(c.FormValue("port"))
+	if err != nil {
+		port = 0
+	}
+	appConfig, err := newAppConfig(name, c.FormValue("host"), port)
+	if err != nil {
+		return alert(c, http.StatusBadRequest, "bad", err.Error())
+	}
+
+	err = h.apps.Create(name, appConfig)
+	if errors.Is(err, manager.ErrAppExists) {
+		return alert(c, http.StatusConflict, "bad", fmt.Sprintf("App %s already exists", name))
+	}
+	if err != nil {
+		c.Logger().Error("Failed to create app", "error", err)
+		return alert(c, http.StatusInternalServerError, "bad", "Could not create app")
+	}
+	c.Logger().Info("Created app", "app", name)
+
+	target := fmt.Sprintf("/app/%s", name)
+	if c.Request().Header.Get("HX-Request") != "" {
+		c.Response().Header().Set("HX-Redirect", target)
+		return c.NoContent(http.StatusCreated)
+	}
+	return c.Redirect(http.StatusSeeOther, target)
+}
+
 func (h *Handler) extensions(c echo.Context) error {
 	extensions, err := h.apps.Extensions()
 	if err != nil {
@@ -56,15 +129,37 @@ func (h *Handler) extensions(c echo.Context) error {
 	return c.Render(http.StatusOK, "extensions.html", vals)
 }
 
+type vhost struct {
+	config.NginxBlock
+	Names []string
+}
+
+func (h *Handler) vhosts(app *manager.App) []vhost {
+	hosts := make([]vhost, 0)
+	if app.AppYaml == nil {
+		return hosts
+	}
+	for _, block := range app.AppYaml.Nginx {
+		hosts = append(hosts, vhost{
+			NginxBlock: block,
+			Names:      block.ServerNames(h.nginx.Domains()),
+		})
+	}
+	return hosts
+}
+
 func (h *Handler) viewApp(c echo.Context) error {
 	app := c.Get("app").(*manager.App)
 	return c.Render(http.StatusOK, "app.html", map[string]any{
 		"Name":           app.ID,
+		"Vhosts":         h.vhosts(app),
+		"Services":       composeServices(app.ComposeFile),
 		"Path":           app.Path,
 		"AppYaml":        app.AppYaml,
 		"RawAppYaml":     string(app.RawAppYaml),
 		"RawComposeYaml": string(app.ComposeFile),
 		"PortainerId":    app.PortainerId,
+		"GitStack":       app.AppYaml != nil && app.AppYaml.Portainer.UsesGit(),
 		"NginxStatus":    h.nginx.Status(app.ID),
 	})
 }
@@ -79,17 +174,41 @@ func (h *Handler) configApp(c echo.Context) error {
 		return c.String(http.StatusOK, "Failed to update resource: invalid yaml")
 	}
 
-	err = h.apps.Update(app.ID, appYaml)
+	conflicts, err := h.apps.Update(app.ID, appYaml)
+	conflictErr := new(generate.ConflictError)
+	if errors.As(err, &conflictErr) {
+		return c.Render(http.StatusUnprocessableEntity, "alert.html", map[string]string{
+			"Type":    "bad",
+			"Message": "Not saved, env keys are defined by more than one source",
+			"Details": describeConflicts(conflictErr.Conflicts),
+		})
+	}
 	if err != nil {
 		c.Logger().Debug("Could not update yaml", err)
 		return c.String(http.StatusInternalServerError, "Could not update app")
 	}
-	c.Logger().Info("Updated yaml content", "app")
+	c.Logger().Info("Updated yaml content", "app", app.ID)
+	if len(conflicts) > 0 {
+		return c.Render(http.StatusOK, "alert.html", map[string]string{
+			"Type":    "warn",
+			"Message": "Updated app.yml, some env keys are overridden",
+			"Details": describeConflicts(conflicts),
+		})
+	}
 	return c.Render(http.StatusOK, "alert.html", map[string]string{
 		"Message": "Succesfully updated app.yml",
 	})
 }
 
+// describeConflicts lists env key overrides one per line.
+func describeConflicts(conflicts []generate.EnvValue) string {
+	lines := make([]string, len(conflicts))
+	for i, conflict := range conflicts {
+		lines[i] = conflict.Conflict()
+	}
+	return strings.Join(lines, "\n")
+}
+
 func (h *Handler) configCompose(c echo.Context) error {
 	app := c.Get("app").(*manager.App)
 	composeYaml := []byte(c.FormValue("compose"))
@@ -114,11 +233,17 @@ func (h *Handler) configCompose(c echo.Context) error {
 func (h *Handler) composeRestart(c echo.Context) error {
 	app := c.Get("app").(*manager.App)
 	c.Logger().Info("Restarting docker compose")
-	err := h.compose.Up(app.Path)
+	ctx, cancel := h.requestContext(c)
+	defer cancel()
+	err := h.compose.Up(ctx, app.Path)
 	if err != nil {
 		c.Logger().Debug("Could not update stack", err)
-		return c.String(http.StatusOK, "Could not update stack")
+		return c.Render(errorStatus(err, http.StatusInternalServerError), "alert.html", map[string]string{
+			"Type":    "bad",
+			"Message": "Could not update stack",
+			"Details": composeStderr(err),
+		})
 	}
 
-	return c.String(http.StatusOK, "Succesfully restarted the containers")
+	return alert(c, http.StatusOK, "ok", "Succesfully restarted the containers")
 }
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/mr55p-dev/app-utils/lib/compose"
+	"github.com/mr55p-dev/app-utils/lib/manager"
+)
+
+// composeRequest holds the options accepted by the compose lifecycle
+// endpoints. Options that do not apply to an action are ignored.
+type composeRequest struct {
+	Services      []string `json:"services"`
+	Timeout       int      `json:"timeout"`
+	RemoveOrphans bool     `json:"removeOrphans"`
+	Volumes       bool     `json:"volumes"`
+	NoCache       bool     `json:"noCache"`
+}
+
+type composeAction struct {
+	Name    string
+	Summary string
+	Run     func(ctx context.Context, cli *compose.Client, path string, req composeRequest) error
+}
+
+var composeActions = []composeAction{
+	{"down", "Stop and remove the containers", func(ctx context.Context, cli *compose.Client, path string, req composeRequest) error {
+		return cli.Down(ctx, path, compose.DownOptions{
+			RemoveOrphans: req.RemoveOrphans,
+			Volumes:       req.Volumes,
+			Timeout:       time.Duration(req.Timeout) * time.Second,
+		})
+	}},
+	{"stop", "Stop services", func(ctx context.Context, cli *compose.Client, path string, req composeRequest) error {
+		return cli.Stop(ctx, path, compose.StopOptions{
+			Services: req.Services,
+			Timeout:  time.Duration(req.Timeout) * time.Second,
+		})
+	}},
+	{"start", "Start services", func(ctx context.Context, cli *compose.Client, path string, req composeRequest) error {
+		return cli.Start(ctx, path, compose.StartOptions{Services: req.Services})
+	}},
+	{"restart", "Restart services", func(ctx context.Context, cli *compose.Client, path string, req composeRequest) error {
+		return cli.Restart(ctx, path, compose.RestartOptions{
+			Services: req.Services,
+			Timeout:  time.Duration(req.Timeout) * time.Second,
+		})
+	}},
+	{"pull", "Pull service images", func(ctx context.Context, cli *compose.Client, path string, req composeRequest) error {
+		return cli.Pull(ctx, path, compose.PullOptions{Services: req.Services})
+	}},
+	{"build", "Build service images", func(ctx context.Context, cli *compose.Client, path string, req composeRequest) error {
+		return cli.Build(ctx, path, compose.BuildOptions{Services: req.Services, NoCache: req.NoCache})
+	}},
+	{"rm", "Remove stopped containers", func(ctx context.Context, cli *compose.Client, path string, req composeRequest) error {
+		return cli.Rm(ctx, path, compose.RmOptions{Services: req.Services, Volumes: req.Volumes})
+	}},
+}
+
+func (h *Handler) containers(c echo.Context) error {
+	app := c.Get("app").(*manager.App)
+	ctx, cancel := h.requestContext(c)
+	defer cancel()
+	containers, err := h.compose.Ps(ctx, app.Path)
+	if err != nil {
+		c.Logger().Debug("Failed to list containers", "app", app.ID, "error", err)
+		return c.Render(http.StatusOK, "containersTable.html", map[string]any{
+			"Error": composeStderr(err),
+		})
+	}
+	return c.Render(http.StatusOK, "containersTable.html", map[string]any{
+		"Containers": containers,
+	})
+}
+
+func (h *Handler) apiContainers(c echo.Context) error {
+	app := c.Get("app").(*manager.App)
+	ctx, cancel := h.requestContext(c)
+	defer cancel()
+	containers, err := h.compose.Ps(ctx, app.Path)
+	if err != nil {
+		return apiFail(c, errorStatus(err, http.StatusInternalServerError), "Failed to list containers", composeStderr(err))
+	}
+	return c.JSON(http.StatusOK, containers)
+}
+
+func composeFormRequest(c echo.Context) composeRequest {
+	timeout, _ := strconv.Atoi(c.FormValue("timeout"))
+	return composeRequest{
+		Services:      strings.Fields(c.FormValue("services")),
+		Timeout:       timeout,
+		RemoveOrphans: c.FormValue("removeOrphans") != "",
+		Volumes:       c.FormValue("volumes") != "",
+		NoCache:       c.FormValue("noCache") != "",
+	}
+}
+
+func composeStderr(err error) string {
+	if message := contextFailure(err); message != "" {
+		return message
+	}
+	cmdErr := new(compose.CommandError)
+	if errors.As(err, &cmdErr) {
+		return cmdErr.Stderr
+	}
+	return err.Error()
+}
+
+func (h *Handler) composeAction(action composeAction) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		app := c.Get("app").(*manager.App)
+		c.Logger().Info("Running compose action", "app", app.ID, "action", action.Name)
+		ctx, cancel := h.requestContext(c)
+		defer cancel()
+		if err := action.Run(ctx, h.compose, app.Path, composeFormRequest(c)); err != nil {
+			c.Logger().Debug("Compose action failed", "action", action.Name, "error", err)
+			return c.Render(errorStatus(err, http.StatusInternalServerError), "alert.html", map[string]string{
+				"Type":    "bad",
+				"Message": fmt.Sprintf("docker compose %s failed", action.Name),
+				"Details": composeStderr(err),
+			})
+		}
+		return alert(c, http.StatusOK, "ok", fmt.Sprintf("docker compose %s completed", action.Name))
+	}
+}
+
+func (h *Handler) apiComposeAction(action composeAction) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		app := c.Get("app").(*manager.App)
+		req := new(composeRequest)
+		if err := c.Bind(req); err != nil {
+			return apiFail(c, http.StatusBadRequest, "Invalid request body", nil)
+		}
+		ctx, cancel := h.requestContext(c)
+		defer cancel()
+		if err := action.Run(ctx, h.compose, app.Path, *req); err != nil {
+			return apiFail(c, errorStatus(err, http.StatusInternalServerError),
+				fmt.Sprintf("docker compose %s failed", action.Name), composeStderr(err))
+		}
+		return c.JSON(http.StatusOK, apiMessage{fmt.Sprintf("docker compose %s completed", action.Name)})
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/mr55p-dev/app-utils/lib/compose"
+	"github.com/mr55p-dev/app-utils/lib/manager"
+)
+
+func (h *Handler) deletePage(c echo.Context) error {
+	apps, err := h.apps.List()
+	if err != nil {
+		c.Logger().Error("Failed to get stacks", "error", err)
+		return c.String(http.StatusInternalServerError, "Failed to get stacks")
+	}
+	archived, err := h.apps.Archived()
+	if err != nil {
+		c.Logger().Error("Failed to get archived apps", "error", err)
+		return c.String(http.StatusInternalServerError, "Failed to get archived apps")
+	}
+	return c.Render(http.StatusOK, "delete.html", map[string]any{
+		"Apps":     apps,
+		"Archived": archived,
+	})
+}
+
+func (h *Handler) deleteApp(c echo.Context) error {
+	app := c.Get("app").(*manager.App)
+
+	opts := []manager.DeleteFn{manager.WithUnitRemover(h.nginx)}
+	if c.FormValue("composeDown") != "" {
+		opts = append(opts, manager.WithComposeDown(h.compose, compose.DownOptions{RemoveOrphans: true}))
+	}
+	if c.FormValue("portainerDelete") != "" {
+		opts = append(opts, manager.WithStackDelete(stackDeleter{h.portainer}))
+	}
+
+	ctx, cancel := h.requestContext(c)
+	defer cancel()
+	report, err := h.apps.Delete(ctx, app.ID, opts...)
+	if errors.Is(err, manager.ErrAppNotFound) {
+		return alert(c, http.StatusNotFound, "bad", fmt.Sprintf("App %s not found", app.ID))
+	}
+	if err != nil {
+		c.Logger().Error("Failed to delete app", "app", app.ID, "error", err)
+		return c.Render(errorStatus(err, http.StatusInternalServerError), "deleteReport.html", map[string]any{
+			"Report": report,
+			"Error":  err.Error(),
+		})
+	}
+	c.Logger().Info("Deleted app", "app", app.ID, "archive", report.ArchivePath)
+	return c.Render(http.StatusOK, "deleteReport.html", map[string]any{
+		"Report": report,
+	})
+}
+
+func (h *Handler) restoreApp(c echo.Context) error {
+	name, err := h.apps.Restore(c.Param("id"))
+	switch {
+	case errors.Is(err, manager.ErrArchiveNotFound):
+		return alert(c, http.StatusNotFound, "bad", "Archived app not found")
+	case errors.Is(err, manager.ErrAppExists):
+		return alert(c, http.StatusConflict, "bad", fmt.Sprintf("App %s already exists", name))
+	case err != nil:
+		c.Logger().Error("Failed to restore app", "error", err)
+		return alert(c, http.StatusInternalServerError, "bad", "Could not restore app")
+	}
+	c.Logger().Info("Restored app", "app", name)
+	return alert(c, http.StatusOK, "ok", fmt.Sprintf("Restored %s", name))
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"github.com/mr55p-dev/app-utils/lib/manager"
+	"github.com/mr55p-dev/app-utils/lib/portainer"
+)
+
+// importableStack is a portainer stack and the app it was imported as, if
+// any.
+type importableStack struct {
+	stackInfo
+	App string `json:"app,omitempty"`
+}
+
+// importableStacks lists the stacks on every endpoint.
+func (h *Handler) importableStacks(c echo.Context) ([]importableStack, error) {
+	ctx, cancel := h.requestContext(c)
+	defer cancel()
+	stacks, err := h.portainer.OnEndpoint(0).ListStacks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	imported, err := h.apps.ImportedStacks()
+	if err != nil {
+		return nil, err
+	}
+	endpoints := h.endpointNames(c, ctx)
+	out := make([]importableStack, len(stacks))
+	for i, stack := range stacks {
+		out[i] = importableStack{newStackInfo(stack, endpoints), imported[stack.Id]}
+	}
+	return out, nil
+}
+
+func (h *Handler) importPage(c echo.Context) error {
+	stacks, err := h.importableStacks(c)
+	if err != nil {
+		c.Logger().Error("Failed to list portainer stacks", "error", err)
+		return c.Render(errorStatus(err, http.StatusOK), "import.html", map[string]any{
+			"Error": fmt.Sprintf("Failed to list portainer stacks: %s", err),
+		})
+	}
+	return c.Render(http.StatusOK, "import.html", map[string]any{
+		"Stacks": stacks,
+	})
+}
+
+// importStack imports the stack given by the stackId param as the app name.
+// On failure it returns the status to respond with and an error suitable for
+// showing to the user.
+func (h *Handler) importStack(c echo.Context, name string) (int, error) {
+	stackId, err := strconv.Atoi(c.Param("stackId"))
+	if err != nil {
+		return http.StatusBadRequest, errors.New("Invalid stack ID")
+	}
+	if !manager.ValidName(name) {
+		return http.StatusBadRequest, errors.New("App name must be lowercase letters, digits, '-' or '_'")
+	}
+
+	ctx, cancel := h.requestContext(c)
+	defer cancel()
+	err = h.apps.Import(ctx, name, stackId, h.portainer)
+	apiErr := new(portainer.APIError)
+	switch {
+	case errors.Is(err, manager.ErrAppExists):
+		return http.StatusConflict, fmt.Errorf("App %s already exists", name)
+	case errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound:
+		return http.StatusNotFound, fmt.Errorf("Stack %d not found", stackId)
+	case err != nil:
+		c.Logger().Error("Failed to import stack", "stack", stackId, "error", err)
+		return errorStatus(err, http.StatusBadGateway), fmt.Errorf("Could not import stack: %w", err)
+	}
+	c.Logger().Info("Imported stack", "stack", stackId, "app", name)
+	return http.StatusCreated, nil
+}
+
+func (h *Handler) importApp(c echo.Context) error {
+	name := c.FormValue("name")
+	if status, err := h.importStack(c, name); err != nil {
+		return alert(c, status, "bad", err.Error())
+	}
+
+	target := fmt.Sprintf("/app/%s", name)
+	if c.Request().Header.Get("HX-Request") != "" {
+		c.Response().Header().Set("HX-Redirect", target)
+		return c.NoContent(http.StatusCreated)
+	}
+	return c.Redirect(http.StatusSeeOther, target)
+}
+
+func (h *Handler) apiListStacks(c echo.Context) error {
+	stacks, err := h.importableStacks(c)
+	if err != nil {
+		return apiFail(c, errorStatus(err, http.StatusBadGateway), fmt.Sprintf("Failed to list stacks: %s", err), nil)
+	}
+	return c.JSON(http.StatusOK, stacks)
+}
+
+func (h *Handler) apiImportStack(c echo.Context) error {
+	req := new(apiImportStack)
+	if err := c.Bind(req); err != nil {
+		return apiFail(c, http.StatusBadRequest, "Invalid request body", nil)
+	}
+	if status, err := h.importStack(c, req.Name); err != nil {
+		return apiFail(c, status, err.Error(), nil)
+	}
+
+	app, err := h.apps.Get(req.Name)
+	if err != nil {
+		return apiFail(c, http.StatusInternalServerError, "Failed to load app", nil)
+	}
+	c.Response().Header().Set(echo.HeaderLocation, fmt.Sprintf("%s/apps/%s", apiPrefix, req.Name))
+	return c.JSON(http.StatusCreated, h.apiApp(app))
+}
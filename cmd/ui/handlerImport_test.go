@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mr55p-dev/app-utils/lib/portainer"
+)
+
+func TestImportApp(t *testing.T) {
+	srv := newTestServer(t)
+	stack := srv.portainer.AddStack("demo", testCompose, []portainer.EnvironmentVariable{{Name: "A", Value: "1"}})
+
+	target := fmt.Sprintf("/import/%d", stack.Id)
+	rec := srv.do(postForm(target, url.Values{"name": {"imported"}}))
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/app/imported" {
+		t.Fatalf("Got status %d redirecting to %q: %s", rec.Code, rec.Header().Get("Location"), rec.Body)
+	}
+	if id, err := portainer.GetStackId(filepath.Join(srv.apps, "imported")); err != nil || id != stack.Id {
+		t.Errorf("Got stack ID %d, %v, want %d", id, err, stack.Id)
+	}
+
+	rec = srv.do(postForm(target, url.Values{"name": {"imported"}}))
+	if rec.Code != http.StatusConflict {
+		t.Errorf("Got status %d importing over an existing app, want 409", rec.Code)
+	}
+	rec = srv.do(postForm(target, url.Values{"name": {"Not Valid"}}))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Got status %d for an invalid name, want 400", rec.Code)
+	}
+	rec = srv.do(postForm("/import/999", url.Values{"name": {"missing"}}))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Got status %d for a missing stack, want 404", rec.Code)
+	}
+}
+
+func TestApiImportStack(t *testing.T) {
+	srv := newTestServer(t)
+	stack := srv.portainer.AddStack("demo", testCompose, nil)
+
+	req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/api/v1/portainer/stacks/%d/import", stack.Id),
+		strings.NewReader(`{"name":"imported"}`))
+	req.Header.Set("Content-Type", "application/json")
+	rec := srv.do(req)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Got status %d: %s", rec.Code, rec.Body)
+	}
+	if got := rec.Header().Get("Location"); got != "/api/v1/apps/imported" {
+		t.Errorf("Got location %q", got)
+	}
+
+	rec = srv.do(httptest.NewRequest(http.MethodGet, "/api/v1/portainer/stacks", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Got status %d listing stacks: %s", rec.Code, rec.Body)
+	}
+	var stacks []importableStack
+	if err := json.Unmarshal(rec.Body.Bytes(), &stacks); err != nil {
+		t.Fatalf("Invalid response %s: %s", rec.Body, err)
+	}
+	if len(stacks) != 1 || stacks[0].App != "imported" {
+		t.Errorf("Got stacks %+v, want the stack marked as imported", stacks)
+	}
+}
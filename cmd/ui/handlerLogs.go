@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/mr55p-dev/app-utils/lib/compose"
+	"github.com/mr55p-dev/app-utils/lib/manager"
+	"gopkg.in/yaml.v3"
+)
+
+// composeServices returns the service names declared in a compose file.
+func composeServices(composeFile []byte) []string {
+	parsed := struct {
+		Services map[string]any `yaml:"services"`
+	}{}
+	services := make([]string, 0)
+	if err := yaml.Unmarshal(composeFile, &parsed); err != nil {
+		return services
+	}
+	for name := range parsed.Services {
+		services = append(services, name)
+	}
+	sort.Strings(services)
+	return services
+}
+
+func writeEvent(w *echo.Response, event, data string) error {
+	if event != "" {
+		if _, err := fmt.Fprintf(w, "event: %s\n", event); err != nil {
+			return err
+		}
+	}
+	for _, line := range strings.Split(data, "\n") {
+		if _, err := fmt.Fprintf(w, "data: %s\n", line); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprint(w, "\n"); err != nil {
+		return err
+	}
+	w.Flush()
+	return nil
+}
+
+// logs streams the app's container logs as server-sent events. Each log line
+// is sent as a message, followed by an "end" event once compose exits. The
+// compose process is stopped as soon as the client goes away.
+func (h *Handler) logs(c echo.Context) error {
+	app := c.Get("app").(*manager.App)
+
+	tail, err := strconv.Atoi(c.QueryParam("tail"))
+	if err != nil {
+		tail = 200
+	}
+	opts := compose.LogsOptions{
+		Services:   c.QueryParams()["service"],
+		Follow:     c.QueryParam("follow") != "false",
+		Tail:       tail,
+		Since:      c.QueryParam("since"),
+		Timestamps: c.QueryParam("timestamps") != "",
+	}
+
+	// Logs are followed for as long as the client stays connected, so only
+	// the request's own context bounds the stream. The command is killed
+	// when it ends, which ends the read loop below.
+	ctx := c.Request().Context()
+	logs, err := h.compose.Logs(ctx, app.Path, opts)
+	if err != nil {
+		return c.String(http.StatusInternalServerError, fmt.Sprintf("Failed to read logs: %s", composeStderr(err)))
+	}
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set(echo.HeaderCacheControl, "no-cache")
+	res.Header().Set(echo.HeaderConnection, "keep-alive")
+	res.Header().Set("X-Accel-Buffering", "no")
+	res.WriteHeader(http.StatusOK)
+	res.Flush()
+
+	scanner := bufio.NewScanner(logs)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if err := writeEvent(res, "", scanner.Text()); err != nil {
+			break
+		}
+	}
+
+	closeErr := logs.Close()
+	if ctx.Err() != nil {
+		return nil
+	}
+	if closeErr != nil {
+		return writeEvent(res, "error", composeStderr(closeErr))
+	}
+	return writeEvent(res, "end", "")
+}
@@ -1,19 +1,34 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 
 	"github.com/labstack/echo/v4"
 	"github.com/mr55p-dev/app-utils/lib/manager"
+	"github.com/mr55p-dev/app-utils/lib/nginx"
 )
 
+func nginxFailure(c echo.Context, err error, message string) error {
+	testErr := new(nginx.TestError)
+	if errors.As(err, &testErr) {
+		return c.Render(http.StatusUnprocessableEntity, "nginxError.html", map[string]any{
+			"Message": message,
+			"Error":   testErr,
+		})
+	}
+	return c.String(errorStatus(err, http.StatusInternalServerError), fmt.Sprintf("%s: %s", message, err))
+}
+
 func (h *Handler) nginxEnable(c echo.Context) error {
 	app := c.Get("app").(*manager.App)
-	err := h.nginx.CreateAndInstallUnits(app.ID, app.AppYaml.Nginx)
+	ctx, cancel := h.requestContext(c)
+	defer cancel()
+	err := h.nginx.CreateAndInstallUnits(ctx, app.ID, app.AppYaml.Nginx)
 	if err != nil {
 		c.Logger().Debug("Failed to crate unit", err)
-		return c.String(http.StatusOK, "Failed to create unit")
+		return nginxFailure(c, err, "Failed to create unit")
 	}
 	return c.String(http.StatusOK, "Success!")
 }
@@ -25,13 +40,21 @@ func (h *Handler) nginxDisable(c echo.Context) error {
 		c.Logger().Debug("Error removing unit", err)
 		return c.String(http.StatusOK, "Failed to remove unit")
 	}
+	ctx, cancel := h.requestContext(c)
+	defer cancel()
+	if err := h.nginx.Reload(ctx); err != nil {
+		c.Logger().Debug("Failed to reload nginx", err)
+		return nginxFailure(c, err, "Removed unit but failed to reload nginx")
+	}
 	return c.String(http.StatusOK, "Success!")
 }
 
 func (h *Handler) nginxReload(c echo.Context) error {
-	if err := h.nginx.Reload(); err != nil {
+	ctx, cancel := h.requestContext(c)
+	defer cancel()
+	if err := h.nginx.Reload(ctx); err != nil {
 		c.Logger().Debug("Failed to reload nginx", err)
-		return c.String(http.StatusOK, fmt.Sprintf("Failed to reload nginx: %s", err))
+		return nginxFailure(c, err, "Failed to reload nginx")
 	}
 
 	return c.String(http.StatusOK, "Reloaded nginx!")
@@ -1,31 +1,321 @@
 package main
 
 import (
-	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 
 	"github.com/labstack/echo/v4"
 	"github.com/mr55p-dev/app-utils/lib/manager"
 	"github.com/mr55p-dev/app-utils/lib/portainer"
 )
 
+// appEndpoint returns the portainer endpoint the app's app.yml targets, which
+// is empty to use the default.
+func appEndpoint(app *manager.App) string {
+	if app.AppYaml == nil {
+		return ""
+	}
+	return app.AppYaml.Portainer.Endpoint
+}
+
+// stackDeleter deletes stacks on whichever endpoint they are deployed on.
+type stackDeleter struct {
+	portainer *portainer.Client
+}
+
+func (d stackDeleter) DeleteStack(ctx context.Context, stackId int) error {
+	target, err := d.portainer.ForStack(ctx, stackId, "")
+	if err != nil {
+		return err
+	}
+	return target.DeleteStack(ctx, stackId)
+}
+
+// stackInfo is a portainer stack along with the name of its endpoint.
+type stackInfo struct {
+	portainer.Stack
+	Endpoint string `json:"endpoint"`
+}
+
+// endpointNames maps endpoint IDs to names. Endpoints are only decoration
+// for listings, so failing to fetch them is logged and IDs shown instead.
+func (h *Handler) endpointNames(c echo.Context, ctx context.Context) map[int]string {
+	names, err := h.portainer.EndpointNames(ctx)
+	if err != nil {
+		c.Logger().Warn("Failed to list portainer endpoints", "error", err)
+	}
+	return names
+}
+
+func newStackInfo(stack portainer.Stack, endpoints map[int]string) stackInfo {
+	name, ok := endpoints[stack.EndpointId]
+	if !ok {
+		name = strconv.Itoa(stack.EndpointId)
+	}
+	return stackInfo{stack, name}
+}
+
+// appStack returns the app's stack and the endpoint it is deployed on.
+func (h *Handler) appStack(c echo.Context, app *manager.App) (*stackInfo, error) {
+	ctx, cancel := h.requestContext(c)
+	defer cancel()
+	stack, err := h.portainer.GetStack(ctx, app.PortainerId)
+	if err != nil {
+		return nil, err
+	}
+	info := newStackInfo(*stack, h.endpointNames(c, ctx))
+	return &info, nil
+}
+
+func (h *Handler) portainerStack(c echo.Context) error {
+	app := c.Get("app").(*manager.App)
+	data := map[string]any{"Endpoint": appEndpoint(app)}
+	if app.PortainerId == 0 {
+		return c.Render(http.StatusOK, "portainerStack.html", data)
+	}
+	stack, err := h.appStack(c, app)
+	if err != nil {
+		_, data["Error"] = stackFailure(err)
+	}
+	data["Stack"] = stack
+	if stack != nil && stack.AutoUpdate != nil && stack.AutoUpdate.Webhook != "" {
+		data["Webhook"] = h.portainer.WebhookURL(stack.AutoUpdate.Webhook)
+	}
+	return c.Render(http.StatusOK, "portainerStack.html", data)
+}
+
+// publishMessage describes the outcome of publishing an app.
+func publishMessage(res *manager.PublishResult) string {
+	verb := "Published"
+	if res.Created {
+		verb = "Created"
+	}
+	if !res.Git {
+		return fmt.Sprintf("%s stack %d", verb, res.StackId)
+	}
+	msg := fmt.Sprintf("%s stack %d from commit %s", verb, res.StackId, res.Commit)
+	if res.Webhook != "" {
+		msg += fmt.Sprintf(", redeploy it by posting to %s", res.Webhook)
+	}
+	return msg
+}
+
+// deployFormOptions reads the deploy options from the publish and redeploy
+// forms.
+func deployFormOptions(c echo.Context) portainer.DeployOptions {
+	return portainer.DeployOptions{
+		Prune:     c.FormValue("prune") != "",
+		PullImage: c.FormValue("pullImage") != "",
+	}
+}
+
+// portainerPlan previews what publishing the app would change, with a form
+// to confirm the publish.
+func (h *Handler) portainerPlan(c echo.Context) error {
+	app := c.Get("app").(*manager.App)
+	data := map[string]any{"Name": app.ID}
+	ctx, cancel := h.requestContext(c)
+	defer cancel()
+	plan, err := manager.PlanPublish(ctx, h.portainer, app)
+	if err != nil {
+		status, message := stackFailure(err)
+		data["Error"] = fmt.Sprintf("Failed to compare with portainer: %s", message)
+		return c.Render(status, "portainerPlan.html", data)
+	}
+	data["Plan"] = plan
+	return c.Render(http.StatusOK, "portainerPlan.html", data)
+}
+
+// portainerPublish publishes the app, creating its stack if it has none,
+// once the plan it was previewed with is confirmed.
 func (h *Handler) portainerPublish(c echo.Context) error {
 	app := c.Get("app").(*manager.App)
+	digest := c.FormValue("plan")
+	if digest == "" {
+		return alert(c, http.StatusBadRequest, "bad", "Preview the changes before publishing")
+	}
+	opts := deployFormOptions(c)
+	ctx, cancel := h.requestContext(c)
+	defer cancel()
+	res, err := manager.PublishPlanned(ctx, h.portainer, app, digest, opts)
+	if err != nil {
+		status, message := stackFailure(err)
+		return alert(c, status, "bad", fmt.Sprintf("Operation failed with message: %s", message))
+	}
+	c.Logger().Info("Published portainer stack", "app", app.ID, "stack", res.StackId, "git", res.Git, "prune", opts.Prune, "pullImage", opts.PullImage)
+	return alert(c, http.StatusOK, "ok", publishMessage(res))
+}
+
+func (h *Handler) portainerRedeploy(c echo.Context) error {
+	app := c.Get("app").(*manager.App)
+	ctx, cancel := h.requestContext(c)
+	defer cancel()
+	res, err := manager.Redeploy(ctx, h.portainer, app, deployFormOptions(c))
+	if err != nil {
+		status, message := stackFailure(err)
+		return alert(c, status, "bad", message)
+	}
+	c.Logger().Info("Redeployed portainer stack from git", "app", app.ID, "commit", res.Commit)
+	return alert(c, http.StatusOK, "ok", publishMessage(res))
+}
+
+// stackStateFn starts or stops a portainer stack.
+type stackStateFn func(cli *portainer.Client, ctx context.Context, stackId int) (*portainer.Stack, error)
+
+var stackStates = map[string]stackStateFn{
+	"start": (*portainer.Client).StartStack,
+	"stop":  (*portainer.Client).StopStack,
+}
 
+// stackFailure returns the status and message for a failed portainer call.
+func stackFailure(err error) (int, string) {
+	apiErr := new(portainer.APIError)
+	switch {
+	case errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound:
+		return http.StatusNotFound, "Stack not found in portainer"
+	case errors.Is(err, portainer.ErrWrongEndpoint):
+		return http.StatusConflict, err.Error()
+	case errors.Is(err, portainer.ErrEndpointNotFound):
+		return http.StatusUnprocessableEntity, err.Error()
+	case errors.Is(err, manager.ErrNotGitStack), errors.Is(err, manager.ErrNoGitConfig), errors.Is(err, manager.ErrNotPublished),
+		errors.Is(err, manager.ErrPlanChanged):
+		return http.StatusConflict, err.Error()
+	}
+	return errorStatus(err, http.StatusBadGateway), err.Error()
+}
+
+func (h *Handler) portainerState(action string) echo.HandlerFunc {
+	setState := stackStates[action]
+	return func(c echo.Context) error {
+		app := c.Get("app").(*manager.App)
+		if app.PortainerId == 0 {
+			return alert(c, http.StatusConflict, "bad", "Application is not managed via portainer")
+		}
+		ctx, cancel := h.requestContext(c)
+		defer cancel()
+		target, err := h.portainer.ForStack(ctx, app.PortainerId, "")
+		if err != nil {
+			status, message := stackFailure(err)
+			return alert(c, status, "bad", message)
+		}
+		stack, err := setState(target, ctx, app.PortainerId)
+		if err != nil {
+			status, message := stackFailure(err)
+			return alert(c, status, "bad", message)
+		}
+		c.Logger().Info("Changed portainer stack state", "app", app.ID, "action", action)
+		return alert(c, http.StatusOK, "ok", fmt.Sprintf("Stack %s is %s", stack.Name, stack.Status))
+	}
+}
+
+// deleteStack deletes the app's portainer stack and its .stack file, leaving
+// the app itself in place so it can be published again.
+func (h *Handler) deleteStack(c echo.Context, app *manager.App) (int, error) {
 	if app.PortainerId == 0 {
-		return c.String(http.StatusNotFound, "Application is not managed via portainer")
+		return http.StatusConflict, errors.New("Application is not managed via portainer")
+	}
+	ctx, cancel := h.requestContext(c)
+	defer cancel()
+	if err := (stackDeleter{h.portainer}).DeleteStack(ctx, app.PortainerId); err != nil {
+		status, message := stackFailure(err)
+		return status, errors.New(message)
+	}
+	if err := portainer.RemoveStackId(app.Path); err != nil {
+		return http.StatusInternalServerError, err
 	}
+	c.Logger().Info("Deleted portainer stack", "app", app.ID, "stack", app.PortainerId)
+	return http.StatusOK, nil
+}
+
+func (h *Handler) portainerDelete(c echo.Context) error {
+	app := c.Get("app").(*manager.App)
+	if status, err := h.deleteStack(c, app); err != nil {
+		return alert(c, status, "bad", err.Error())
+	}
+	return alert(c, http.StatusOK, "ok", fmt.Sprintf("Deleted portainer stack %d", app.PortainerId))
+}
 
-	composeReader := bytes.NewReader(app.ComposeFile)
-	env, err := portainer.ReadEnvironment(bytes.NewReader(app.EnvFile))
+func (h *Handler) apiPortainerState(action string) echo.HandlerFunc {
+	setState := stackStates[action]
+	return func(c echo.Context) error {
+		app := c.Get("app").(*manager.App)
+		if app.PortainerId == 0 {
+			return apiFail(c, http.StatusConflict, "Application is not managed via portainer", nil)
+		}
+		ctx, cancel := h.requestContext(c)
+		defer cancel()
+		target, err := h.portainer.ForStack(ctx, app.PortainerId, "")
+		if err != nil {
+			status, message := stackFailure(err)
+			return apiFail(c, status, message, nil)
+		}
+		stack, err := setState(target, ctx, app.PortainerId)
+		if err != nil {
+			status, message := stackFailure(err)
+			return apiFail(c, status, message, nil)
+		}
+		return c.JSON(http.StatusOK, stack)
+	}
+}
+
+func (h *Handler) apiPortainerRedeploy(c echo.Context) error {
+	app := c.Get("app").(*manager.App)
+	opts := new(portainer.DeployOptions)
+	if err := c.Bind(opts); err != nil {
+		return apiFail(c, http.StatusBadRequest, "Invalid request body", nil)
+	}
+	ctx, cancel := h.requestContext(c)
+	defer cancel()
+	res, err := manager.Redeploy(ctx, h.portainer, app, *opts)
+	if err != nil {
+		status, message := stackFailure(err)
+		return apiFail(c, status, message, nil)
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+func (h *Handler) apiPortainerPlan(c echo.Context) error {
+	app := c.Get("app").(*manager.App)
+	ctx, cancel := h.requestContext(c)
+	defer cancel()
+	plan, err := manager.PlanPublish(ctx, h.portainer, app)
+	if err != nil {
+		status, message := stackFailure(err)
+		return apiFail(c, status, message, nil)
+	}
+	return c.JSON(http.StatusOK, plan)
+}
+
+func (h *Handler) apiPortainerStack(c echo.Context) error {
+	app := c.Get("app").(*manager.App)
+	if app.PortainerId == 0 {
+		return apiFail(c, http.StatusConflict, "Application is not managed via portainer", nil)
+	}
+	stack, err := h.appStack(c, app)
 	if err != nil {
-		return c.String(http.StatusOK, "Failed to parse env file, check syntax")
+		status, message := stackFailure(err)
+		return apiFail(c, status, message, nil)
 	}
-	res, err := h.portainer.UpdateStack(app.PortainerId, composeReader, env)
+	return c.JSON(http.StatusOK, stack)
+}
+
+func (h *Handler) apiListEndpoints(c echo.Context) error {
+	ctx, cancel := h.requestContext(c)
+	defer cancel()
+	endpoints, err := h.portainer.ListEndpoints(ctx)
 	if err != nil {
-		return c.String(http.StatusOK, fmt.Sprintf("Operation failed with message: %s", err))
+		return apiFail(c, errorStatus(err, http.StatusBadGateway), fmt.Sprintf("Failed to list endpoints: %s", err), nil)
 	}
-	return c.String(http.StatusOK, fmt.Sprintf("Operation completed with message: %s", res.Message))
+	return c.JSON(http.StatusOK, endpoints)
+}
 
+func (h *Handler) apiPortainerDelete(c echo.Context) error {
+	app := c.Get("app").(*manager.App)
+	if status, err := h.deleteStack(c, app); err != nil {
+		return apiFail(c, status, err.Error(), nil)
+	}
+	return h.apiReloadApp(c, app.ID)
 }
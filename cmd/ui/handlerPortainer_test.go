@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/mr55p-dev/app-utils/lib/manager"
+	"github.com/mr55p-dev/app-utils/lib/portainer"
+)
+
+var planDigest = regexp.MustCompile(`name="plan" value="([0-9a-f]+)"`)
+
+// previewPublish fetches the plan for publishing the app and returns the
+// page and the digest its confirm form sends.
+func previewPublish(t *testing.T, srv *testServer, app string) (string, string) {
+	t.Helper()
+	rec := srv.do(httptest.NewRequest(http.MethodGet, "/app/"+app+"/portainer/plan", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Got status %d for the plan: %s", rec.Code, rec.Body)
+	}
+	match := planDigest.FindStringSubmatch(rec.Body.String())
+	if match == nil {
+		t.Fatalf("No plan digest in %s", rec.Body)
+	}
+	return rec.Body.String(), match[1]
+}
+
+func TestPortainerPublishCreatesStack(t *testing.T) {
+	srv := newTestServer(t)
+	dir := srv.addApp(t, "demo", "A=1\n")
+
+	page, digest := previewPublish(t, srv, "demo")
+	if !strings.Contains(page, "Publishing creates a new stack") || !strings.Contains(page, "&#43;services:") {
+		t.Errorf("Plan does not show a new stack: %s", page)
+	}
+	rec := srv.do(postForm("/app/demo/portainer", url.Values{"plan": {digest}}))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Got status %d: %s", rec.Code, rec.Body)
+	}
+	if !strings.Contains(rec.Body.String(), "Created stack") {
+		t.Errorf("Got response %s", rec.Body)
+	}
+	id, err := portainer.GetStackId(dir)
+	if err != nil {
+		t.Fatalf("No .stack file written: %s", err)
+	}
+	stack, file, ok := srv.portainer.Stack(id)
+	if !ok || file != testCompose || stack.Name != "demo" {
+		t.Errorf("Got stack %+v with file %q", stack, file)
+	}
+}
+
+// apiPreviewPublish fetches the plan for publishing the app from the API and
+// returns its digest.
+func apiPreviewPublish(t *testing.T, srv *testServer, app string) string {
+	t.Helper()
+	rec := srv.do(httptest.NewRequest(http.MethodGet, "/api/v1/apps/"+app+"/portainer/plan", nil))
+	plan := new(manager.Plan)
+	if err := json.Unmarshal(rec.Body.Bytes(), plan); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("Got status %d for the plan %s: %v", rec.Code, rec.Body, err)
+	}
+	if !plan.Create || plan.Digest == "" {
+		t.Fatalf("Got plan %+v, want a new stack", plan)
+	}
+	return plan.Digest
+}
+
+func TestApiPortainerPublishCreatesStack(t *testing.T) {
+	srv := newTestServer(t)
+	dir := srv.addApp(t, "demo", "A=1\n")
+
+	digest := apiPreviewPublish(t, srv, "demo")
+	rec := srv.do(jsonRequest(http.MethodPost, "/api/v1/apps/demo/portainer/publish", `{"prune":true,"plan":"`+digest+`"}`))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Got status %d: %s", rec.Code, rec.Body)
+	}
+	res := new(manager.PublishResult)
+	if err := json.Unmarshal(rec.Body.Bytes(), res); err != nil {
+		t.Fatalf("Invalid response %s: %s", rec.Body, err)
+	}
+	if !res.Created || res.StackId == 0 {
+		t.Errorf("Got result %+v, want a created stack", res)
+	}
+	if id, _ := portainer.GetStackId(dir); id != res.StackId {
+		t.Errorf("Got stack ID %d in the .stack file, want %d", id, res.StackId)
+	}
+}
+
+func TestPortainerPublishNeedsPlan(t *testing.T) {
+	srv := newTestServer(t)
+	dir := srv.addApp(t, "demo", "A=1\n")
+
+	rec := srv.do(postForm("/app/demo/portainer", url.Values{}))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Got status %d publishing without a plan, want 400", rec.Code)
+	}
+
+	_, digest := previewPublish(t, srv, "demo")
+	if err := os.WriteFile(filepath.Join(dir, "stack.env"), []byte("A=2\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	rec = srv.do(postForm("/app/demo/portainer", url.Values{"plan": {digest}}))
+	if rec.Code != http.StatusConflict {
+		t.Errorf("Got status %d publishing a stale plan, want 409: %s", rec.Code, rec.Body)
+	}
+	if _, err := portainer.GetStackId(dir); err == nil {
+		t.Error("Created a stack from a stale plan")
+	}
+}
+
+func TestApiPortainerPublishNeedsPlan(t *testing.T) {
+	srv := newTestServer(t)
+	dir := srv.addApp(t, "demo", "A=1\n")
+
+	rec := srv.do(jsonRequest(http.MethodPost, "/api/v1/apps/demo/portainer/publish", `{"prune":true}`))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Got status %d publishing without a plan, want 400: %s", rec.Code, rec.Body)
+	}
+
+	digest := apiPreviewPublish(t, srv, "demo")
+	if err := os.WriteFile(filepath.Join(dir, "stack.env"), []byte("A=2\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	rec = srv.do(jsonRequest(http.MethodPost, "/api/v1/apps/demo/portainer/publish", `{"plan":"`+digest+`"}`))
+	if rec.Code != http.StatusConflict {
+		t.Errorf("Got status %d publishing a stale plan, want 409: %s", rec.Code, rec.Body)
+	}
+	if _, err := portainer.GetStackId(dir); err == nil {
+		t.Error("Created a stack without a confirmed plan")
+	}
+}
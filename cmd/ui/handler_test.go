@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/mr55p-dev/app-utils/lib/compose"
+	"github.com/mr55p-dev/app-utils/lib/manager"
+	"github.com/mr55p-dev/app-utils/lib/nginx"
+	"github.com/mr55p-dev/app-utils/lib/portainer/portainertest"
+	"github.com/mr55p-dev/app-utils/lib/runner/runnertest"
+)
+
+const testCompose = "services:\n  web:\n    image: nginx:alpine\n"
+
+// testServer is the UI and API of a handler managing apps in a temporary
+// directory and publishing them to a fake portainer.
+type testServer struct {
+	*echo.Echo
+	apps      string
+	nginx     string
+	runner    *runnertest.Fake
+	portainer *portainertest.Server
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	dir := t.TempDir()
+	apps := filepath.Join(dir, "apps")
+	units := filepath.Join(dir, "nginx")
+	for _, d := range []string{apps, units} {
+		if err := os.Mkdir(d, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fsClient, err := manager.New(apps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := runnertest.New()
+	composeClient, err := compose.New(apps, compose.WithRunner(fake))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := portainertest.New("test-key")
+	t.Cleanup(srv.Close)
+
+	h := &Handler{
+		apps:      fsClient,
+		compose:   composeClient,
+		nginx:     nginx.New(nginx.WithDir(units), nginx.WithRunner(fake)),
+		portainer: srv.Client(),
+	}
+	e := echo.New()
+	e.Renderer = newTemplates()
+	h.registerApi(e)
+	h.registerRoutes(e)
+	return &testServer{Echo: e, apps: apps, nginx: units, runner: fake, portainer: srv}
+}
+
+// addApp writes an app with a compose file and env to the apps directory.
+func (s *testServer) addApp(t *testing.T, name, env string) string {
+	t.Helper()
+	dir := filepath.Join(s.apps, name)
+	files := map[string]string{
+		"app.yml":            "app: " + name + "\n",
+		"docker-compose.yml": testCompose,
+		"stack.env":          env,
+	}
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for file, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, file), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func (s *testServer) do(req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	return rec
+}
+
+func postForm(target string, form url.Values) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(form.Encode()))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	return req
+}
@@ -1,57 +1,179 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"io/fs"
 	"log/slog"
 	"net/http"
 	"os"
+	"strings"
+	"time"
 
 	"embed"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	"github.com/labstack/gommon/log"
+	"github.com/mr55p-dev/app-utils/config"
 	"github.com/mr55p-dev/app-utils/lib/compose"
 	"github.com/mr55p-dev/app-utils/lib/manager"
 	"github.com/mr55p-dev/app-utils/lib/nginx"
 	"github.com/mr55p-dev/app-utils/lib/portainer"
+	"github.com/mr55p-dev/app-utils/lib/runner"
 )
 
 var (
-	AppsDir        = flag.String("apps", "/etc/gold/apps", "Path to apps directory")
-	NginxDir       = flag.String("nginx", "/etc/nginx/sites-enabled", "Path to nginx dir")
-	SSLCertPath    = flag.String("ssl-cert", "", "Path to ssl cert")
-	SSLCertKeyPath = flag.String("ssl-key", "", "Path to ssl cert key")
-	SSLDHParamPath = flag.String("ssl-dhparam", "", "Path to dhparams.txt file")
-	host           = flag.String("host", "", "Host to listen on")
-	port           = flag.Int("port", 8080, "Port to listen on")
-	logLevel       = flag.Bool("v", false, "Sets verbose mode")
+	AppsDir           = flag.String("apps", "/etc/gold/apps", "Path to apps directory")
+	NginxDir          = flag.String("nginx", "/etc/nginx/sites-enabled", "Path to nginx dir")
+	SSLCertPath       = flag.String("ssl-cert", "", "Path to ssl cert")
+	SSLCertKeyPath    = flag.String("ssl-key", "", "Path to ssl cert key")
+	SSLDHParamPath    = flag.String("ssl-dhparam", "", "Path to dhparams.txt file")
+	NginxTest         = flag.String("nginx-test", "nginx -t", "Command used to validate nginx config, empty to disable")
+	NginxReload       = flag.String("nginx-reload", "nginx -s reload", "Command used to reload nginx")
+	AuthStyle         = flag.String("auth-style", "", "Auth proxy for protected hosts: vouch, oauth2-proxy or authelia")
+	AuthEndpoint      = flag.String("auth-endpoint", "", "URL nginx uses to validate requests to protected hosts")
+	AuthLoginURL      = flag.String("auth-login-url", "", "URL unauthenticated users are redirected to")
+	Domains           = flag.String("domains", "home.pagemail.io", "Comma separated base domains for nginx hosts")
+	ProxyAuthCIDRs    = flag.String("proxy-auth-cidrs", "", "Comma separated addresses of forward-auth proxies whose user header is trusted")
+	ProxyAuthHeaders  = flag.String("proxy-auth-headers", "X-Vouch-User,Remote-User", "Comma separated headers carrying the user set by the forward-auth proxy")
+	UsersFile         = flag.String("users-file", "", "Path to a file of user:bcrypt-hash lines for basic auth")
+	TokensFile        = flag.String("tokens-file", "", "Path to a file of name:token lines for bearer token auth")
+	CommandTimeout    = flag.Duration("command-timeout", 10*time.Minute, "Maximum run time for docker compose and nginx commands")
+	RequestTimeout    = flag.Duration("request-timeout", 15*time.Minute, "Deadline for the commands and portainer calls made while handling a request")
+	PortainerCA       = flag.String("portainer-ca", "", "Path to a PEM bundle of extra CAs to trust for portainer")
+	PortainerInsecure = flag.Bool("portainer-insecure", false, "Skip verifying portainer's TLS certificate")
+	PortainerTimeout  = flag.Duration("portainer-timeout", 30*time.Second, "Timeout for each request to portainer")
+	PortainerRetries  = flag.Int("portainer-retries", 2, "Times to retry portainer requests failing with a network error or 5xx")
+	PortainerLogin    = flag.String("portainer-credentials", "", "Path to an env file with PORTAINER_USERNAME and PORTAINER_PASSWORD, read from the environment when empty")
+	SecureCookies     = flag.Bool("secure-cookies", false, "Only send cookies over https")
+	AllowedOrigins    = flag.String("allowed-origins", "", "Comma separated origins allowed to make requests besides the served host")
+	InsecureNoAuth    = flag.Bool("insecure-no-auth", false, "Serve without any authentication")
+	host              = flag.String("host", "", "Host to listen on")
+	port              = flag.Int("port", 8080, "Port to listen on")
+	logLevel          = flag.Bool("v", false, "Sets verbose mode")
 )
 
 //go:embed html/*
 var embeddedFS embed.FS
 var templateFS, _ = fs.Sub(embeddedFS, "html")
 
-func main() {
-	flag.Parse()
+func newAuthenticators() ([]authenticator, error) {
+	methods := make([]authenticator, 0)
+	if *ProxyAuthCIDRs != "" {
+		proxy, err := newProxyAuth(config.SplitList(*ProxyAuthCIDRs), config.SplitList(*ProxyAuthHeaders))
+		if err != nil {
+			return nil, err
+		}
+		methods = append(methods, proxy)
+	}
+	if *TokensFile != "" {
+		tokens, err := newTokenAuth(*TokensFile)
+		if err != nil {
+			return nil, err
+		}
+		methods = append(methods, tokens)
+	}
+	if *UsersFile != "" {
+		users, err := newPasswordAuth(*UsersFile)
+		if err != nil {
+			return nil, err
+		}
+		methods = append(methods, users)
+	}
+	if len(methods) == 0 && !*InsecureNoAuth {
+		return nil, errors.New("No authentication configured, set -proxy-auth-cidrs, -users-file or -tokens-file (or -insecure-no-auth)")
+	}
+	return methods, nil
+}
+
+func newTemplates() *Template {
 	t := NewTemplates(
 		"layout.html",
 		"components/alert.html",
 		"components/composeForm.html",
 		"components/configForm.html",
 		"components/containersTable.html",
+		"components/deleteReport.html",
+		"components/nginxError.html",
+		"components/portainerPlan.html",
+		"components/portainerStack.html",
+		"components/logViewer.html",
 	)
 	t.LoadPage(
 		"views/list.html",
 		"views/app.html",
 		"views/create.html",
 		"views/extensions.html",
+		"views/delete.html",
+		"views/import.html",
 	)
+	return t
+}
+
+// registerRoutes adds the pages and htmx endpoints of the UI to e.
+func (h *Handler) registerRoutes(e *echo.Echo) {
+	e.GET("", h.root)
+	e.GET("/extensions", h.extensions)
+	e.GET("/create", h.create)
+	e.POST("/create", h.createApp)
+	e.GET("/delete", h.deletePage)
+	e.GET("/import", h.importPage)
+	e.POST("/import/:stackId", h.importApp)
+	e.POST("/archive/:id/restore", h.restoreApp)
+	e.POST("/server/nginx/reload", h.nginxReload)
+
+	app := e.Group("/app/:id", func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			id := c.Param("id")
+			if id == "" {
+				return c.String(http.StatusBadRequest, "App id is required")
+			}
+			app, err := h.apps.Get(id)
+			if err != nil {
+				return c.String(http.StatusNotFound, fmt.Sprintf("App not found: %s", err))
+			}
+
+			c.Set("app", app)
+			return next(c)
+		}
+	})
+	app.GET("", h.viewApp)
+	app.GET("/containers", h.containers)
+	app.GET("/logs", h.logs)
+	app.GET("/create", h.create)
+	app.POST("/create", h.createApp)
+	app.POST("/delete", h.deleteApp)
+
+	// app yaml config
+	app.POST("/config", h.configApp)
 
+	// nginx units
+	app.POST("/nginx/enable", h.nginxEnable)
+	app.POST("/nginx/disable", h.nginxDisable)
+
+	// compose file changes
+	app.POST("/compose", h.configCompose)
+	app.POST("/compose/reload", h.composeRestart)
+	for _, action := range composeActions {
+		app.POST("/compose/"+action.Name, h.composeAction(action))
+	}
+
+	// publushing
+	app.GET("/portainer/stack", h.portainerStack)
+	app.GET("/portainer/plan", h.portainerPlan)
+	app.POST("/portainer", h.portainerPublish)
+	app.POST("/portainer/redeploy", h.portainerRedeploy)
+	app.POST("/portainer/start", h.portainerState("start"))
+	app.POST("/portainer/stop", h.portainerState("stop"))
+	app.POST("/portainer/delete", h.portainerDelete)
+}
+
+func main() {
+	flag.Parse()
 	e := echo.New()
-	e.Renderer = t
+	e.Renderer = newTemplates()
 	if *logLevel {
 		e.Logger.SetLevel(log.DEBUG)
 	} else {
@@ -63,17 +185,44 @@ func main() {
 		middleware.Logger(),
 	)
 
+	authMethods, err := newAuthenticators()
+	if err != nil {
+		panic(err)
+	}
+	if len(authMethods) > 0 {
+		e.Use(authMiddleware(authMethods, "/healthz"))
+	} else {
+		e.Logger.Warn("Authentication is disabled, anyone who can reach the server can manage apps")
+	}
+	e.Use(
+		originMiddleware(config.SplitList(*AllowedOrigins)),
+		csrfMiddleware(*SecureCookies),
+	)
+
 	apps, err := manager.New(*AppsDir)
 	if err != nil {
 		panic(err)
 	}
 
-	compose, err := compose.New(*AppsDir)
+	commandRunner := runner.Exec{Timeout: *CommandTimeout}
+	compose, err := compose.New(*AppsDir, compose.WithRunner(commandRunner))
 	if err != nil {
 		panic(err)
 	}
 
-	nginxArgs := []nginx.ConfigFn{nginx.WithDir(*NginxDir)}
+	nginxArgs := []nginx.ConfigFn{
+		nginx.WithDir(*NginxDir),
+		nginx.WithRunner(commandRunner),
+		nginx.WithTestCommand(strings.Fields(*NginxTest)...),
+		nginx.WithReloadCommand(strings.Fields(*NginxReload)...),
+		nginx.WithDomains(config.SplitList(*Domains)...),
+	}
+	if *AuthStyle != "" {
+		if err := nginx.ValidAuthStyle(nginx.AuthStyle(*AuthStyle)); err != nil {
+			panic(err)
+		}
+		nginxArgs = append(nginxArgs, nginx.WithAuth(nginx.AuthStyle(*AuthStyle), *AuthEndpoint, *AuthLoginURL))
+	}
 	sslEnabled := *SSLCertPath != "" && *SSLCertKeyPath != ""
 	if sslEnabled {
 		nginxArgs = append(nginxArgs, nginx.WithSSL(*SSLCertPath, *SSLCertKeyPath))
@@ -82,54 +231,36 @@ func main() {
 		nginxArgs = append(nginxArgs, nginx.WithDHParams(*SSLDHParamPath))
 	}
 
+	portainerHTTP, err := portainer.NewHTTPClient(*PortainerCA, *PortainerInsecure)
+	if err != nil {
+		panic(err)
+	}
+	portainerCreds, err := portainer.LoadCredentials(*PortainerLogin)
+	if err != nil {
+		panic(err)
+	}
+
 	handler := &Handler{
 		apps:    apps,
 		compose: compose,
 		nginx:   nginx.New(nginxArgs...),
 		portainer: &portainer.Client{
-			Scheme:     os.Getenv("PORTAINER_SCHEME"),
-			Host:       os.Getenv("PORTAINER_HOST"),
-			ApiKey:     os.Getenv("PORTAINER_KEY"),
-			EndpointId: os.Getenv("PORTAINER_ENDPOINT_ID"),
+			Scheme:      os.Getenv("PORTAINER_SCHEME"),
+			Host:        os.Getenv("PORTAINER_HOST"),
+			ApiKey:      os.Getenv("PORTAINER_KEY"),
+			Credentials: portainerCreds,
+			EndpointId:  os.Getenv("PORTAINER_ENDPOINT_ID"),
+			HTTPClient:  portainerHTTP,
+			Timeout:     *PortainerTimeout,
+			Retries:     *PortainerRetries,
 		},
+		timeout: *RequestTimeout,
 	}
 
-	e.GET("", handler.root)
-	e.GET("/extensions", handler.extensions)
-	e.POST("/server/nginx/reload", handler.nginxReload)
-
-	app := e.Group("/app/:id", func(next echo.HandlerFunc) echo.HandlerFunc {
-		return func(c echo.Context) error {
-			id := c.Param("id")
-			if id == "" {
-				return c.String(http.StatusBadRequest, "App id is required")
-			}
-			app, err := handler.apps.Get(id)
-			if err != nil {
-				return c.String(http.StatusNotFound, fmt.Sprintf("App not found: %s", err))
-			}
-
-			c.Set("app", app)
-			return next(c)
-		}
-	})
-	app.GET("", handler.viewApp)
-	app.GET("/create", handler.create)
-	app.POST("/create", func(c echo.Context) error { return c.NoContent(http.StatusNotImplemented) })
-
-	// app yaml config
-	app.POST("/config", handler.configApp)
-
-	// nginx units
-	app.POST("/nginx/enable", handler.nginxEnable)
-	app.POST("/nginx/disable", handler.nginxDisable)
+	e.GET("/healthz", func(c echo.Context) error { return c.String(http.StatusOK, "ok") })
+	handler.registerApi(e)
 
-	// compose file changes
-	app.POST("/compose", handler.configCompose)
-	app.POST("/compose/reload", handler.composeRestart)
-
-	// publushing
-	app.POST("/portainer", handler.portainerPublish)
+	handler.registerRoutes(e)
 
 	if err := e.Start(fmt.Sprintf("%s:%d", *host, *port)); err != nil {
 		slog.Error("Failed to start server", "error", err)
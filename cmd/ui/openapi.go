@@ -0,0 +1,161 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func hasAppParam(path string) bool {
+	return strings.Contains(path, ":id")
+}
+
+// schemaBuilder converts Go types into OpenAPI schemas, collecting named
+// struct types into the components section so they are only described once.
+type schemaBuilder struct {
+	components map[string]any
+}
+
+func (b *schemaBuilder) schema(t reflect.Type) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "format": "byte"}
+		}
+		return map[string]any{"type": "array", "items": b.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": b.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.object(t)
+		}
+		if _, ok := b.components[t.Name()]; !ok {
+			// Reserve the name first so recursive types terminate.
+			b.components[t.Name()] = map[string]any{}
+			b.components[t.Name()] = b.object(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + t.Name()}
+	default:
+		return map[string]any{}
+	}
+}
+
+func (b *schemaBuilder) object(t reflect.Type) map[string]any {
+	properties := make(map[string]any)
+	for _, field := range reflect.VisibleFields(t) {
+		if !field.IsExported() {
+			continue
+		}
+		name := field.Name
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		if tagName, _, _ := strings.Cut(tag, ","); tagName != "" {
+			name = tagName
+		}
+		if field.Anonymous && tag == "" && field.Type.Kind() == reflect.Struct {
+			// Embedded fields are promoted by VisibleFields.
+			continue
+		}
+		properties[name] = b.schema(field.Type)
+	}
+	return map[string]any{"type": "object", "properties": properties}
+}
+
+func jsonContent(schema map[string]any) map[string]any {
+	return map[string]any{
+		"application/json": map[string]any{"schema": schema},
+	}
+}
+
+// openApiDocument describes routes as an OpenAPI 3 document.
+func openApiDocument(prefix string, routes []apiRoute) map[string]any {
+	builder := &schemaBuilder{components: make(map[string]any)}
+	errorSchema := builder.schema(reflect.TypeOf(apiError{}))
+
+	paths := make(map[string]any)
+	for _, route := range routes {
+		segments := strings.Split(route.Path, "/")
+		params := make([]any, 0)
+		for i, segment := range segments {
+			if strings.HasPrefix(segment, ":") {
+				name := strings.TrimPrefix(segment, ":")
+				segments[i] = "{" + name + "}"
+				params = append(params, map[string]any{
+					"name":     name,
+					"in":       "path",
+					"required": true,
+					"schema":   map[string]any{"type": "string"},
+				})
+			}
+		}
+		path := prefix + strings.Join(segments, "/")
+
+		operation := map[string]any{
+			"summary":    route.Summary,
+			"parameters": params,
+			"responses": map[string]any{
+				"default": map[string]any{
+					"description": "Error",
+					"content":     jsonContent(errorSchema),
+				},
+			},
+		}
+		if route.Request != nil {
+			operation["requestBody"] = map[string]any{
+				"required": true,
+				"content":  jsonContent(builder.schema(reflect.TypeOf(route.Request))),
+			}
+		}
+		if route.Response != nil {
+			status := route.Status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			operation["responses"].(map[string]any)[strconv.Itoa(status)] = map[string]any{
+				"description": http.StatusText(status),
+				"content":     jsonContent(builder.schema(reflect.TypeOf(route.Response))),
+			}
+		}
+
+		item, ok := paths[path].(map[string]any)
+		if !ok {
+			item = make(map[string]any)
+			paths[path] = item
+		}
+		item[strings.ToLower(route.Method)] = operation
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "Gold app manager",
+			"version": "v1",
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": builder.components,
+		},
+	}
+}
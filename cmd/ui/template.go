@@ -1,20 +1,45 @@
 package main
 
 import (
+	"fmt"
 	"html/template"
 	"io"
 	"path/filepath"
 
 	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
 )
 
+// templateFuncs declares the functions available to templates. The values
+// here are placeholders, Render swaps in implementations bound to the request.
+var templateFuncs = template.FuncMap{
+	"csrfToken": func() string { return "" },
+}
+
 type Template struct {
 	templates map[string]*template.Template
 	base      *template.Template
 }
 
+// Render executes a clone of the named template so request scoped functions
+// can be bound without racing other requests. The stored templates are never
+// executed directly, which keeps them cloneable.
 func (t *Template) Render(w io.Writer, name string, data interface{}, c echo.Context) error {
-	return t.templates[name].Execute(w, data)
+	stored, ok := t.templates[name]
+	if !ok {
+		return fmt.Errorf("Template %s not found", name)
+	}
+	tmpl, err := stored.Clone()
+	if err != nil {
+		return fmt.Errorf("Failed to clone template %s: %w", name, err)
+	}
+	tmpl.Funcs(template.FuncMap{
+		"csrfToken": func() string {
+			token, _ := c.Get(middleware.DefaultCSRFConfig.ContextKey).(string)
+			return token
+		},
+	})
+	return tmpl.Execute(w, data)
 }
 
 func (t *Template) LoadPage(names ...string) {
@@ -31,7 +56,7 @@ func NewTemplates(layout string, components ...string) *Template {
 	for _, component := range components {
 		name := filepath.Base(component)
 		templateMap[name] = template.Must(
-			template.ParseFS(templateFS, component),
+			template.New(name).Funcs(templateFuncs).ParseFS(templateFS, component),
 		)
 	}
 
@@ -39,6 +64,8 @@ func NewTemplates(layout string, components ...string) *Template {
 	arg = append(arg, components...)
 	return &Template{
 		templates: templateMap,
-		base:      template.Must(template.ParseFS(templateFS, arg...)),
+		base: template.Must(
+			template.New(filepath.Base(layout)).Funcs(templateFuncs).ParseFS(templateFS, arg...),
+		),
 	}
 }
@@ -5,26 +5,115 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/mr55p-dev/gonk"
 	"gopkg.in/yaml.v3"
 )
 
 type NginxBlock struct {
-	ExternalHost string
-	Protocol     string `config:"protocol,optional"`
-	IPv4         string `config:"ipv4"`
-	Port         int
-	Protected    bool `config:"protected,optional"`
+	ExternalHost string `config:"externalhost" yaml:"externalhost" json:"externalHost"`
+	Protocol     string `config:"protocol,optional" yaml:"protocol,omitempty" json:"protocol"`
+	IPv4         string `config:"ipv4" yaml:"ipv4" json:"ipv4"`
+	Port         int    `config:"port" yaml:"port" json:"port"`
+	Protected    bool   `config:"protected,optional" yaml:"protected,omitempty" json:"protected"`
+	Domain       string `config:"domain,optional" yaml:"domain,omitempty" json:"domain,omitempty"`
+	FQDN         bool   `config:"fqdn,optional" yaml:"fqdn,omitempty" json:"fqdn,omitempty"`
+}
+
+// ServerNames returns the host names the block is served on. ExternalHost is
+// qualified with each of domains unless the block overrides the domain or
+// marks ExternalHost as already fully qualified.
+func (b NginxBlock) ServerNames(domains []string) []string {
+	if b.FQDN {
+		return []string{b.ExternalHost}
+	}
+	if b.Domain != "" {
+		domains = []string{b.Domain}
+	}
+	if len(domains) == 0 {
+		return []string{b.ExternalHost}
+	}
+	names := make([]string, len(domains))
+	for i, domain := range domains {
+		names[i] = fmt.Sprintf("%s.%s", b.ExternalHost, strings.TrimPrefix(domain, "."))
+	}
+	return names
+}
+
+// SplitList splits a comma separated flag value, dropping blank entries.
+func SplitList(list string) []string {
+	out := make([]string, 0)
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// EnvConflicts controls what happens when more than one env source defines
+// the same key. Sources are applied in order: generated values, each of the
+// env-extensions as listed, then the runtime env, and the last one wins.
+type EnvConflicts string
+
+const (
+	// EnvConflictsAllow lets later sources override earlier ones silently.
+	EnvConflictsAllow EnvConflicts = "allow"
+	// EnvConflictsWarn lets later sources win but reports each override.
+	// It is the default.
+	EnvConflictsWarn EnvConflicts = "warn"
+	// EnvConflictsError refuses to generate an environment with overrides.
+	EnvConflictsError EnvConflicts = "error"
+)
+
+// PortainerAutoUpdate has portainer redeploy a git stack by itself.
+type PortainerAutoUpdate struct {
+	// Interval polls the repository for changes this often, e.g. 5m.
+	Interval string `config:"interval,optional" yaml:"interval,omitempty" json:"interval,omitempty"`
+	// Webhook creates a URL which redeploys the stack when POSTed to.
+	Webhook     bool `config:"webhook,optional" yaml:"webhook,omitempty" json:"webhook,omitempty"`
+	ForceUpdate bool `config:"force-update,optional" yaml:"force-update,omitempty" json:"forceUpdate,omitempty"`
+	PullImage   bool `config:"pull-image,optional" yaml:"pull-image,omitempty" json:"pullImage,omitempty"`
+}
+
+// PortainerGit deploys an app from a compose file in a git repository
+// instead of the app's docker-compose.yml.
+type PortainerGit struct {
+	URL string `config:"url,optional" yaml:"url,omitempty" json:"url,omitempty"`
+	// Ref is the branch or tag deployed, e.g. refs/heads/main. The
+	// repository's default branch is used when empty.
+	Ref         string `config:"ref,optional" yaml:"ref,omitempty" json:"ref,omitempty"`
+	ComposePath string `config:"compose-path,optional" yaml:"compose-path,omitempty" json:"composePath,omitempty"`
+	Username    string `config:"username,optional" yaml:"username,omitempty" json:"username,omitempty"`
+	// PasswordEnv names the environment variable holding the password or
+	// access token for Username, so it is kept out of app.yml.
+	PasswordEnv string              `config:"password-env,optional" yaml:"password-env,omitempty" json:"passwordEnv,omitempty"`
+	AutoUpdate  PortainerAutoUpdate `config:"auto-update,optional" yaml:"auto-update,omitempty" json:"autoUpdate,omitempty"`
+}
+
+// PortainerConfig controls how an app is deployed through portainer.
+type PortainerConfig struct {
+	// Endpoint is the name or ID of the endpoint the app's stack is deployed
+	// on. When empty the endpoint portainer is configured with is used.
+	Endpoint string       `config:"endpoint,optional" yaml:"endpoint,omitempty" json:"endpoint,omitempty"`
+	Git      PortainerGit `config:"git,optional" yaml:"git,omitempty" json:"git,omitempty"`
+}
+
+// UsesGit reports whether the app is deployed from a git repository.
+func (p PortainerConfig) UsesGit() bool {
+	return p.Git.URL != ""
 }
 
 type AppConfig struct {
-	App     string
-	Nginx   []NginxBlock `config:"nginx,optional"`
-	Runtime struct {
-		EnvExtensions []string       `config:"env-extensions,optional"`
-		Env           map[string]any `config:"env,optional"`
-	} `config:"runtime,optional"`
+	App       string          `config:"app" yaml:"app" json:"app"`
+	Nginx     []NginxBlock    `config:"nginx,optional" yaml:"nginx,omitempty" json:"nginx"`
+	Portainer PortainerConfig `config:"portainer,optional" yaml:"portainer,omitempty" json:"portainer,omitempty"`
+	Runtime   struct {
+		EnvExtensions []string       `config:"env-extensions,optional" yaml:"env-extensions,omitempty" json:"envExtensions"`
+		Env           map[string]any `config:"env,optional" yaml:"env,omitempty" json:"env"`
+		EnvConflicts  EnvConflicts   `config:"env-conflicts,optional" yaml:"env-conflicts,omitempty" json:"envConflicts,omitempty"`
+	} `config:"runtime,optional" yaml:"runtime,omitempty" json:"runtime"`
 }
 
 type Extensions map[string]map[string]string
@@ -1,26 +1,66 @@
 package compose
 
 import (
-	"bytes"
+	"context"
 	"fmt"
-	"os/exec"
+	"io"
+	"strings"
+
+	"github.com/mr55p-dev/app-utils/lib/runner"
 )
 
 var composeArgs = []string{"compose"}
 
-func command(path string, args ...string) ([]byte, error) {
-	outBytes := new(bytes.Buffer)
-	errBytes := new(bytes.Buffer)
+// CommandError is returned when docker compose exits unsuccessfully. Stderr
+// holds whatever compose printed, which usually explains the failure.
+type CommandError struct {
+	Args   []string
+	Stderr string
+	Err    error
+}
+
+func (e *CommandError) Error() string {
+	return fmt.Sprintf("docker %s: %s: %s", strings.Join(e.Args, " "), e.Err, strings.TrimSpace(e.Stderr))
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+func composeCommand(path string, args []string) runner.Cmd {
 	a := make([]string, len(args)+len(composeArgs))
 	copy(a[:len(composeArgs)], composeArgs)
 	copy(a[len(composeArgs):], args)
-	cmd := exec.Command("docker", a...)
-	cmd.Dir = path
-	cmd.Stdout = outBytes
-	cmd.Stderr = errBytes
-	if err := cmd.Run(); err != nil {
-		return nil, fmt.Errorf("Error (%s): %s", err, errBytes.String())
+	return runner.Cmd{Name: "docker", Args: a, Dir: path}
+}
+
+func (c *Client) command(ctx context.Context, path string, args ...string) ([]byte, error) {
+	cmd := composeCommand(path, args)
+	stdout, stderr, err := c.runner.Run(ctx, cmd)
+	if err != nil {
+		return nil, &CommandError{Args: cmd.Args, Stderr: string(stderr), Err: err}
+	}
+	return stdout, nil
+}
+
+// commandStream wraps errors from the underlying stream in a CommandError.
+type commandStream struct {
+	runner.Stream
+	args []string
+}
+
+func (s *commandStream) Close() error {
+	if err := s.Stream.Close(); err != nil {
+		return &CommandError{Args: s.args, Stderr: s.Stderr(), Err: err}
 	}
-	return outBytes.Bytes(), nil
+	return nil
 }
 
+func (c *Client) stream(ctx context.Context, path string, args ...string) (io.ReadCloser, error) {
+	cmd := composeCommand(path, args)
+	out, err := c.runner.Start(ctx, cmd)
+	if err != nil {
+		return nil, &CommandError{Args: cmd.Args, Err: err}
+	}
+	return &commandStream{Stream: out, args: cmd.Args}, nil
+}
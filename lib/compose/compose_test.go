@@ -0,0 +1,252 @@
+package compose
+
+import (
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mr55p-dev/app-utils/lib/runner/runnertest"
+)
+
+func newTestClient(t *testing.T, fake *runnertest.Fake) *Client {
+	t.Helper()
+	cli, err := New(t.TempDir(), WithRunner(fake))
+	if err != nil {
+		t.Fatalf("New returned error: %s", err)
+	}
+	return cli
+}
+
+const psLines = `{"ID":"abc","Name":"demo-web-1","Service":"web","Image":"nginx:alpine","State":"running","Health":"healthy","ExitCode":0,"Publishers":[{"URL":"0.0.0.0","TargetPort":80,"PublishedPort":8080,"Protocol":"tcp"}],"CreatedAt":"2024-05-01 10:00:00 +0000 UTC"}
+{"ID":"def","Name":"demo-db-1","Service":"db","Image":"postgres:16","State":"exited","Health":"","ExitCode":137,"Publishers":null,"Created":1714557600}
+`
+
+const psArray = `[{"ID":"abc","Name":"demo-web-1","Service":"web","Image":"nginx:alpine","State":"running"}]`
+
+func TestPs(t *testing.T) {
+	tests := []struct {
+		name     string
+		output   string
+		services []string
+		states   []string
+	}{
+		{"json lines", psLines, []string{"web", "db"}, []string{"running", "exited"}},
+		{"json array", psArray, []string{"web"}, []string{"running"}},
+		{"empty", "", []string{}, []string{}},
+		{"empty array", "[]\n", []string{}, []string{}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := runnertest.New().On("docker compose ps", runnertest.Response{Stdout: tc.output})
+			cli := newTestClient(t, fake)
+
+			containers, err := cli.Ps(context.Background(), "/apps/demo")
+			if err != nil {
+				t.Fatalf("Ps returned error: %s", err)
+			}
+			services := make([]string, 0)
+			states := make([]string, 0)
+			for _, container := range containers {
+				services = append(services, container.Service)
+				states = append(states, container.State)
+			}
+			if !reflect.DeepEqual(services, tc.services) {
+				t.Errorf("Got services %v, want %v", services, tc.services)
+			}
+			if !reflect.DeepEqual(states, tc.states) {
+				t.Errorf("Got states %v, want %v", states, tc.states)
+			}
+
+			invocations := fake.Invocations()
+			if len(invocations) != 1 || invocations[0].Dir != "/apps/demo" {
+				t.Fatalf("Expected one invocation in /apps/demo, got %+v", invocations)
+			}
+			if got := invocations[0].String(); got != "docker compose ps --all --format json" {
+				t.Errorf("Unexpected command %q", got)
+			}
+		})
+	}
+}
+
+func TestPsFields(t *testing.T) {
+	fake := runnertest.New().On("docker compose ps", runnertest.Response{Stdout: psLines})
+	containers, err := newTestClient(t, fake).Ps(context.Background(), "/apps/demo")
+	if err != nil {
+		t.Fatalf("Ps returned error: %s", err)
+	}
+
+	web, db := containers[0], containers[1]
+	if web.Health != "healthy" || web.Image != "nginx:alpine" {
+		t.Errorf("Unexpected web container %+v", web)
+	}
+	if len(web.Publishers) != 1 || web.Publishers[0].String() != "0.0.0.0:8080->80/tcp" {
+		t.Errorf("Unexpected publishers %+v", web.Publishers)
+	}
+	if want := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC); !web.CreatedTime().Equal(want) {
+		t.Errorf("Got created %s, want %s", web.CreatedTime(), want)
+	}
+	if db.ExitCode != 137 {
+		t.Errorf("Got exit code %d, want 137", db.ExitCode)
+	}
+	if want := time.Unix(1714557600, 0); !db.CreatedTime().Equal(want) {
+		t.Errorf("Got created %s, want %s", db.CreatedTime(), want)
+	}
+}
+
+func TestPsInvalidOutput(t *testing.T) {
+	fake := runnertest.New().On("docker compose ps", runnertest.Response{Stdout: "NAME IMAGE\n"})
+	if _, err := newTestClient(t, fake).Ps(context.Background(), "/apps/demo"); err == nil {
+		t.Fatal("Expected an error for non JSON output")
+	}
+}
+
+func TestUp(t *testing.T) {
+	fake := runnertest.New()
+	if err := newTestClient(t, fake).Up(context.Background(), "/apps/demo"); err != nil {
+		t.Fatalf("Up returned error: %s", err)
+	}
+	if got := fake.Commands(); !reflect.DeepEqual(got, []string{"docker compose up -d"}) {
+		t.Errorf("Unexpected commands %v", got)
+	}
+}
+
+func TestErrorsCarryStderr(t *testing.T) {
+	stderr := "service \"web\" refers to undefined network backend: invalid compose project"
+	fake := runnertest.New().On("docker compose up", runnertest.Response{
+		Stderr: stderr,
+		Err:    runnertest.ErrExit,
+	})
+
+	err := newTestClient(t, fake).Up(context.Background(), "/apps/demo")
+	if err == nil {
+		t.Fatal("Expected an error")
+	}
+	cmdErr := new(CommandError)
+	if !errors.As(err, &cmdErr) {
+		t.Fatalf("Expected a CommandError, got %T", err)
+	}
+	if cmdErr.Stderr != stderr {
+		t.Errorf("Got stderr %q, want %q", cmdErr.Stderr, stderr)
+	}
+	if !errors.Is(err, runnertest.ErrExit) {
+		t.Error("Expected the runner error to be wrapped")
+	}
+	if !strings.Contains(err.Error(), "undefined network backend") {
+		t.Errorf("Error message does not include stderr: %s", err)
+	}
+}
+
+func TestLifecycleArgs(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func(cli *Client) error
+		want string
+	}{
+		{"down", func(cli *Client) error {
+			return cli.Down(context.Background(), "/apps/demo", DownOptions{RemoveOrphans: true, Volumes: true, Timeout: 30 * time.Second})
+		}, "docker compose down --remove-orphans --volumes --timeout 30"},
+		{"stop", func(cli *Client) error {
+			return cli.Stop(context.Background(), "/apps/demo", StopOptions{Services: []string{"web"}, Timeout: 5 * time.Second})
+		}, "docker compose stop --timeout 5 -- web"},
+		{"partial second timeout", func(cli *Client) error {
+			return cli.Stop(context.Background(), "/apps/demo", StopOptions{Timeout: 1500 * time.Millisecond})
+		}, "docker compose stop --timeout 2"},
+		{"sub-second timeout", func(cli *Client) error {
+			return cli.Down(context.Background(), "/apps/demo", DownOptions{Timeout: 200 * time.Millisecond})
+		}, "docker compose down --timeout 1"},
+		{"start", func(cli *Client) error {
+			return cli.Start(context.Background(), "/apps/demo", StartOptions{})
+		}, "docker compose start"},
+		{"restart", func(cli *Client) error {
+			return cli.Restart(context.Background(), "/apps/demo", RestartOptions{Services: []string{"web", "db"}})
+		}, "docker compose restart -- web db"},
+		{"pull", func(cli *Client) error {
+			return cli.Pull(context.Background(), "/apps/demo", PullOptions{IgnoreFailures: true})
+		}, "docker compose pull --quiet --ignore-pull-failures"},
+		{"build", func(cli *Client) error {
+			return cli.Build(context.Background(), "/apps/demo", BuildOptions{NoCache: true, Services: []string{"web"}})
+		}, "docker compose build --no-cache -- web"},
+		{"rm", func(cli *Client) error {
+			return cli.Rm(context.Background(), "/apps/demo", RmOptions{Stop: true})
+		}, "docker compose rm --force --stop"},
+		{"flag as service", func(cli *Client) error {
+			return cli.Rm(context.Background(), "/apps/demo", RmOptions{Services: []string{"--volumes"}})
+		}, "docker compose rm --force -- --volumes"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := runnertest.New()
+			if err := tc.run(newTestClient(t, fake)); err != nil {
+				t.Fatalf("Returned error: %s", err)
+			}
+			if got := fake.Commands(); !reflect.DeepEqual(got, []string{tc.want}) {
+				t.Errorf("Got %v, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestLogs(t *testing.T) {
+	fake := runnertest.New().On("docker compose logs", runnertest.Response{
+		Stdout: "web-1  | hello\nweb-1  | world\n",
+	})
+	logs, err := newTestClient(t, fake).Logs(context.Background(), "/apps/demo", LogsOptions{
+		Services:   []string{"web"},
+		Follow:     true,
+		Tail:       10,
+		Since:      "5m",
+		Timestamps: true,
+	})
+	if err != nil {
+		t.Fatalf("Logs returned error: %s", err)
+	}
+	data, err := io.ReadAll(logs)
+	if err != nil {
+		t.Fatalf("Failed to read logs: %s", err)
+	}
+	if err := logs.Close(); err != nil {
+		t.Fatalf("Close returned error: %s", err)
+	}
+	if string(data) != "web-1  | hello\nweb-1  | world\n" {
+		t.Errorf("Unexpected logs %q", data)
+	}
+	want := "docker compose logs --no-color --follow --tail 10 --since 5m --timestamps -- web"
+	if got := fake.Commands(); !reflect.DeepEqual(got, []string{want}) {
+		t.Errorf("Got %v, want %q", got, want)
+	}
+}
+
+func TestLogsCloseError(t *testing.T) {
+	fake := runnertest.New().On("docker compose logs", runnertest.Response{
+		Stderr: "no such service: api",
+		Err:    runnertest.ErrExit,
+	})
+	logs, err := newTestClient(t, fake).Logs(context.Background(), "/apps/demo", LogsOptions{Services: []string{"api"}})
+	if err != nil {
+		t.Fatalf("Logs returned error: %s", err)
+	}
+	io.ReadAll(logs)
+	cmdErr := new(CommandError)
+	if err := logs.Close(); !errors.As(err, &cmdErr) || cmdErr.Stderr != "no such service: api" {
+		t.Errorf("Expected a CommandError with stderr, got %v", err)
+	}
+}
+
+func TestCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := newTestClient(t, runnertest.New()).Up(ctx, "/apps/demo")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+	if errors.Is(err, runnertest.ErrExit) {
+		t.Error("Cancellation should not look like a failed command")
+	}
+}
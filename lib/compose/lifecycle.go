@@ -0,0 +1,173 @@
+package compose
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+type DownOptions struct {
+	RemoveOrphans bool
+	Volumes       bool
+	Timeout       time.Duration
+}
+
+type StopOptions struct {
+	Services []string
+	Timeout  time.Duration
+}
+
+type StartOptions struct {
+	Services []string
+}
+
+type RestartOptions struct {
+	Services []string
+	Timeout  time.Duration
+}
+
+type PullOptions struct {
+	Services       []string
+	IgnoreFailures bool
+}
+
+type BuildOptions struct {
+	Services []string
+	NoCache  bool
+	Pull     bool
+}
+
+type RmOptions struct {
+	Services []string
+	Stop     bool
+	Volumes  bool
+}
+
+func timeoutArgs(timeout time.Duration) []string {
+	if timeout <= 0 {
+		return nil
+	}
+	// Round up so a timeout under a second is not sent as 0, which compose
+	// takes to mean kill immediately.
+	return []string{"--timeout", strconv.Itoa(int(math.Ceil(timeout.Seconds())))}
+}
+
+func flagArg(set bool, flag string) []string {
+	if !set {
+		return nil
+	}
+	return []string{flag}
+}
+
+// serviceArgs ends the flags before the service names, which may come from
+// user input, so a name starting with "-" cannot be taken for a flag.
+func serviceArgs(services []string) []string {
+	if len(services) == 0 {
+		return nil
+	}
+	return append([]string{"--"}, services...)
+}
+
+func joinArgs(groups ...[]string) []string {
+	args := make([]string, 0)
+	for _, group := range groups {
+		args = append(args, group...)
+	}
+	return args
+}
+
+// Down stops and removes the containers and networks of the project at path.
+func (c *Client) Down(ctx context.Context, path string, opts DownOptions) error {
+	_, err := c.command(ctx, path, joinArgs(
+		[]string{"down"},
+		flagArg(opts.RemoveOrphans, "--remove-orphans"),
+		flagArg(opts.Volumes, "--volumes"),
+		timeoutArgs(opts.Timeout),
+	)...)
+	if err != nil {
+		return fmt.Errorf("Error running compose down: %w", err)
+	}
+	return nil
+}
+
+// Stop stops the given services, or every service if none are given.
+func (c *Client) Stop(ctx context.Context, path string, opts StopOptions) error {
+	_, err := c.command(ctx, path, joinArgs(
+		[]string{"stop"},
+		timeoutArgs(opts.Timeout),
+		serviceArgs(opts.Services),
+	)...)
+	if err != nil {
+		return fmt.Errorf("Error running compose stop: %w", err)
+	}
+	return nil
+}
+
+// Start starts existing containers for the given services, or every service
+// if none are given.
+func (c *Client) Start(ctx context.Context, path string, opts StartOptions) error {
+	_, err := c.command(ctx, path, joinArgs([]string{"start"}, serviceArgs(opts.Services))...)
+	if err != nil {
+		return fmt.Errorf("Error running compose start: %w", err)
+	}
+	return nil
+}
+
+// Restart restarts the given services, or every service if none are given.
+func (c *Client) Restart(ctx context.Context, path string, opts RestartOptions) error {
+	_, err := c.command(ctx, path, joinArgs(
+		[]string{"restart"},
+		timeoutArgs(opts.Timeout),
+		serviceArgs(opts.Services),
+	)...)
+	if err != nil {
+		return fmt.Errorf("Error running compose restart: %w", err)
+	}
+	return nil
+}
+
+// Pull pulls the images for the given services, or every service if none are
+// given.
+func (c *Client) Pull(ctx context.Context, path string, opts PullOptions) error {
+	_, err := c.command(ctx, path, joinArgs(
+		[]string{"pull", "--quiet"},
+		flagArg(opts.IgnoreFailures, "--ignore-pull-failures"),
+		serviceArgs(opts.Services),
+	)...)
+	if err != nil {
+		return fmt.Errorf("Error running compose pull: %w", err)
+	}
+	return nil
+}
+
+// Build builds the images for the given services, or every service with a
+// build section if none are given.
+func (c *Client) Build(ctx context.Context, path string, opts BuildOptions) error {
+	_, err := c.command(ctx, path, joinArgs(
+		[]string{"build"},
+		flagArg(opts.NoCache, "--no-cache"),
+		flagArg(opts.Pull, "--pull"),
+		serviceArgs(opts.Services),
+	)...)
+	if err != nil {
+		return fmt.Errorf("Error running compose build: %w", err)
+	}
+	return nil
+}
+
+// Rm removes stopped containers for the given services, or every service if
+// none are given. It never prompts for confirmation.
+func (c *Client) Rm(ctx context.Context, path string, opts RmOptions) error {
+	_, err := c.command(ctx, path, joinArgs(
+		[]string{"rm", "--force"},
+		flagArg(opts.Stop, "--stop"),
+		flagArg(opts.Volumes, "--volumes"),
+		serviceArgs(opts.Services),
+	)...)
+	if err != nil {
+		return fmt.Errorf("Error running compose rm: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package compose
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+type LogsOptions struct {
+	Services []string
+	// Follow keeps the stream open and writes new output as it arrives.
+	Follow bool
+	// Tail limits the output to the last n lines of each container, all
+	// lines are returned when it is zero.
+	Tail int
+	// Since is a timestamp or relative duration such as 10m, as accepted by
+	// docker compose logs.
+	Since      string
+	Timestamps bool
+}
+
+// Logs streams the logs of the project at path. The caller must close the
+// returned reader, which stops the underlying command when following.
+// Cancelling ctx also stops the command.
+func (c *Client) Logs(ctx context.Context, path string, opts LogsOptions) (io.ReadCloser, error) {
+	args := []string{"logs", "--no-color"}
+	if opts.Follow {
+		args = append(args, "--follow")
+	}
+	if opts.Tail > 0 {
+		args = append(args, "--tail", strconv.Itoa(opts.Tail))
+	}
+	if opts.Since != "" {
+		args = append(args, "--since", opts.Since)
+	}
+	if opts.Timestamps {
+		args = append(args, "--timestamps")
+	}
+	args = append(args, serviceArgs(opts.Services)...)
+
+	logs, err := c.stream(ctx, path, args...)
+	if err != nil {
+		return nil, fmt.Errorf("Error running compose logs: %w", err)
+	}
+	return logs, nil
+}
@@ -1,15 +1,21 @@
 package compose
 
 import (
-	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"time"
+
+	"github.com/mr55p-dev/app-utils/lib/runner"
 )
 
+type ConfigFn func(*Client)
 type Client struct {
-	dir string
+	dir    string
+	runner runner.Runner
 }
 
 type ListEntry struct {
@@ -18,7 +24,12 @@ type ListEntry struct {
 	ConfigFiles string `json:"ConfigFiles"`
 }
 
-func New(root string) (*Client, error) {
+// WithRunner sets the runner used to execute docker compose.
+func WithRunner(r runner.Runner) ConfigFn {
+	return func(c *Client) { c.runner = r }
+}
+
+func New(root string, config ...ConfigFn) (*Client, error) {
 	stat, err := os.Stat(root)
 	if err != nil {
 		return nil, fmt.Errorf("Invalid root for FSClient: %w", err)
@@ -26,17 +37,92 @@ func New(root string) (*Client, error) {
 	if !stat.IsDir() {
 		return nil, fmt.Errorf("%s is not a directory", root)
 	}
-	return &Client{dir: root}, nil
+	cli := &Client{dir: root, runner: runner.Exec{}}
+	for _, fn := range config {
+		fn(cli)
+	}
+	return cli, nil
+}
+
+// Publisher is a port published by a container.
+type Publisher struct {
+	URL           string `json:"URL"`
+	TargetPort    int    `json:"TargetPort"`
+	PublishedPort int    `json:"PublishedPort"`
+	Protocol      string `json:"Protocol"`
+}
+
+func (p Publisher) String() string {
+	if p.PublishedPort == 0 {
+		return fmt.Sprintf("%d/%s", p.TargetPort, p.Protocol)
+	}
+	return fmt.Sprintf("%s:%d->%d/%s", p.URL, p.PublishedPort, p.TargetPort, p.Protocol)
 }
 
+// PsEntry is a container as reported by `docker compose ps --format json`.
 type PsEntry struct {
-	Name   string `json:"Name"`
-	Status string `json:"Status"`
+	ID         string      `json:"ID"`
+	Name       string      `json:"Name"`
+	Service    string      `json:"Service"`
+	Image      string      `json:"Image"`
+	State      string      `json:"State"`
+	Health     string      `json:"Health"`
+	ExitCode   int         `json:"ExitCode"`
+	Status     string      `json:"Status"`
+	Publishers []Publisher `json:"Publishers"`
+	CreatedAt  string      `json:"CreatedAt"`
+	Created    int64       `json:"Created"`
+}
+
+const createdAtLayout = "2006-01-02 15:04:05 -0700 MST"
+
+// CreatedTime returns when the container was created, or the zero time if
+// compose did not report it.
+func (p PsEntry) CreatedTime() time.Time {
+	if p.Created != 0 {
+		return time.Unix(p.Created, 0)
+	}
+	created, err := time.Parse(createdAtLayout, p.CreatedAt)
+	if err != nil {
+		return time.Time{}
+	}
+	return created
+}
+
+// parsePs decodes the output of `docker compose ps --format json`. Older
+// compose releases print a single JSON array while newer ones print one
+// object per line, so both are accepted.
+func parsePs(output []byte) ([]PsEntry, error) {
+	containers := make([]PsEntry, 0)
+	output = bytes.TrimSpace(output)
+	if len(output) == 0 {
+		return containers, nil
+	}
+	if output[0] == '[' {
+		if err := json.Unmarshal(output, &containers); err != nil {
+			return nil, fmt.Errorf("Error unmarshalling output: %w", err)
+		}
+		return containers, nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(output))
+	for {
+		proc := PsEntry{}
+		err := dec.Decode(&proc)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Error unmarshalling output: %w", err)
+		}
+		containers = append(containers, proc)
+	}
+	return containers, nil
 }
 
-func (c *Client) List() ([]ListEntry, error) {
+func (c *Client) List(ctx context.Context) ([]ListEntry, error) {
 	projects := make([]ListEntry, 0)
-	outBytes, err := command("/", "ls")
+	outBytes, err := c.command(ctx, "/", "ls")
 	if err != nil {
 		return nil, fmt.Errorf("Error running compose ls: %w", err)
 	}
@@ -46,26 +132,16 @@ func (c *Client) List() ([]ListEntry, error) {
 	return projects, nil
 }
 
-func (c *Client) Ps(path string) ([]PsEntry, error) {
-	output, err := command(path, "ps")
+func (c *Client) Ps(ctx context.Context, path string) ([]PsEntry, error) {
+	output, err := c.command(ctx, path, "ps", "--all", "--format", "json")
 	if err != nil {
 		return nil, fmt.Errorf("Error running docker compose ps: %w", err)
 	}
-
-	containers := make([]PsEntry, 0)
-	scanner := bufio.NewScanner(bytes.NewReader(output))
-	for scanner.Scan() {
-		proc := PsEntry{}
-		if err := json.Unmarshal(scanner.Bytes(), &proc); err != nil {
-			return nil, fmt.Errorf("Error unmarshalling output: %w", err)
-		}
-		containers = append(containers, proc)
-	}
-	return containers, nil
+	return parsePs(output)
 }
 
-func (c *Client) Up(path string) error {
-	_, err := command(path, "up", "-d")
+func (c *Client) Up(ctx context.Context, path string) error {
+	_, err := c.command(ctx, path, "up", "-d")
 	if err != nil {
 		return fmt.Errorf("Error running compose up: %w", err)
 	}
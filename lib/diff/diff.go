@@ -0,0 +1,167 @@
+// Package diff compares what is deployed with what is on disk.
+//
+// Unified compares two texts line by line and formats the result like
+// diff -u, and Keys reports which keys of a map were added, changed or
+// removed without exposing their values.
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+type Op int
+
+const (
+	Equal Op = iota
+	Delete
+	Insert
+)
+
+// Line is a line of an edit script. Text keeps its trailing newline, which
+// only the last line of a file may lack.
+type Line struct {
+	Op   Op
+	Text string
+}
+
+func splitLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// Lines returns the shortest edit script turning a into b, with the lines
+// deleted from a run of changes before those inserted.
+func Lines(a, b string) []Line {
+	as, bs := splitLines(a), splitLines(b)
+
+	// lcs[i][j] is the length of the longest common subsequence of as[i:]
+	// and bs[j:]. Compose and env files are small enough for the quadratic
+	// table.
+	lcs := make([][]int, len(as)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(bs)+1)
+	}
+	for i := len(as) - 1; i >= 0; i-- {
+		for j := len(bs) - 1; j >= 0; j-- {
+			if as[i] == bs[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	script := make([]Line, 0, max(len(as), len(bs)))
+	i, j := 0, 0
+	for i < len(as) && j < len(bs) {
+		switch {
+		case as[i] == bs[j]:
+			script = append(script, Line{Equal, as[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			script = append(script, Line{Delete, as[i]})
+			i++
+		default:
+			script = append(script, Line{Insert, bs[j]})
+			j++
+		}
+	}
+	for ; i < len(as); i++ {
+		script = append(script, Line{Delete, as[i]})
+	}
+	for ; j < len(bs); j++ {
+		script = append(script, Line{Insert, bs[j]})
+	}
+	return script
+}
+
+// hunkRange formats the start and length of a hunk's lines in one file.
+func hunkRange(start, length int) string {
+	switch length {
+	case 0:
+		return fmt.Sprintf("%d,0", start)
+	case 1:
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, length)
+}
+
+// hunks returns the [start, end) ranges of script to print, each covering a
+// group of changes with up to context unchanged lines around it.
+func hunks(script []Line, context int) [][2]int {
+	var out [][2]int
+	for i := 0; i < len(script); {
+		if script[i].Op == Equal {
+			i++
+			continue
+		}
+		start := max(0, i-context)
+		last := i
+		for j := i + 1; j < len(script); j++ {
+			if script[j].Op == Equal {
+				continue
+			}
+			if j-last-1 > 2*context {
+				break
+			}
+			last = j
+		}
+		end := min(len(script), last+1+context)
+		out = append(out, [2]int{start, end})
+		i = end
+	}
+	return out
+}
+
+// Unified returns the differences between a and b in unified format with
+// context lines of context around each change, labelling the files aName
+// and bName. It returns an empty string when they are equal.
+func Unified(aName, bName, a, b string, context int) string {
+	script := Lines(a, b)
+	ranges := hunks(script, context)
+	if len(ranges) == 0 {
+		return ""
+	}
+
+	out := new(strings.Builder)
+	fmt.Fprintf(out, "--- %s\n+++ %s\n", aName, bName)
+	aLine, bLine, pos := 0, 0, 0
+	for _, r := range ranges {
+		for ; pos < r[0]; pos++ {
+			aLine++
+			bLine++
+		}
+		aLen, bLen := 0, 0
+		for _, line := range script[r[0]:r[1]] {
+			if line.Op != Insert {
+				aLen++
+			}
+			if line.Op != Delete {
+				bLen++
+			}
+		}
+		fmt.Fprintf(out, "@@ -%s +%s @@\n", hunkRange(aLine, aLen), hunkRange(bLine, bLen))
+		for _, line := range script[r[0]:r[1]] {
+			prefix := " "
+			switch line.Op {
+			case Delete:
+				prefix = "-"
+			case Insert:
+				prefix = "+"
+			}
+			out.WriteString(prefix + line.Text)
+			if !strings.HasSuffix(line.Text, "\n") {
+				out.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		aLine += aLen
+		bLine += bLen
+		pos = r[1]
+	}
+	return out.String()
+}
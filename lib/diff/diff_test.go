@@ -0,0 +1,71 @@
+package diff
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func numbered(from, to int) string {
+	b := new(strings.Builder)
+	for i := from; i <= to; i++ {
+		b.WriteString(strings.Repeat("x", i) + "\n")
+	}
+	return b.String()
+}
+
+func TestUnified(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want string
+	}{
+		{"equal", "a\nb\n", "a\nb\n", ""},
+		{"both empty", "", "", ""},
+		{"change", "a\nb\nc\n", "a\nB\nc\n",
+			"--- old\n+++ new\n@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n"},
+		{"created", "", "a\nb\n",
+			"--- old\n+++ new\n@@ -0,0 +1,2 @@\n+a\n+b\n"},
+		{"emptied", "a\n", "",
+			"--- old\n+++ new\n@@ -1 +0,0 @@\n-a\n"},
+		{"append", "a\nb\n", "a\nb\nc\n",
+			"--- old\n+++ new\n@@ -1,2 +1,3 @@\n a\n b\n+c\n"},
+		{"no newline at end", "a\nb", "a\nb\n",
+			"--- old\n+++ new\n@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+b\n"},
+		{"trimmed context", numbered(1, 10), strings.Replace(numbered(1, 10), "xxxxx\n", "five\n", 1),
+			"--- old\n+++ new\n@@ -2,7 +2,7 @@\n xx\n xxx\n xxxx\n-xxxxx\n+five\n xxxxxx\n xxxxxxx\n xxxxxxxx\n"},
+		{"separate hunks", numbered(1, 12), "changed\n" + numbered(2, 11) + "end\n",
+			"--- old\n+++ new\n@@ -1,4 +1,4 @@\n-x\n+changed\n xx\n xxx\n xxxx\n" +
+				"@@ -9,4 +9,4 @@\n xxxxxxxxx\n xxxxxxxxxx\n xxxxxxxxxxx\n-xxxxxxxxxxxx\n+end\n"},
+		{"merged hunks", numbered(1, 8), "changed\n" + numbered(2, 7) + "end\n",
+			"--- old\n+++ new\n@@ -1,8 +1,8 @@\n-x\n+changed\n xx\n xxx\n xxxx\n xxxxx\n xxxxxx\n xxxxxxx\n-xxxxxxxx\n+end\n"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := Unified("old", "new", tc.a, tc.b, 3)
+			if got != tc.want {
+				t.Errorf("Got diff\n%s\nwant\n%s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestLines(t *testing.T) {
+	got := Lines("a\nb\nc\n", "a\nc\nd\n")
+	want := []Line{{Equal, "a\n"}, {Delete, "b\n"}, {Equal, "c\n"}, {Insert, "d\n"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Got %v, want %v", got, want)
+	}
+}
+
+func TestKeys(t *testing.T) {
+	old := map[string]string{"KEEP": "1", "CHANGE": "a", "DROP": "x"}
+	new := map[string]string{"KEEP": "1", "CHANGE": "b", "ADD": "y"}
+	want := []KeyChange{{"ADD", Added}, {"CHANGE", Changed}, {"DROP", Removed}}
+	if got := Keys(old, new); !reflect.DeepEqual(got, want) {
+		t.Errorf("Got %v, want %v", got, want)
+	}
+	if got := Keys(old, old); len(got) != 0 {
+		t.Errorf("Got %v for equal maps, want no changes", got)
+	}
+}
@@ -0,0 +1,41 @@
+package diff
+
+import "sort"
+
+type Change string
+
+const (
+	Added   Change = "added"
+	Changed Change = "changed"
+	Removed Change = "removed"
+)
+
+// KeyChange is a key whose value differs between two maps.
+type KeyChange struct {
+	Key    string `json:"key"`
+	Change Change `json:"change"`
+}
+
+// Keys returns the keys added, changed or removed going from old to new,
+// sorted by key. Values are left out as they are often secrets.
+func Keys(old, new map[string]string) []KeyChange {
+	changes := make([]KeyChange, 0)
+	for key, value := range new {
+		prev, ok := old[key]
+		switch {
+		case !ok:
+			changes = append(changes, KeyChange{key, Added})
+		case prev != value:
+			changes = append(changes, KeyChange{key, Changed})
+		}
+	}
+	for key := range old {
+		if _, ok := new[key]; !ok {
+			changes = append(changes, KeyChange{key, Removed})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Key < changes[j].Key
+	})
+	return changes
+}
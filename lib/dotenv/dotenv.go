@@ -0,0 +1,281 @@
+// Package dotenv reads and writes env files such as stack.env.
+//
+// Parse accepts the common dotenv dialect: blank lines and # comments are
+// skipped, keys may be prefixed with export, values may be bare, single
+// quoted (taken literally) or double quoted (with backslash escapes), and
+// quoted values may span several lines. Write produces output Parse reads
+// back unchanged, quoting values only when they need it.
+package dotenv
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Entry is a single variable from an env file.
+type Entry struct {
+	Key   string
+	Value string
+}
+
+// ParseError reports a malformed line.
+type ParseError struct {
+	Line int
+	Msg  string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("Line %d: %s", e.Line, e.Msg)
+}
+
+var (
+	validKey  = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+	bareValue = regexp.MustCompile(`^[A-Za-z0-9_./:@%+,=-]*$`)
+)
+
+// ValidKey reports whether key can be written to an env file.
+func ValidKey(key string) bool {
+	return validKey.MatchString(key)
+}
+
+// Parse reads the entries of an env file in the order they appear. Repeated
+// keys are returned as they are, see Dedupe.
+func Parse(r io.Reader) ([]Entry, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read env file: %w", err)
+	}
+	p := &parser{src: strings.ReplaceAll(string(data), "\r\n", "\n"), line: 1}
+
+	entries := make([]Entry, 0)
+	for {
+		entry, ok, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return entries, nil
+		}
+		entries = append(entries, entry)
+	}
+}
+
+type parser struct {
+	src  string
+	pos  int
+	line int
+}
+
+func (p *parser) fail(line int, format string, args ...any) error {
+	return &ParseError{Line: line, Msg: fmt.Sprintf(format, args...)}
+}
+
+// skipSpace moves past spaces and tabs and returns the remaining input.
+func (p *parser) skipSpace() string {
+	rest := strings.TrimLeft(p.src[p.pos:], " \t")
+	p.pos = len(p.src) - len(rest)
+	return rest
+}
+
+// readLine returns the rest of the current line and moves past it.
+func (p *parser) readLine() string {
+	rest := p.src[p.pos:]
+	end := strings.IndexByte(rest, '\n')
+	if end < 0 {
+		p.pos = len(p.src)
+		return rest
+	}
+	p.pos += end + 1
+	p.line++
+	return rest[:end]
+}
+
+// next returns the next entry, skipping blank lines and comments. ok is false
+// once the input is exhausted.
+func (p *parser) next() (entry Entry, ok bool, err error) {
+	for p.pos < len(p.src) {
+		rest := p.skipSpace()
+		if rest == "" || rest[0] == '\n' || rest[0] == '#' {
+			p.readLine()
+			continue
+		}
+		if strings.HasPrefix(rest, "export ") || strings.HasPrefix(rest, "export\t") {
+			p.pos += len("export")
+			rest = p.skipSpace()
+		}
+
+		eq := strings.IndexAny(rest, "=\n")
+		if eq < 0 || rest[eq] != '=' {
+			line := p.line
+			return Entry{}, false, p.fail(line, "expected KEY=VALUE, got %q", strings.TrimSpace(p.readLine()))
+		}
+		key := strings.TrimRight(rest[:eq], " \t")
+		if !ValidKey(key) {
+			return Entry{}, false, p.fail(p.line, "invalid key %q", key)
+		}
+		p.pos += eq + 1
+
+		value, err := p.value()
+		if err != nil {
+			return Entry{}, false, err
+		}
+		return Entry{Key: key, Value: value}, true, nil
+	}
+	return Entry{}, false, nil
+}
+
+// value reads the value following an "=", including the end of its line.
+func (p *parser) value() (string, error) {
+	rest := p.skipSpace()
+	if rest == "" {
+		return "", nil
+	}
+
+	switch rest[0] {
+	case '\'', '"':
+		value, err := p.quoted(rest[0])
+		if err != nil {
+			return "", err
+		}
+		line := p.line
+		trailing := strings.TrimSpace(p.readLine())
+		if trailing != "" && trailing[0] != '#' {
+			return "", p.fail(line, "unexpected %q after quoted value", trailing)
+		}
+		return value, nil
+	}
+
+	line := p.readLine()
+	if i := strings.Index(line, " #"); i >= 0 {
+		line = line[:i]
+	}
+	if i := strings.Index(line, "\t#"); i >= 0 {
+		line = line[:i]
+	}
+	return strings.TrimSpace(line), nil
+}
+
+// quoted reads a value starting at the opening quote and leaves the parser
+// just after the closing one. Single quoted values are taken literally.
+func (p *parser) quoted(quote byte) (string, error) {
+	start := p.line
+	p.pos++
+	value := new(strings.Builder)
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		p.pos++
+		switch {
+		case c == quote:
+			return value.String(), nil
+		case c == '\n':
+			p.line++
+			value.WriteByte(c)
+		case c == '\\' && quote == '"' && p.pos < len(p.src):
+			escaped := p.src[p.pos]
+			p.pos++
+			switch escaped {
+			case 'n':
+				value.WriteByte('\n')
+			case 'r':
+				value.WriteByte('\r')
+			case 't':
+				value.WriteByte('\t')
+			case '\\', '"', '$':
+				value.WriteByte(escaped)
+			case '\n':
+				// A backslash before a newline continues the line.
+				p.line++
+			default:
+				value.WriteByte('\\')
+				value.WriteByte(escaped)
+			}
+		default:
+			value.WriteByte(c)
+		}
+	}
+	return "", p.fail(start, "unterminated %c quoted value", quote)
+}
+
+// Quote formats value so Parse reads it back unchanged. Values made only of
+// common punctuation are left bare, others are single quoted where possible
+// so they are taken literally, and double quoted with escapes otherwise.
+func Quote(value string) string {
+	if bareValue.MatchString(value) {
+		return value
+	}
+	if !strings.ContainsAny(value, "'\n\r") {
+		return "'" + value + "'"
+	}
+	escaped := strings.NewReplacer(
+		`\`, `\\`,
+		`"`, `\"`,
+		`$`, `\$`,
+		"\n", `\n`,
+		"\r", `\r`,
+		"\t", `\t`,
+	).Replace(value)
+	return `"` + escaped + `"`
+}
+
+// Write writes entries one per line in the order given.
+func Write(w io.Writer, entries []Entry) error {
+	bw := bufio.NewWriter(w)
+	for _, entry := range entries {
+		if !ValidKey(entry.Key) {
+			return fmt.Errorf("Invalid env key %q", entry.Key)
+		}
+		if _, err := fmt.Fprintf(bw, "%s=%s\n", entry.Key, Quote(entry.Value)); err != nil {
+			return fmt.Errorf("Failed to write env: %w", err)
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("Failed to write env: %w", err)
+	}
+	return nil
+}
+
+// FromMap returns the entries of m sorted by key, so maps are always written
+// in the same order.
+func FromMap[V any](m map[string]V) []Entry {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	entries := make([]Entry, 0, len(keys))
+	for _, key := range keys {
+		entries = append(entries, Entry{Key: key, Value: format(m[key])})
+	}
+	return entries
+}
+
+func format(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	}
+	return fmt.Sprint(value)
+}
+
+// Dedupe collapses repeated keys, keeping the position of the first
+// occurrence and the value of the last, matching how an env file is loaded.
+func Dedupe(entries []Entry) []Entry {
+	index := make(map[string]int, len(entries))
+	out := make([]Entry, 0, len(entries))
+	for _, entry := range entries {
+		if i, ok := index[entry.Key]; ok {
+			out[i].Value = entry.Value
+			continue
+		}
+		index[entry.Key] = len(out)
+		out = append(out, entry)
+	}
+	return out
+}
@@ -0,0 +1,190 @@
+package dotenv
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []Entry
+	}{
+		{"empty", "", []Entry{}},
+		{"simple", "KEY=value\n", []Entry{{"KEY", "value"}}},
+		{"no trailing newline", "KEY=value", []Entry{{"KEY", "value"}}},
+		{"crlf", "A=1\r\nB=2\r\n", []Entry{{"A", "1"}, {"B", "2"}}},
+		{"equals in value", "SECRET=dGVzdA==\n", []Entry{{"SECRET", "dGVzdA=="}}},
+		{"connection string", "DSN=postgres://u:p@db/app?sslmode=disable&x=y\n",
+			[]Entry{{"DSN", "postgres://u:p@db/app?sslmode=disable&x=y"}}},
+		{"empty value", "EMPTY=\nNEXT=1\n", []Entry{{"EMPTY", ""}, {"NEXT", "1"}}},
+		{"empty quoted value", "A=''\nB=\"\"\n", []Entry{{"A", ""}, {"B", ""}}},
+		{"blank lines and comments", "\n# comment\n   \n  # indented\nKEY=value\n\n", []Entry{{"KEY", "value"}}},
+		{"inline comment", "KEY=value # note\n", []Entry{{"KEY", "value"}}},
+		{"hash without space", "COLOR=#fff\n", []Entry{{"COLOR", "#fff"}}},
+		{"surrounding whitespace", "  KEY = value  \n", []Entry{{"KEY", "value"}}},
+		{"export", "export KEY=value\nexport\tOTHER=1\n", []Entry{{"KEY", "value"}, {"OTHER", "1"}}},
+		{"key named export", "export=1\n", []Entry{{"export", "1"}}},
+		{"single quoted", "KEY='a \\n $HOME \"x\"'\n", []Entry{{"KEY", `a \n $HOME "x"`}}},
+		{"double quoted", `KEY="a b"` + "\n", []Entry{{"KEY", "a b"}}},
+		{"double quoted escapes", `KEY="line\nnext\ttab \"q\" \\ \$HOME \x"` + "\n",
+			[]Entry{{"KEY", "line\nnext\ttab \"q\" \\ $HOME \\x"}}},
+		{"quoted with comment", `KEY="a # b" # comment` + "\n", []Entry{{"KEY", "a # b"}}},
+		{"multiline double", "CERT=\"-----BEGIN-----\nabc\n-----END-----\"\nNEXT=1\n",
+			[]Entry{{"CERT", "-----BEGIN-----\nabc\n-----END-----"}, {"NEXT", "1"}}},
+		{"multiline single", "KEY='one\ntwo'\n", []Entry{{"KEY", "one\ntwo"}}},
+		{"line continuation", "KEY=\"one \\\ntwo\"\n", []Entry{{"KEY", "one two"}}},
+		{"duplicates kept", "A=1\nA=2\n", []Entry{{"A", "1"}, {"A", "2"}}},
+		{"dotted key", "spring.profile=dev\n", []Entry{{"spring.profile", "dev"}}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Parse(strings.NewReader(tc.input))
+			if err != nil {
+				t.Fatalf("Parse returned error: %s", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		line  int
+	}{
+		{"missing equals", "A=1\nNOVALUE\n", 2},
+		{"invalid key", "1KEY=value\n", 1},
+		{"space in key", "MY KEY=value\n", 1},
+		{"empty key", "=value\n", 1},
+		{"unterminated double", "A=1\nKEY=\"open\nstill open\n", 2},
+		{"unterminated single", "KEY='open\n", 1},
+		{"text after quote", "KEY=\"a\"b\n", 1},
+		{"error after multiline", "KEY=\"a\nb\"\nBAD\n", 3},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Parse(strings.NewReader(tc.input))
+			parseErr := new(ParseError)
+			if !errors.As(err, &parseErr) {
+				t.Fatalf("Expected a ParseError, got %v", err)
+			}
+			if parseErr.Line != tc.line {
+				t.Errorf("Got error on line %d, want %d: %s", parseErr.Line, tc.line, err)
+			}
+		})
+	}
+}
+
+func TestQuote(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"", ""},
+		{"plain", "plain"},
+		{"https://example.com/path", "https://example.com/path"},
+		{"dGVzdA==", "dGVzdA=="},
+		{"two words", "'two words'"},
+		{"$HOME", "'$HOME'"},
+		{"#fff", "'#fff'"},
+		{`say "hi"`, `'say "hi"'`},
+		{"it's", `"it's"`},
+		{"one\ntwo", `"one\ntwo"`},
+		{`it's $5 \ "x"`, `"it's \$5 \\ \"x\""`},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.value, func(t *testing.T) {
+			if got := Quote(tc.value); got != tc.want {
+				t.Errorf("Got %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestWrite(t *testing.T) {
+	buf := new(bytes.Buffer)
+	err := Write(buf, []Entry{
+		{"B", "2"},
+		{"A", "has space"},
+		{"EMPTY", ""},
+	})
+	if err != nil {
+		t.Fatalf("Write returned error: %s", err)
+	}
+	want := "B=2\nA='has space'\nEMPTY=\n"
+	if buf.String() != want {
+		t.Errorf("Got %q, want %q", buf.String(), want)
+	}
+
+	if err := Write(new(bytes.Buffer), []Entry{{"BAD KEY", "x"}}); err == nil {
+		t.Error("Expected an error for an invalid key")
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	values := []string{
+		"",
+		"plain",
+		"a=b=c",
+		"with spaces",
+		" leading and trailing ",
+		"#not a comment",
+		"value # with hash",
+		"it's",
+		`"double"`,
+		`back\slash`,
+		"$VAR and ${OTHER}",
+		"multi\nline\nvalue",
+		"tab\tand\rreturn",
+		"-----BEGIN KEY-----\nabc'def\n-----END KEY-----\n",
+	}
+
+	entries := make([]Entry, 0, len(values))
+	for i, value := range values {
+		entries = append(entries, Entry{Key: "KEY_" + string(rune('A'+i)), Value: value})
+	}
+
+	buf := new(bytes.Buffer)
+	if err := Write(buf, entries); err != nil {
+		t.Fatalf("Write returned error: %s", err)
+	}
+	got, err := Parse(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Parse returned error: %s\n%s", err, buf)
+	}
+	if !reflect.DeepEqual(got, entries) {
+		t.Errorf("Round trip changed entries\ngot:  %q\nwant: %q\nfile:\n%s", got, entries, buf)
+	}
+}
+
+func TestFromMap(t *testing.T) {
+	got := FromMap(map[string]any{
+		"B":     2,
+		"A":     "one",
+		"C":     true,
+		"EMPTY": nil,
+	})
+	want := []Entry{{"A", "one"}, {"B", "2"}, {"C", "true"}, {"EMPTY", ""}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Got %q, want %q", got, want)
+	}
+}
+
+func TestDedupe(t *testing.T) {
+	got := Dedupe([]Entry{{"A", "1"}, {"B", "2"}, {"A", "3"}, {"C", "4"}, {"B", "5"}})
+	want := []Entry{{"A", "3"}, {"B", "5"}, {"C", "4"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Got %q, want %q", got, want)
+	}
+}
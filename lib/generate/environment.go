@@ -0,0 +1,216 @@
+package generate
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/mr55p-dev/app-utils/config"
+	"github.com/mr55p-dev/app-utils/lib/dotenv"
+)
+
+func sanitizeHost(hostname string) string {
+	s := hostname
+	s = strings.ReplaceAll(s, "-", "_")
+	s = strings.ReplaceAll(s, " ", "_")
+	return strings.ToUpper(s)
+}
+
+const (
+	// SourceGenerated marks values derived from the app's nginx blocks.
+	SourceGenerated = "generated"
+	// SourceRuntime marks values from the runtime env in app.yml.
+	SourceRuntime = "runtime"
+)
+
+// ExtensionSource names the source for values from an env extension.
+func ExtensionSource(name string) string {
+	return "extension:" + name
+}
+
+var ErrEnvConflict = errors.New("Env keys are defined by more than one source")
+
+// ConflictError is returned when env-conflicts is set to error and a key is
+// defined by more than one source.
+type ConflictError struct {
+	Conflicts []EnvValue
+}
+
+func (e *ConflictError) Error() string {
+	descriptions := make([]string, len(e.Conflicts))
+	for i, conflict := range e.Conflicts {
+		descriptions[i] = conflict.Conflict()
+	}
+	return fmt.Sprintf("%s: %s", ErrEnvConflict, strings.Join(descriptions, ", "))
+}
+
+func (e *ConflictError) Is(target error) bool {
+	return target == ErrEnvConflict
+}
+
+// EnvValue is a single stack.env value and where it came from.
+type EnvValue struct {
+	Key    string
+	Value  string
+	Source string
+	// Overrides is the source of the earlier value this one replaces, if
+	// any.
+	Overrides string
+}
+
+// Conflict describes the override, such as "LOG_LEVEL: runtime overrides
+// extension:logging".
+func (v EnvValue) Conflict() string {
+	return fmt.Sprintf("%s: %s overrides %s", v.Key, v.Source, v.Overrides)
+}
+
+// EnvGroup holds the values contributed by one source, sorted by key.
+type EnvGroup struct {
+	Source string
+	Values []EnvValue
+}
+
+// StackEnv is the generated environment for an app, grouped by source in the
+// order the sources are applied.
+type StackEnv struct {
+	Groups []EnvGroup
+	mode   config.EnvConflicts
+}
+
+// Overrides returns every value that replaces one from an earlier source.
+func (e *StackEnv) Overrides() []EnvValue {
+	out := make([]EnvValue, 0)
+	for _, group := range e.Groups {
+		for _, value := range group.Values {
+			if value.Overrides != "" {
+				out = append(out, value)
+			}
+		}
+	}
+	return out
+}
+
+// Conflicts returns the overrides that should be reported to the user, which
+// is none when env-conflicts is set to allow.
+func (e *StackEnv) Conflicts() []EnvValue {
+	if e.mode == config.EnvConflictsAllow {
+		return nil
+	}
+	return e.Overrides()
+}
+
+// Resolved returns the value each key ends up with, sorted by key. The
+// Source of each value is the source that won.
+func (e *StackEnv) Resolved() []EnvValue {
+	winners := make(map[string]EnvValue)
+	for _, group := range e.Groups {
+		for _, value := range group.Values {
+			winners[value.Key] = value
+		}
+	}
+	keys := make([]string, 0, len(winners))
+	for key := range winners {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	out := make([]EnvValue, 0, len(keys))
+	for _, key := range keys {
+		out = append(out, winners[key])
+	}
+	return out
+}
+
+// WriteTo writes the env file, each group under a comment naming its source
+// and each overriding value under a comment naming the source it replaces.
+func (e *StackEnv) WriteTo(w io.Writer) (int64, error) {
+	buf := new(bytes.Buffer)
+	for i, group := range e.Groups {
+		if i > 0 {
+			buf.WriteString("\n")
+		}
+		fmt.Fprintf(buf, "# %s\n", group.Source)
+		for _, value := range group.Values {
+			if value.Overrides != "" {
+				fmt.Fprintf(buf, "# overrides %s\n", value.Overrides)
+			}
+			entry := dotenv.Entry{Key: value.Key, Value: value.Value}
+			if err := dotenv.Write(buf, []dotenv.Entry{entry}); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return buf.WriteTo(w)
+}
+
+// add appends a group for source, recording which values override earlier
+// ones. Groups with no values are left out.
+func (e *StackEnv) add(source string, entries []dotenv.Entry, seen map[string]string) {
+	if len(entries) == 0 {
+		return
+	}
+	group := EnvGroup{Source: source}
+	for _, entry := range entries {
+		value := EnvValue{Key: entry.Key, Value: entry.Value, Source: source}
+		if previous, ok := seen[entry.Key]; ok {
+			value.Overrides = previous
+		}
+		seen[entry.Key] = source
+		group.Values = append(group.Values, value)
+	}
+	e.Groups = append(e.Groups, group)
+}
+
+// NewStackEnv collects the environment for appConfig. Generated values come
+// first, followed by each env extension in the order they are listed and
+// finally the app's own runtime env, so later sources win. If the app sets
+// env-conflicts to error and any key is overridden a *ConflictError is
+// returned.
+func NewStackEnv(appConfig config.AppConfig, extensions config.Extensions) (*StackEnv, error) {
+	env := &StackEnv{mode: appConfig.Runtime.EnvConflicts}
+	switch env.mode {
+	case "":
+		env.mode = config.EnvConflictsWarn
+	case config.EnvConflictsAllow, config.EnvConflictsWarn, config.EnvConflictsError:
+	default:
+		return nil, fmt.Errorf("Unknown env-conflicts mode %q, expected allow, warn or error", env.mode)
+	}
+	seen := make(map[string]string)
+
+	generated := make(map[string]string)
+	for _, nginx := range appConfig.Nginx {
+		generated[fmt.Sprintf("CFG_IPV4_%s", sanitizeHost(nginx.ExternalHost))] = nginx.IPv4
+	}
+	env.add(SourceGenerated, dotenv.FromMap(generated), seen)
+
+	for _, extensionName := range appConfig.Runtime.EnvExtensions {
+		ext, ok := extensions[extensionName]
+		if !ok {
+			return nil, fmt.Errorf("Env extension %s: not found", extensionName)
+		}
+		env.add(ExtensionSource(extensionName), dotenv.FromMap(ext), seen)
+	}
+	env.add(SourceRuntime, dotenv.FromMap(appConfig.Runtime.Env), seen)
+
+	if overrides := env.Overrides(); env.mode == config.EnvConflictsError && len(overrides) > 0 {
+		return nil, &ConflictError{Conflicts: overrides}
+	}
+	return env, nil
+}
+
+// Environment renders the stack.env contents for appConfig, see NewStackEnv
+// and StackEnv.WriteTo.
+func Environment(appConfig config.AppConfig, extensions config.Extensions) (io.Reader, error) {
+	env, err := NewStackEnv(appConfig, extensions)
+	if err != nil {
+		return nil, err
+	}
+	stackEnvData := new(bytes.Buffer)
+	if _, err := env.WriteTo(stackEnvData); err != nil {
+		return nil, fmt.Errorf("Failed to write stack env: %w", err)
+	}
+	return stackEnvData, nil
+}
@@ -0,0 +1,281 @@
+package generate
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"text/template"
+
+	"github.com/mr55p-dev/app-utils/config"
+	"github.com/mr55p-dev/app-utils/embed"
+	"github.com/mr55p-dev/app-utils/lib/dotenv"
+)
+
+var update = flag.Bool("update", false, "Update golden files")
+
+func checkGolden(t *testing.T, name string, r io.Reader) {
+	t.Helper()
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("Failed to read output: %s", err)
+	}
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatalf("Failed to update golden file: %s", err)
+		}
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read golden file: %s", err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("Output does not match %s\n--- got ---\n%s\n--- want ---\n%s", path, got, want)
+	}
+}
+
+func testAppConfig() config.AppConfig {
+	appConfig := config.AppConfig{
+		App: "example",
+		Nginx: []config.NginxBlock{
+			{ExternalHost: "example", Protocol: "http", IPv4: "10.0.0.2", Port: 8080},
+			{ExternalHost: "example-admin", Protocol: "https", IPv4: "10.0.0.2", Port: 8443, Protected: true},
+		},
+	}
+	appConfig.Runtime.EnvExtensions = []string{"postgres", "smtp"}
+	appConfig.Runtime.Env = map[string]any{
+		"LOG_LEVEL": "debug",
+		"WORKERS":   4,
+		"APP_URL":   "https://example.home.pagemail.io",
+		"GREETING":  "hello world",
+		"SECRET":    "c2VjcmV0==",
+		"TLS_CERT":  "-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----",
+		"UNSET":     nil,
+		"PGHOST":    "db.example.internal",
+	}
+	return appConfig
+}
+
+func testExtensions() config.Extensions {
+	return config.Extensions{
+		"postgres": {
+			"PGUSER":     "example",
+			"PGHOST":     "db.internal",
+			"PGPASSWORD": "secret",
+		},
+		"smtp": {
+			"SMTP_HOST": "mail.internal",
+			"SMTP_PORT": "587",
+			"LOG_LEVEL": "info",
+		},
+		"unused": {
+			"UNUSED": "true",
+		},
+	}
+}
+
+// siteOptions are the options config-setup renders the embedded template
+// with for a site served under domain.
+func siteOptions(domain string) []NginxOption {
+	return []NginxOption{
+		WithDomains(domain),
+		WithData("SSLCertPath", "/etc/letsencrypt/live/"+domain+"/fullchain.pem"),
+		WithData("SSLCertKeyPath", "/etc/letsencrypt/live/"+domain+"/privkey.pem"),
+		WithData("SSLDHParamPath", "/etc/arr/dhparam.txt"),
+		WithData("AuthEndpoint", "http://vouch.internal:9090/validate"),
+		WithData("AuthLoginURL", "https://vouch."+domain+"/login"),
+	}
+}
+
+func TestNginx(t *testing.T) {
+	tests := []struct {
+		domain string
+		golden string
+	}{
+		{"home.pagemail.io", "nginx.conf.golden"},
+		{"other.tld", "nginx-other.conf.golden"},
+	}
+	tmpl := template.Must(template.New("nginx").Parse(embed.NginxTemplate))
+	for _, tc := range tests {
+		t.Run(tc.domain, func(t *testing.T) {
+			out, err := Nginx(tmpl, testAppConfig().Nginx, siteOptions(tc.domain)...)
+			if err != nil {
+				t.Fatalf("Nginx returned error: %s", err)
+			}
+			checkGolden(t, tc.golden, out)
+		})
+	}
+}
+
+func TestNginxDomains(t *testing.T) {
+	tmpl := template.Must(template.New("nginx").Parse(
+		"{{ .ExternalHost }}:{{ range .ServerNames }} {{ . }}{{ end }}\n",
+	))
+	blocks := []config.NginxBlock{
+		{ExternalHost: "plain"},
+		{ExternalHost: "override", Domain: "example.org"},
+		{ExternalHost: "www.example.net", FQDN: true},
+	}
+	out, err := Nginx(tmpl, blocks, WithDomains("home.pagemail.io", ".example.com"))
+	if err != nil {
+		t.Fatalf("Nginx returned error: %s", err)
+	}
+	checkGolden(t, "nginx-domains.golden", out)
+}
+
+func TestNginxWithData(t *testing.T) {
+	tmpl := template.Must(template.New("nginx").Parse(
+		"{{ .ExternalHost }} {{ .Protocol }}://{{ .IPv4 }}:{{ .Port }} {{ .Extra }}\n",
+	))
+	out, err := Nginx(tmpl, testAppConfig().Nginx, WithData("Extra", "value"))
+	if err != nil {
+		t.Fatalf("Nginx returned error: %s", err)
+	}
+	checkGolden(t, "nginx-data.golden", out)
+}
+
+func TestEnvironment(t *testing.T) {
+	out, err := Environment(testAppConfig(), testExtensions())
+	if err != nil {
+		t.Fatalf("Environment returned error: %s", err)
+	}
+	data, err := io.ReadAll(out)
+	if err != nil {
+		t.Fatalf("Failed to read output: %s", err)
+	}
+	checkGolden(t, "stack.env.golden", bytes.NewReader(data))
+
+	entries, err := dotenv.Parse(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Generated env does not parse: %s", err)
+	}
+	for _, entry := range dotenv.Dedupe(entries) {
+		if entry.Key == "LOG_LEVEL" && entry.Value != "debug" {
+			t.Errorf("Got LOG_LEVEL=%s, want the runtime value to win", entry.Value)
+		}
+	}
+}
+
+func TestStackEnvSources(t *testing.T) {
+	env, err := NewStackEnv(testAppConfig(), testExtensions())
+	if err != nil {
+		t.Fatalf("NewStackEnv returned error: %s", err)
+	}
+
+	sources := make([]string, 0)
+	for _, group := range env.Groups {
+		sources = append(sources, group.Source)
+	}
+	wantSources := []string{SourceGenerated, "extension:postgres", "extension:smtp", SourceRuntime}
+	if !reflect.DeepEqual(sources, wantSources) {
+		t.Errorf("Got groups %v, want %v", sources, wantSources)
+	}
+
+	overrides := make(map[string]string)
+	for _, value := range env.Overrides() {
+		overrides[value.Key] = value.Overrides
+	}
+	wantOverrides := map[string]string{
+		"LOG_LEVEL": "extension:smtp",
+		"PGHOST":    "extension:postgres",
+	}
+	if !reflect.DeepEqual(overrides, wantOverrides) {
+		t.Errorf("Got overrides %v, want %v", overrides, wantOverrides)
+	}
+
+	winners := make(map[string]EnvValue)
+	for _, value := range env.Resolved() {
+		winners[value.Key] = value
+	}
+	tests := []struct {
+		key    string
+		value  string
+		source string
+	}{
+		{"CFG_IPV4_EXAMPLE", "10.0.0.2", SourceGenerated},
+		{"PGUSER", "example", "extension:postgres"},
+		{"PGHOST", "db.example.internal", SourceRuntime},
+		{"SMTP_PORT", "587", "extension:smtp"},
+		{"LOG_LEVEL", "debug", SourceRuntime},
+	}
+	for _, tc := range tests {
+		got := winners[tc.key]
+		if got.Value != tc.value || got.Source != tc.source {
+			t.Errorf("%s: got %q from %s, want %q from %s", tc.key, got.Value, got.Source, tc.value, tc.source)
+		}
+	}
+	if _, ok := winners["UNUSED"]; ok {
+		t.Error("Unlisted extension should not be included")
+	}
+}
+
+func TestStackEnvConflictModes(t *testing.T) {
+	tests := []struct {
+		mode      config.EnvConflicts
+		conflicts int
+		err       bool
+	}{
+		{"", 2, false},
+		{config.EnvConflictsWarn, 2, false},
+		{config.EnvConflictsAllow, 0, false},
+		{config.EnvConflictsError, 0, true},
+		{"ignore", 0, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(string(tc.mode), func(t *testing.T) {
+			appConfig := testAppConfig()
+			appConfig.Runtime.EnvConflicts = tc.mode
+			env, err := NewStackEnv(appConfig, testExtensions())
+			if tc.err {
+				if err == nil {
+					t.Fatal("Expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewStackEnv returned error: %s", err)
+			}
+			if got := len(env.Conflicts()); got != tc.conflicts {
+				t.Errorf("Got %d conflicts, want %d", got, tc.conflicts)
+			}
+		})
+	}
+}
+
+func TestStackEnvConflictError(t *testing.T) {
+	appConfig := testAppConfig()
+	appConfig.Runtime.EnvConflicts = config.EnvConflictsError
+	_, err := NewStackEnv(appConfig, testExtensions())
+
+	conflictErr := new(ConflictError)
+	if !errors.As(err, &conflictErr) {
+		t.Fatalf("Expected a ConflictError, got %v", err)
+	}
+	if !errors.Is(err, ErrEnvConflict) {
+		t.Error("Expected the error to match ErrEnvConflict")
+	}
+	want := "LOG_LEVEL: runtime overrides extension:smtp"
+	if got := conflictErr.Conflicts[0].Conflict(); got != want {
+		t.Errorf("Got %q, want %q", got, want)
+	}
+
+	appConfig.Runtime.Env = nil
+	if _, err := NewStackEnv(appConfig, testExtensions()); err != nil {
+		t.Errorf("Expected no error without overrides, got %s", err)
+	}
+}
+
+func TestEnvironmentMissingExtension(t *testing.T) {
+	appConfig := testAppConfig()
+	appConfig.Runtime.EnvExtensions = append(appConfig.Runtime.EnvExtensions, "missing")
+	_, err := Environment(appConfig, testExtensions())
+	if err == nil {
+		t.Fatal("Expected an error for a missing extension")
+	}
+}
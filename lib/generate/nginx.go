@@ -0,0 +1,75 @@
+package generate
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+	"text/template"
+
+	"github.com/mr55p-dev/app-utils/config"
+)
+
+type nginxOptions struct {
+	data    map[string]any
+	domains []string
+}
+
+type NginxOption func(*nginxOptions)
+
+// WithData sets an additional value on the data passed to the nginx template
+// alongside the fields of the block being rendered.
+func WithData(key string, val any) NginxOption {
+	return func(o *nginxOptions) { o.data[key] = val }
+}
+
+// WithDomains sets the base domains each block's ExternalHost is served
+// under. The qualified names are passed to the template as ServerNames.
+func WithDomains(domains ...string) NginxOption {
+	return func(o *nginxOptions) { o.domains = domains }
+}
+
+func copyStructToMap(data any) map[string]any {
+	to := make(map[string]any)
+	dataType := reflect.TypeOf(data).Elem()
+	dataVal := reflect.ValueOf(data).Elem()
+	fields := reflect.VisibleFields(dataType)
+	for _, field := range fields {
+		to[field.Name] = dataVal.FieldByName(field.Name).Interface()
+	}
+	return to
+}
+
+// NginxUnit renders a single server block for conf into w.
+func NginxUnit(w io.Writer, tmpl *template.Template, conf config.NginxBlock, opts ...NginxOption) error {
+	o := &nginxOptions{data: make(map[string]any)}
+	for _, fn := range opts {
+		fn(o)
+	}
+
+	templateData := copyStructToMap(&conf)
+	templateData["ServerNames"] = conf.ServerNames(o.domains)
+	for key, val := range o.data {
+		templateData[key] = val
+	}
+
+	err := tmpl.Execute(w, templateData)
+	if err != nil {
+		return fmt.Errorf("Failed to exec template: %w", err)
+	}
+	return nil
+}
+
+// Nginx renders a server block for every entry in blocks, separated by a
+// blank line, ready to be written out as a single unit file.
+func Nginx(tmpl *template.Template, blocks []config.NginxBlock, opts ...NginxOption) (io.Reader, error) {
+	buf := new(bytes.Buffer)
+	for _, block := range blocks {
+		err := NginxUnit(buf, tmpl, block, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("Error creating unit for %s: %w", block.ExternalHost, err)
+		}
+		fmt.Fprint(buf, "\n\n")
+	}
+	return buf, nil
+}
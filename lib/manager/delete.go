@@ -0,0 +1,223 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mr55p-dev/app-utils/lib/compose"
+	"github.com/mr55p-dev/app-utils/lib/nginx"
+	"github.com/mr55p-dev/app-utils/lib/portainer"
+)
+
+const (
+	archiveDir    = ".archive"
+	archiveLayout = "20060102T150405.000Z"
+)
+
+var (
+	ErrAppNotFound     = errors.New("App not found")
+	ErrArchiveNotFound = errors.New("Archived app not found")
+)
+
+// UnitRemover removes an app's nginx unit, then reloads nginx so it stops
+// serving the app.
+type UnitRemover interface {
+	RemoveUnit(name string) error
+	Reload(ctx context.Context) error
+}
+
+type ComposeDowner interface {
+	Down(ctx context.Context, path string, opts compose.DownOptions) error
+}
+
+type StackDeleter interface {
+	DeleteStack(ctx context.Context, stackId int) error
+}
+
+type deleteOptions struct {
+	nginx       UnitRemover
+	compose     ComposeDowner
+	composeDown compose.DownOptions
+	portainer   StackDeleter
+}
+
+type DeleteFn func(*deleteOptions)
+
+// WithUnitRemover removes the app's nginx unit as part of the deletion and
+// reloads nginx.
+func WithUnitRemover(n UnitRemover) DeleteFn {
+	return func(o *deleteOptions) { o.nginx = n }
+}
+
+// WithComposeDown stops and removes the app's containers before archiving.
+func WithComposeDown(c ComposeDowner, opts compose.DownOptions) DeleteFn {
+	return func(o *deleteOptions) {
+		o.compose = c
+		o.composeDown = opts
+	}
+}
+
+// WithStackDelete deletes the app's portainer stack, if it has one.
+func WithStackDelete(p StackDeleter) DeleteFn {
+	return func(o *deleteOptions) { o.portainer = p }
+}
+
+type DeleteReport struct {
+	App              string `json:"app"`
+	ArchiveID        string `json:"archiveId,omitempty"`
+	ArchivePath      string `json:"archivePath,omitempty"`
+	NginxUnitRemoved bool   `json:"nginxUnitRemoved"`
+	ComposeDown      bool   `json:"composeDown"`
+	PortainerStackId int    `json:"portainerStackId,omitempty"`
+}
+
+type ArchivedApp struct {
+	ID         string
+	Name       string
+	Path       string
+	ArchivedAt time.Time
+}
+
+func (cli *FSClient) archivePath(id string) string {
+	return filepath.Join(cli.dir, archiveDir, id)
+}
+
+// Delete tears down the named app and moves its directory into the archive.
+// The returned report records each step that completed, so a partial
+// deletion can be inspected when an error is returned. ctx bounds the calls
+// to compose, portainer and nginx.
+func (cli *FSClient) Delete(ctx context.Context, name string, opts ...DeleteFn) (*DeleteReport, error) {
+	o := new(deleteOptions)
+	for _, fn := range opts {
+		fn(o)
+	}
+
+	if !ValidName(name) {
+		return nil, ErrInvalidName
+	}
+	path := filepath.Join(cli.dir, name)
+	stat, err := os.Stat(path)
+	if err != nil || !stat.IsDir() {
+		return nil, ErrAppNotFound
+	}
+
+	report := &DeleteReport{App: name}
+	if o.compose != nil {
+		if err := o.compose.Down(ctx, path, o.composeDown); err != nil {
+			return report, fmt.Errorf("Failed to stop stack: %w", err)
+		}
+		report.ComposeDown = true
+	}
+
+	if o.portainer != nil {
+		stackId, err := portainer.GetStackId(path)
+		if err == nil && stackId != 0 {
+			if err := o.portainer.DeleteStack(ctx, stackId); err != nil {
+				return report, fmt.Errorf("Failed to delete portainer stack %d: %w", stackId, err)
+			}
+			if err := portainer.RemoveStackId(path); err != nil {
+				return report, err
+			}
+			report.PortainerStackId = stackId
+		}
+	}
+
+	if o.nginx != nil {
+		err := o.nginx.RemoveUnit(name)
+		switch {
+		case err == nil:
+			report.NginxUnitRemoved = true
+		case !errors.Is(err, nginx.ErrUnitNotFound):
+			return report, fmt.Errorf("Failed to remove nginx unit: %w", err)
+		}
+		if report.NginxUnitRemoved {
+			if err := o.nginx.Reload(ctx); err != nil {
+				return report, fmt.Errorf("Failed to reload nginx after removing its unit: %w", err)
+			}
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Join(cli.dir, archiveDir), 0o770); err != nil {
+		return report, fmt.Errorf("Failed to create archive: %w", err)
+	}
+	id := fmt.Sprintf("%s@%s", name, time.Now().UTC().Format(archiveLayout))
+	if err := os.Rename(path, cli.archivePath(id)); err != nil {
+		return report, fmt.Errorf("Failed to archive %s: %w", path, err)
+	}
+	report.ArchiveID = id
+	report.ArchivePath = cli.archivePath(id)
+
+	return report, nil
+}
+
+func parseArchiveID(id string) (string, time.Time, bool) {
+	idx := strings.LastIndex(id, "@")
+	if idx <= 0 {
+		return "", time.Time{}, false
+	}
+	archivedAt, err := time.Parse(archiveLayout, id[idx+1:])
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	return id[:idx], archivedAt, true
+}
+
+// Archived lists the archived apps, most recently archived first.
+func (cli *FSClient) Archived() ([]ArchivedApp, error) {
+	dirs, err := os.ReadDir(filepath.Join(cli.dir, archiveDir))
+	if os.IsNotExist(err) {
+		return []ArchivedApp{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error opening archive: %w", err)
+	}
+
+	archived := make([]ArchivedApp, 0)
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+		name, archivedAt, ok := parseArchiveID(dir.Name())
+		if !ok {
+			continue
+		}
+		archived = append(archived, ArchivedApp{
+			ID:         dir.Name(),
+			Name:       name,
+			Path:       cli.archivePath(dir.Name()),
+			ArchivedAt: archivedAt,
+		})
+	}
+	sort.Slice(archived, func(i, j int) bool {
+		return archived[i].ArchivedAt.After(archived[j].ArchivedAt)
+	})
+	return archived, nil
+}
+
+// Restore moves an archived app back into the apps directory and returns its
+// name. Nginx units and stacks removed during deletion are not recreated.
+func (cli *FSClient) Restore(id string) (string, error) {
+	name, _, ok := parseArchiveID(id)
+	if !ok || !ValidName(name) {
+		return "", ErrArchiveNotFound
+	}
+	src := cli.archivePath(id)
+	if stat, err := os.Stat(src); err != nil || !stat.IsDir() {
+		return "", ErrArchiveNotFound
+	}
+
+	dst := filepath.Join(cli.dir, name)
+	if _, err := os.Stat(dst); err == nil {
+		return name, ErrAppExists
+	}
+	if err := os.Rename(src, dst); err != nil {
+		return "", fmt.Errorf("Failed to restore %s: %w", id, err)
+	}
+	return name, nil
+}
@@ -0,0 +1,128 @@
+package manager_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/mr55p-dev/app-utils/lib/manager"
+	"github.com/mr55p-dev/app-utils/lib/nginx"
+	"github.com/mr55p-dev/app-utils/lib/runner/runnertest"
+)
+
+// newApps returns a client for a temporary apps directory holding an app
+// called demo, and an nginx client with a unit installed for it.
+func newApps(t *testing.T, fake *runnertest.Fake) (*manager.FSClient, string, *nginx.Client) {
+	t.Helper()
+	dir := t.TempDir()
+	apps := filepath.Join(dir, "apps")
+	units := filepath.Join(dir, "nginx")
+	for _, d := range []string{filepath.Join(apps, "demo"), units} {
+		if err := os.MkdirAll(d, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(apps, "demo", "docker-compose.yml"), []byte(composeFile), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	n := nginx.New(nginx.WithDir(units), nginx.WithRunner(fake))
+	if err := os.WriteFile(filepath.Join(units, "demo.gold.nginx.conf"), []byte("server {}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cli, err := manager.New(apps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cli, apps, n
+}
+
+func TestDeleteAndRestore(t *testing.T) {
+	fake := runnertest.New()
+	cli, apps, n := newApps(t, fake)
+
+	report, err := cli.Delete(context.Background(), "demo", manager.WithUnitRemover(n))
+	if err != nil {
+		t.Fatalf("Delete returned error: %s", err)
+	}
+	if !report.NginxUnitRemoved || report.ArchiveID == "" {
+		t.Errorf("Got report %+v", report)
+	}
+	if n.Status("demo") != nginx.StatusDisabled {
+		t.Error("Nginx unit was not removed")
+	}
+	if got, want := fake.Commands(), []string{"nginx -t", "nginx -s reload"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Got commands %v, want %v", got, want)
+	}
+	if _, err := os.Stat(filepath.Join(apps, "demo")); !os.IsNotExist(err) {
+		t.Errorf("App directory was not archived: %v", err)
+	}
+
+	archived, err := cli.Archived()
+	if err != nil {
+		t.Fatalf("Archived returned error: %s", err)
+	}
+	if len(archived) != 1 || archived[0].ID != report.ArchiveID || archived[0].Name != "demo" {
+		t.Fatalf("Got archive %+v, want %s", archived, report.ArchiveID)
+	}
+
+	name, err := cli.Restore(report.ArchiveID)
+	if err != nil || name != "demo" {
+		t.Fatalf("Restore returned %q, %v", name, err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(apps, "demo", "docker-compose.yml")); string(data) != composeFile {
+		t.Errorf("Got compose file %q after restoring", data)
+	}
+	if archived, _ := cli.Archived(); len(archived) != 0 {
+		t.Errorf("Got archive %+v after restoring, want it empty", archived)
+	}
+	if _, err := cli.Restore(report.ArchiveID); !errors.Is(err, manager.ErrArchiveNotFound) {
+		t.Errorf("Expected ErrArchiveNotFound restoring twice, got %v", err)
+	}
+}
+
+func TestRestoreOverExistingApp(t *testing.T) {
+	cli, apps, _ := newApps(t, runnertest.New())
+
+	report, err := cli.Delete(context.Background(), "demo")
+	if err != nil {
+		t.Fatalf("Delete returned error: %s", err)
+	}
+	if err := os.Mkdir(filepath.Join(apps, "demo"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cli.Restore(report.ArchiveID); !errors.Is(err, manager.ErrAppExists) {
+		t.Errorf("Expected ErrAppExists, got %v", err)
+	}
+	if archived, _ := cli.Archived(); len(archived) != 1 {
+		t.Errorf("Got archive %+v, want the app kept there", archived)
+	}
+}
+
+func TestDeleteReloadFailure(t *testing.T) {
+	fake := runnertest.New().On("nginx -s reload", runnertest.Response{Err: runnertest.ErrExit})
+	cli, apps, n := newApps(t, fake)
+
+	report, err := cli.Delete(context.Background(), "demo", manager.WithUnitRemover(n))
+	if err == nil {
+		t.Fatal("Expected an error")
+	}
+	if !report.NginxUnitRemoved || report.ArchiveID != "" {
+		t.Errorf("Got report %+v, want the unit removed and the app left in place", report)
+	}
+	if _, err := os.Stat(filepath.Join(apps, "demo")); err != nil {
+		t.Errorf("App directory was archived: %s", err)
+	}
+}
+
+func TestDeleteNotFound(t *testing.T) {
+	cli, _, _ := newApps(t, runnertest.New())
+	if _, err := cli.Delete(context.Background(), "missing"); !errors.Is(err, manager.ErrAppNotFound) {
+		t.Errorf("Expected ErrAppNotFound, got %v", err)
+	}
+	if _, err := cli.Restore("missing@20240101T000000.000Z"); !errors.Is(err, manager.ErrArchiveNotFound) {
+		t.Errorf("Expected ErrArchiveNotFound, got %v", err)
+	}
+}
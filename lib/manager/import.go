@@ -0,0 +1,87 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/mr55p-dev/app-utils/config"
+	"github.com/mr55p-dev/app-utils/lib/portainer"
+)
+
+type StackReader interface {
+	GetStack(ctx context.Context, stackId int) (*portainer.Stack, error)
+	GetStackFile(ctx context.Context, stackId int) (string, error)
+}
+
+// ImportedStacks maps the portainer stack IDs of existing apps to the app
+// names, so stacks that have already been imported can be recognised.
+func (cli *FSClient) ImportedStacks() (map[int]string, error) {
+	names, err := cli.List()
+	if err != nil {
+		return nil, err
+	}
+	imported := make(map[int]string)
+	for _, name := range names {
+		stackId, err := portainer.GetStackId(filepath.Join(cli.dir, name))
+		if err == nil && stackId != 0 {
+			imported[stackId] = name
+		}
+	}
+	return imported, nil
+}
+
+// Import creates the app name from an existing portainer stack. The stack's
+// compose file becomes docker-compose.yml, its env is kept as the runtime env
+// of a skeleton app.yml (from which stack.env is generated) along with the
+// endpoint it is deployed on, and its ID is recorded in the .stack file. If
+// any step fails the partially created directory is removed again.
+func (cli *FSClient) Import(ctx context.Context, name string, stackId int, p StackReader) (err error) {
+	stack, err := p.GetStack(ctx, stackId)
+	if err != nil {
+		return fmt.Errorf("Failed to read stack: %w", err)
+	}
+	composeFile, err := p.GetStackFile(ctx, stackId)
+	if err != nil {
+		return fmt.Errorf("Failed to read stack file: %w", err)
+	}
+
+	appConfig := config.AppConfig{App: name}
+	if stack.EndpointId != 0 {
+		appConfig.Portainer.Endpoint = strconv.Itoa(stack.EndpointId)
+	}
+	if len(stack.Env) > 0 {
+		appConfig.Runtime.Env = make(map[string]any, len(stack.Env))
+		for _, variable := range stack.Env {
+			appConfig.Runtime.Env[variable.Name] = variable.Value
+		}
+	}
+	env, err := cli.stackEnv(&appConfig)
+	if err != nil {
+		return err
+	}
+
+	path, err := cli.mkdir(name)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(path)
+		}
+	}()
+
+	if err := writeAppYaml(path, appConfig); err != nil {
+		return err
+	}
+	err = os.WriteFile(filepath.Join(path, "docker-compose.yml"), []byte(composeFile), 0o660)
+	if err != nil {
+		return fmt.Errorf("Failed to write docker-compose.yml: %w", err)
+	}
+	if err := cli.writeEnvironment(name, env); err != nil {
+		return err
+	}
+	return portainer.WriteStackId(path, stack.Id)
+}
@@ -0,0 +1,89 @@
+package manager_test
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/mr55p-dev/app-utils/config"
+	"github.com/mr55p-dev/app-utils/lib/portainer"
+	"github.com/mr55p-dev/app-utils/lib/portainer/portainertest"
+)
+
+func TestImport(t *testing.T) {
+	srv := portainertest.New("test-key")
+	defer srv.Close()
+	endpoint := srv.AddEndpoint("remote")
+	env := []portainer.EnvironmentVariable{
+		{Name: "DSN", Value: "postgres://db/app?sslmode=disable&user=app"},
+		{Name: "GREETING", Value: `say "hello" and 'bye'`},
+		{Name: "TOKEN", Value: "c2VjcmV0=="},
+	}
+	stack := srv.AddStackOn(endpoint.Id, "demo", composeFile, env)
+	cli, dir := newFSClient(t)
+
+	if err := cli.Import(context.Background(), "demo", stack.Id, srv.Client()); err != nil {
+		t.Fatalf("Import returned error: %s", err)
+	}
+	path := filepath.Join(dir, "demo")
+	if data, _ := os.ReadFile(filepath.Join(path, "docker-compose.yml")); string(data) != composeFile {
+		t.Errorf("Got docker-compose.yml %q, want the stack file", data)
+	}
+	if id, err := portainer.GetStackId(path); err != nil || id != stack.Id {
+		t.Errorf("Got stack ID %d, %v from the .stack file, want %d", id, err, stack.Id)
+	}
+
+	stackEnv, err := os.Open(filepath.Join(path, "stack.env"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stackEnv.Close()
+	got, err := portainer.ReadEnvironment(stackEnv)
+	if err != nil {
+		t.Fatalf("Failed to read stack.env: %s", err)
+	}
+	if !reflect.DeepEqual(got, env) {
+		t.Errorf("Got env %q from stack.env, want %q", got, env)
+	}
+
+	appConfig, err := config.NewFromFile(path)
+	if err != nil {
+		t.Fatalf("Failed to load app.yml: %s", err)
+	}
+	if appConfig.App != "demo" || appConfig.Portainer.Endpoint != strconv.Itoa(endpoint.Id) {
+		t.Errorf("Got app %q on endpoint %q, want demo on %d", appConfig.App, appConfig.Portainer.Endpoint, endpoint.Id)
+	}
+
+	imported, err := cli.ImportedStacks()
+	if err != nil {
+		t.Fatalf("ImportedStacks returned error: %s", err)
+	}
+	if want := map[int]string{stack.Id: "demo"}; !reflect.DeepEqual(imported, want) {
+		t.Errorf("Got imported stacks %v, want %v", imported, want)
+	}
+}
+
+func TestImportStackFileFailure(t *testing.T) {
+	srv := portainertest.New("test-key")
+	defer srv.Close()
+	stack := srv.AddStack("demo", composeFile, nil)
+	cli, dir := newFSClient(t)
+
+	// Let the stack be read, then fail fetching its file.
+	srv.FailNext(0, http.StatusInternalServerError)
+	err := cli.Import(context.Background(), "demo", stack.Id, srv.Client())
+	if err == nil || !strings.Contains(err.Error(), "Failed to read stack file") {
+		t.Fatalf("Expected the stack file to fail, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "demo")); !os.IsNotExist(err) {
+		t.Errorf("App directory was left behind: %v", err)
+	}
+	if imported, _ := cli.ImportedStacks(); len(imported) != 0 {
+		t.Errorf("Got imported stacks %v, want none", imported)
+	}
+}
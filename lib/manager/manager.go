@@ -2,14 +2,31 @@ package manager
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
+	"text/template"
+
+	_ "embed"
 
 	"github.com/mr55p-dev/app-utils/config"
+	"github.com/mr55p-dev/app-utils/lib/generate"
 	"github.com/mr55p-dev/app-utils/lib/portainer"
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed docker-compose.yml.tmpl
+var composeTmpl string
+
+var (
+	ErrAppExists   = errors.New("App already exists")
+	ErrInvalidName = errors.New("Invalid app name")
+
+	composeTemplate = template.Must(template.New("docker-compose.yml.tmpl").Parse(composeTmpl))
+	validName       = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
 )
 
 type FSClient struct {
@@ -38,6 +55,12 @@ func New(directory string) (*FSClient, error) {
 	return &FSClient{dir: directory}, nil
 }
 
+// ValidName reports whether name can be used as an app directory and
+// compose project name.
+func ValidName(name string) bool {
+	return validName.MatchString(name)
+}
+
 func (cli *FSClient) List() ([]string, error) {
 	dirs, err := os.ReadDir(cli.dir)
 	if err != nil {
@@ -45,7 +68,7 @@ func (cli *FSClient) List() ([]string, error) {
 	}
 	dirList := make([]string, 0)
 	for _, dir := range dirs {
-		if dir.IsDir() {
+		if dir.IsDir() && !strings.HasPrefix(dir.Name(), ".") {
 			dirList = append(dirList, dir.Name())
 		}
 	}
@@ -64,6 +87,15 @@ func (cli *FSClient) Extensions() (config.Extensions, error) {
 	return ext, nil
 }
 
+// Exists reports whether name is an app in the apps directory.
+func (cli *FSClient) Exists(name string) bool {
+	if !ValidName(name) {
+		return false
+	}
+	stat, err := os.Stat(filepath.Join(cli.dir, name))
+	return err == nil && stat.IsDir()
+}
+
 func (cli *FSClient) Get(name string) (*App, error) {
 	app := new(App)
 	app.ID = name
@@ -102,68 +134,134 @@ func (cli *FSClient) Get(name string) (*App, error) {
 	return app, nil
 }
 
-func sanitizeHost(hostname string) string {
-	s := hostname
-	s = strings.ReplaceAll(s, "-", "_")
-	s = strings.ReplaceAll(s, " ", "_")
-	return strings.ToUpper(s)
-}
-
-func doEnvironment(appConfig *config.AppConfig, extensions config.Extensions) (io.Reader, error) {
-	stackEnvData := new(bytes.Buffer)
-	for _, nginx := range appConfig.Nginx {
-		fmt.Fprintf(stackEnvData, "CFG_IPV4_%s=%s\n", sanitizeHost(nginx.ExternalHost), nginx.IPv4)
-	}
-	for _, extensionName := range appConfig.Runtime.EnvExtensions {
-		ext, ok := extensions[extensionName]
-		if !ok {
-			return nil, fmt.Errorf("Env extension %s: not found", extensionName)
-		}
-		for key, val := range ext {
-			fmt.Fprintf(stackEnvData, "%s=%v\n", key, val)
+// stackEnv generates the environment for appConfig, loading the extensions
+// file only when the app uses extensions.
+func (cli *FSClient) stackEnv(appConfig *config.AppConfig) (*generate.StackEnv, error) {
+	extensions := make(config.Extensions)
+	if len(appConfig.Runtime.EnvExtensions) > 0 {
+		var err error
+		extensions, err = cli.Extensions()
+		if err != nil {
+			return nil, fmt.Errorf("Failed to load extensions: %w", err)
 		}
 	}
-	for key, val := range appConfig.Runtime.Env {
-		fmt.Fprintf(stackEnvData, "%s=%v\n", key, val)
-	}
 
-	return stackEnvData, nil
+	env, err := generate.NewStackEnv(*appConfig, extensions)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to generate stack env: %w", err)
+	}
+	return env, nil
 }
 
-func (cli *FSClient) Update(name string, content []byte) error {
-	path := filepath.Join(cli.dir, name, "app.yml")
-	err := os.WriteFile(path, content, 0o660)
+func (cli *FSClient) writeEnvironment(name string, env *generate.StackEnv) error {
+	stackEnvBytes := new(bytes.Buffer)
+	if _, err := env.WriteTo(stackEnvBytes); err != nil {
+		return fmt.Errorf("Failed to write stack env: %w", err)
+	}
+
+	err := os.WriteFile(filepath.Join(cli.dir, name, "stack.env"), stackEnvBytes.Bytes(), 0o660)
 	if err != nil {
-		return fmt.Errorf("Failed to write to %s: %w", path, err)
+		return fmt.Errorf("Failed to write updated env: %w", err)
 	}
 
+	err = os.WriteFile(filepath.Join(cli.dir, name, ".env"), stackEnvBytes.Bytes(), 0o660)
+	if err != nil {
+		return fmt.Errorf("Failed to write updated env: %w", err)
+	}
+	return nil
+}
+
+// Update replaces the app's app.yml with content and regenerates its env
+// files. The env key conflicts found are returned so they can be shown to
+// the user. Nothing is written if the env cannot be generated, including when
+// the app treats conflicts as errors (see generate.ConflictError).
+func (cli *FSClient) Update(name string, content []byte) ([]generate.EnvValue, error) {
 	appConfig, err := config.NewFromBytes(content)
 	if err != nil {
-		return fmt.Errorf("Failed to load new config: %w", err)
+		return nil, fmt.Errorf("Failed to load new config: %w", err)
+	}
+	env, err := cli.stackEnv(appConfig)
+	if err != nil {
+		return nil, err
 	}
 
-	extensions, err := cli.Extensions()
+	path := filepath.Join(cli.dir, name, "app.yml")
+	err = os.WriteFile(path, content, 0o660)
 	if err != nil {
-		return fmt.Errorf("Failed to load extensions: %w", err)
+		return nil, fmt.Errorf("Failed to write to %s: %w", path, err)
+	}
+	if err := cli.writeEnvironment(name, env); err != nil {
+		return nil, err
+	}
+	return env.Conflicts(), nil
+}
+
+// mkdir creates the directory for a new app, failing with ErrAppExists if
+// there already is one.
+func (cli *FSClient) mkdir(name string) (string, error) {
+	if !ValidName(name) {
+		return "", ErrInvalidName
+	}
+	path := filepath.Join(cli.dir, name)
+	if err := os.Mkdir(path, 0o770); err != nil {
+		if os.IsExist(err) {
+			return "", ErrAppExists
+		}
+		return "", fmt.Errorf("Failed to create %s: %w", path, err)
 	}
+	return path, nil
+}
 
-	stackEnv, err := doEnvironment(appConfig, extensions)
-	stackEnvBytes, err := io.ReadAll(stackEnv)
+func writeAppYaml(path string, appConfig config.AppConfig) error {
+	appYaml := new(bytes.Buffer)
+	enc := yaml.NewEncoder(appYaml)
+	enc.SetIndent(2)
+	if err := enc.Encode(appConfig); err != nil {
+		return fmt.Errorf("Failed to marshal app.yml: %w", err)
+	}
+	err := os.WriteFile(filepath.Join(path, "app.yml"), appYaml.Bytes(), 0o660)
 	if err != nil {
-		return fmt.Errorf("Failed to read stackEnv: %w", err)
+		return fmt.Errorf("Failed to write app.yml: %w", err)
+	}
+	return nil
+}
+
+// Create scaffolds a new app directory containing app.yml, a starter
+// docker-compose.yml and the generated stack.env and .env files. If any step
+// fails the partially created directory is removed again.
+func (cli *FSClient) Create(name string, appConfig config.AppConfig) (err error) {
+	if appConfig.App == "" {
+		appConfig.App = name
 	}
 
-	err = os.WriteFile(filepath.Join(cli.dir, name, "stack.env"), stackEnvBytes, 0o660)
+	path, err := cli.mkdir(name)
 	if err != nil {
-		return fmt.Errorf("Failed to write updated env: %w", err)
+		return err
 	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(path)
+		}
+	}()
 
-	err = os.WriteFile(filepath.Join(cli.dir, name, ".env"), stackEnvBytes, 0o660)
+	if err := writeAppYaml(path, appConfig); err != nil {
+		return err
+	}
+
+	composeFile := new(bytes.Buffer)
+	if err := composeTemplate.Execute(composeFile, appConfig); err != nil {
+		return fmt.Errorf("Failed to exec compose template: %w", err)
+	}
+	err = os.WriteFile(filepath.Join(path, "docker-compose.yml"), composeFile.Bytes(), 0o660)
 	if err != nil {
-		return fmt.Errorf("Failed to write updated env: %w", err)
+		return fmt.Errorf("Failed to write docker-compose.yml: %w", err)
 	}
 
-	return nil
+	env, err := cli.stackEnv(&appConfig)
+	if err != nil {
+		return err
+	}
+	return cli.writeEnvironment(name, env)
 }
 
 func (cli *FSClient) UpdateCompose(name string, content []byte) error {
@@ -174,7 +272,3 @@ func (cli *FSClient) UpdateCompose(name string, content []byte) error {
 	}
 	return nil
 }
-
-func (cli *FSClient) Delete(name string) error {
-	return nil
-}
@@ -0,0 +1,86 @@
+package manager_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mr55p-dev/app-utils/config"
+	"github.com/mr55p-dev/app-utils/lib/manager"
+)
+
+func newFSClient(t *testing.T) (*manager.FSClient, string) {
+	t.Helper()
+	dir := t.TempDir()
+	cli, err := manager.New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cli, dir
+}
+
+func TestCreate(t *testing.T) {
+	cli, dir := newFSClient(t)
+	appConfig := config.AppConfig{
+		Nginx: []config.NginxBlock{{ExternalHost: "web", IPv4: "10.0.0.2", Port: 8080, Protected: true}},
+	}
+	appConfig.Runtime.Env = map[string]any{"GREETING": "hello"}
+	if err := cli.Create("demo", appConfig); err != nil {
+		t.Fatalf("Create returned error: %s", err)
+	}
+
+	loaded, err := config.NewFromFile(filepath.Join(dir, "demo"))
+	if err != nil {
+		t.Fatalf("Failed to load the app.yml written: %s", err)
+	}
+	if loaded.App != "demo" {
+		t.Errorf("Got app %q, want demo", loaded.App)
+	}
+	want := config.NginxBlock{ExternalHost: "web", Protocol: "http", IPv4: "10.0.0.2", Port: 8080, Protected: true}
+	if len(loaded.Nginx) != 1 || loaded.Nginx[0] != want {
+		t.Errorf("Got nginx blocks %+v, want %+v", loaded.Nginx, want)
+	}
+
+	app, err := cli.Get("demo")
+	if err != nil {
+		t.Fatalf("Get returned error: %s", err)
+	}
+	if app.AppYaml == nil || app.AppYaml.Runtime.Env["GREETING"] != "hello" {
+		t.Errorf("Got app.yml %+v, want the runtime env kept", app.AppYaml)
+	}
+	for _, line := range []string{"CFG_IPV4_WEB=10.0.0.2", "GREETING=hello"} {
+		if !strings.Contains(string(app.EnvFile), line+"\n") {
+			t.Errorf("stack.env does not contain %s:\n%s", line, app.EnvFile)
+		}
+	}
+	if !strings.Contains(string(app.ComposeFile), `"8080:8080"`) {
+		t.Errorf("docker-compose.yml does not publish the port:\n%s", app.ComposeFile)
+	}
+	if dotEnv, _ := os.ReadFile(filepath.Join(dir, "demo", ".env")); string(dotEnv) != string(app.EnvFile) {
+		t.Errorf("Got .env %q, want it to match stack.env", dotEnv)
+	}
+
+	if err := cli.Create("demo", appConfig); !errors.Is(err, manager.ErrAppExists) {
+		t.Errorf("Expected ErrAppExists, got %v", err)
+	}
+	if err := cli.Create("Not Valid", appConfig); !errors.Is(err, manager.ErrInvalidName) {
+		t.Errorf("Expected ErrInvalidName, got %v", err)
+	}
+}
+
+func TestCreateRemovesDirectoryOnFailure(t *testing.T) {
+	cli, dir := newFSClient(t)
+	appConfig := config.AppConfig{}
+	appConfig.Runtime.EnvExtensions = []string{"postgres"}
+
+	// There is no env-extensions.yml, so generating stack.env fails after
+	// app.yml and docker-compose.yml have been written.
+	if err := cli.Create("demo", appConfig); err == nil {
+		t.Fatal("Expected an error")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "demo")); !os.IsNotExist(err) {
+		t.Errorf("App directory was left behind: %v", err)
+	}
+}
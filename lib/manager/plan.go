@@ -0,0 +1,131 @@
+package manager
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"strconv"
+
+	"github.com/mr55p-dev/app-utils/lib/diff"
+	"github.com/mr55p-dev/app-utils/lib/portainer"
+)
+
+var ErrPlanChanged = errors.New("The app or its stack changed since the publish was previewed")
+
+// planContext is the number of unchanged lines shown around each change to
+// the compose file.
+const planContext = 3
+
+// Plan describes what Publish would change.
+type Plan struct {
+	StackId int `json:"stackId,omitempty"`
+	// Create is set when the app has no stack yet.
+	Create bool `json:"create"`
+	// Git is set for apps deployed from git, whose compose file is read
+	// from the repository when published rather than diffed.
+	Git bool `json:"git"`
+	// Compose is a unified diff from the deployed compose file to the
+	// app's docker-compose.yml, empty when they are the same.
+	Compose string `json:"compose"`
+	// Env lists the variables added, changed or removed by the app's
+	// stack.env.
+	Env []diff.KeyChange `json:"env"`
+	// Digest identifies the deployed stack and the app's files the plan was
+	// made from, so a publish can be confirmed against the plan shown.
+	Digest string `json:"digest"`
+}
+
+// Unchanged reports whether publishing would only redeploy the stack as it
+// is. Git stacks may still pick up new commits.
+func (p *Plan) Unchanged() bool {
+	return !p.Create && p.Compose == "" && len(p.Env) == 0
+}
+
+func envMap(env []portainer.EnvironmentVariable) map[string]string {
+	out := make(map[string]string, len(env))
+	for _, v := range env {
+		out[v.Name] = v.Value
+	}
+	return out
+}
+
+// writePart adds a length prefixed part to a digest, so parts cannot run
+// into each other.
+func writePart(h hash.Hash, part string) {
+	fmt.Fprintf(h, "%d:%s", len(part), part)
+}
+
+func envPart(env []portainer.EnvironmentVariable) string {
+	b := new(bytes.Buffer)
+	for _, v := range env {
+		fmt.Fprintf(b, "%d:%s=%d:%s\n", len(v.Name), v.Name, len(v.Value), v.Value)
+	}
+	return b.String()
+}
+
+// PlanPublish compares the app's stack in portainer with its
+// docker-compose.yml and stack.env, without changing anything.
+func PlanPublish(ctx context.Context, p *portainer.Client, app *App) (*Plan, error) {
+	cfg := portainerConfig(app)
+	env, err := portainer.ReadEnvironment(bytes.NewReader(app.EnvFile))
+	if err != nil {
+		return nil, err
+	}
+	plan := &Plan{StackId: app.PortainerId, Create: app.PortainerId == 0, Git: cfg.UsesGit()}
+
+	deployedFile := ""
+	var deployedEnv []portainer.EnvironmentVariable
+	if !plan.Create {
+		target, err := p.ForStack(ctx, app.PortainerId, cfg.Endpoint)
+		if err != nil {
+			return nil, err
+		}
+		stack, err := target.GetStack(ctx, app.PortainerId)
+		if err != nil {
+			return nil, err
+		}
+		deployedEnv = stack.Env
+		if !plan.Git {
+			deployedFile, err = target.GetStackFile(ctx, app.PortainerId)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if !plan.Git {
+		plan.Compose = diff.Unified("portainer/docker-compose.yml", app.ID+"/docker-compose.yml",
+			deployedFile, string(app.ComposeFile), planContext)
+	}
+	plan.Env = diff.Keys(envMap(deployedEnv), envMap(env))
+
+	h := sha256.New()
+	parts := []string{
+		strconv.Itoa(app.PortainerId), string(app.RawAppYaml),
+		deployedFile, envPart(deployedEnv),
+		string(app.ComposeFile), envPart(env),
+	}
+	for _, part := range parts {
+		writePart(h, part)
+	}
+	plan.Digest = hex.EncodeToString(h.Sum(nil))
+	return plan, nil
+}
+
+// PublishPlanned publishes app like Publish, provided neither it nor its
+// stack changed since the plan with digest was made. ErrPlanChanged is
+// returned otherwise.
+func PublishPlanned(ctx context.Context, p *portainer.Client, app *App, digest string, opts portainer.DeployOptions) (*PublishResult, error) {
+	plan, err := PlanPublish(ctx, p, app)
+	if err != nil {
+		return nil, err
+	}
+	if plan.Digest != digest {
+		return nil, ErrPlanChanged
+	}
+	return Publish(ctx, p, app, opts)
+}
@@ -0,0 +1,225 @@
+package manager
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/mr55p-dev/app-utils/config"
+	"github.com/mr55p-dev/app-utils/lib/portainer"
+)
+
+var (
+	ErrNotGitStack  = errors.New("Stack is not deployed from git")
+	ErrNoGitConfig  = errors.New("App has no git repository configured")
+	ErrNotPublished = errors.New("App has not been published to portainer")
+)
+
+// PublishResult describes what Publish did.
+type PublishResult struct {
+	StackId int  `json:"stackId"`
+	Created bool `json:"created"`
+	Git     bool `json:"git"`
+	// Commit is the commit a git stack was deployed from.
+	Commit string `json:"commit,omitempty"`
+	// Webhook is the URL redeploying a git stack, if it has one.
+	Webhook string `json:"webhook,omitempty"`
+}
+
+func portainerConfig(app *App) config.PortainerConfig {
+	if app.AppYaml == nil {
+		return config.PortainerConfig{}
+	}
+	return app.AppYaml.Portainer
+}
+
+func stackName(app *App) string {
+	if app.AppYaml != nil && app.AppYaml.App != "" {
+		return app.AppYaml.App
+	}
+	return app.ID
+}
+
+// GitRepository returns the repository cfg deploys from. existing is the
+// stack already deployed from it, if any, whose webhook is kept so that its
+// URL does not change.
+func GitRepository(cfg config.PortainerGit, existing *portainer.Stack) (portainer.GitRepository, error) {
+	repo := portainer.GitRepository{
+		URL:         cfg.URL,
+		Reference:   cfg.Ref,
+		ComposePath: cfg.ComposePath,
+		Username:    cfg.Username,
+	}
+	if cfg.PasswordEnv != "" {
+		repo.Password = os.Getenv(cfg.PasswordEnv)
+		if repo.Password == "" {
+			return repo, fmt.Errorf("Git password variable %s is not set", cfg.PasswordEnv)
+		}
+	}
+
+	auto := cfg.AutoUpdate
+	if auto.Interval == "" && !auto.Webhook {
+		return repo, nil
+	}
+	if auto.Interval != "" {
+		if _, err := time.ParseDuration(auto.Interval); err != nil {
+			return repo, fmt.Errorf("Invalid auto-update interval %q: %w", auto.Interval, err)
+		}
+	}
+	repo.AutoUpdate = &portainer.AutoUpdate{
+		Interval:       auto.Interval,
+		ForceUpdate:    auto.ForceUpdate,
+		ForcePullImage: auto.PullImage,
+	}
+	if auto.Webhook {
+		if existing != nil && existing.AutoUpdate != nil && existing.AutoUpdate.Webhook != "" {
+			repo.AutoUpdate.Webhook = existing.AutoUpdate.Webhook
+		} else {
+			webhook, err := portainer.NewWebhookId()
+			if err != nil {
+				return repo, err
+			}
+			repo.AutoUpdate.Webhook = webhook
+		}
+	}
+	return repo, nil
+}
+
+func gitResult(p *portainer.Client, stack *portainer.Stack) *PublishResult {
+	res := &PublishResult{StackId: stack.Id, Git: true}
+	if stack.GitConfig != nil {
+		res.Commit = stack.GitConfig.ConfigHash
+	}
+	if stack.AutoUpdate != nil && stack.AutoUpdate.Webhook != "" {
+		res.Webhook = p.WebhookURL(stack.AutoUpdate.Webhook)
+	}
+	return res
+}
+
+// Publish deploys app to portainer with the env in its stack.env, creating
+// its stack on the endpoint in app.yml if it has none yet. Apps with a git
+// repository in app.yml are deployed from it, updating the stack's git
+// settings to match app.yml, and others from their docker-compose.yml. opts
+// apply when an existing stack is redeployed. PlanPublish previews the
+// changes.
+func Publish(ctx context.Context, p *portainer.Client, app *App, opts portainer.DeployOptions) (*PublishResult, error) {
+	cfg := portainerConfig(app)
+	env, err := portainer.ReadEnvironment(bytes.NewReader(app.EnvFile))
+	if err != nil {
+		return nil, err
+	}
+
+	if app.PortainerId == 0 {
+		return create(ctx, p, app, cfg, env)
+	}
+
+	target, err := p.ForStack(ctx, app.PortainerId, cfg.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if !cfg.UsesGit() {
+		if _, err := target.UpdateStack(ctx, app.PortainerId, bytes.NewReader(app.ComposeFile), env, opts); err != nil {
+			return nil, err
+		}
+		return &PublishResult{StackId: app.PortainerId}, nil
+	}
+
+	stack, err := target.GetStack(ctx, app.PortainerId)
+	if err != nil {
+		return nil, err
+	}
+	if stack.GitConfig == nil {
+		return nil, fmt.Errorf("%w: delete stack %d and publish again to deploy from git", ErrNotGitStack, stack.Id)
+	}
+	repo, err := GitRepository(cfg.Git, stack)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := target.UpdateGitStack(ctx, stack.Id, repo, env, opts); err != nil {
+		return nil, err
+	}
+	stack, err = target.RedeployGitStack(ctx, stack.Id, repo, env, opts)
+	if err != nil {
+		return nil, err
+	}
+	return gitResult(p, stack), nil
+}
+
+// create creates the app's stack and records its ID in the .stack file.
+func create(ctx context.Context, p *portainer.Client, app *App, cfg config.PortainerConfig, env []portainer.EnvironmentVariable) (*PublishResult, error) {
+	target, err := p.ForEndpoint(ctx, cfg.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	var created *portainer.StackCreateResponse
+	if cfg.UsesGit() {
+		repo, err := GitRepository(cfg.Git, nil)
+		if err != nil {
+			return nil, err
+		}
+		created, err = target.CreateGitStack(ctx, stackName(app), repo, env)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		created, err = target.CreateStack(ctx, stackName(app), bytes.NewReader(app.ComposeFile), env)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := portainer.WriteStackId(app.Path, created.Id); err != nil {
+		return nil, err
+	}
+	app.PortainerId = created.Id
+
+	if !cfg.UsesGit() {
+		return &PublishResult{StackId: created.Id, Created: true}, nil
+	}
+	stack, err := target.GetStack(ctx, created.Id)
+	if err != nil {
+		return nil, err
+	}
+	res := gitResult(p, stack)
+	res.Created = true
+	return res, nil
+}
+
+// Redeploy has portainer pull the latest commit of the app's git stack and
+// deploy it, without changing the stack's settings.
+func Redeploy(ctx context.Context, p *portainer.Client, app *App, opts portainer.DeployOptions) (*PublishResult, error) {
+	cfg := portainerConfig(app)
+	if !cfg.UsesGit() {
+		return nil, ErrNoGitConfig
+	}
+	if app.PortainerId == 0 {
+		return nil, ErrNotPublished
+	}
+	env, err := portainer.ReadEnvironment(bytes.NewReader(app.EnvFile))
+	if err != nil {
+		return nil, err
+	}
+	target, err := p.ForStack(ctx, app.PortainerId, cfg.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	stack, err := target.GetStack(ctx, app.PortainerId)
+	if err != nil {
+		return nil, err
+	}
+	if stack.GitConfig == nil {
+		return nil, fmt.Errorf("%w: stack %d", ErrNotGitStack, stack.Id)
+	}
+	repo, err := GitRepository(cfg.Git, stack)
+	if err != nil {
+		return nil, err
+	}
+	stack, err = target.RedeployGitStack(ctx, stack.Id, repo, env, opts)
+	if err != nil {
+		return nil, err
+	}
+	return gitResult(p, stack), nil
+}
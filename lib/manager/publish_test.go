@@ -0,0 +1,216 @@
+package manager_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mr55p-dev/app-utils/config"
+	"github.com/mr55p-dev/app-utils/lib/diff"
+	"github.com/mr55p-dev/app-utils/lib/manager"
+	"github.com/mr55p-dev/app-utils/lib/portainer"
+	"github.com/mr55p-dev/app-utils/lib/portainer/portainertest"
+)
+
+const (
+	repoURL     = "https://git.example.com/apps/demo.git"
+	composeFile = "services:\n  web:\n    image: nginx:alpine\n"
+)
+
+func gitApp(t *testing.T, git config.PortainerGit) *manager.App {
+	t.Helper()
+	cfg := &config.AppConfig{App: "demo"}
+	cfg.Portainer.Git = git
+	return &manager.App{
+		ID:      "demo",
+		Path:    t.TempDir(),
+		EnvFile: []byte("A=1\n"),
+		AppYaml: cfg,
+	}
+}
+
+func TestPublishGit(t *testing.T) {
+	srv := portainertest.New("test-key")
+	defer srv.Close()
+	srv.SetRepositoryFile(repoURL, portainertest.DefaultRef, "docker-compose.yml", composeFile)
+	cli := srv.Client()
+	ctx := context.Background()
+
+	app := gitApp(t, config.PortainerGit{
+		URL:        repoURL,
+		AutoUpdate: config.PortainerAutoUpdate{Webhook: true},
+	})
+	created, err := manager.Publish(ctx, cli, app, portainer.DeployOptions{})
+	if err != nil {
+		t.Fatalf("Publish returned error: %s", err)
+	}
+	if !created.Created || !created.Git || created.Commit == "" || created.Webhook == "" {
+		t.Fatalf("Got result %+v", created)
+	}
+	if id, err := portainer.GetStackId(app.Path); err != nil || id != created.StackId {
+		t.Errorf("Got stack ID %d, %v from the .stack file, want %d", id, err, created.StackId)
+	}
+
+	srv.SetRepositoryFile(repoURL, portainertest.DefaultRef, "docker-compose.yml", composeFile+"    restart: always\n")
+	updated, err := manager.Publish(ctx, cli, app, portainer.DeployOptions{})
+	if err != nil {
+		t.Fatalf("Publish returned error: %s", err)
+	}
+	if updated.Created || updated.StackId != created.StackId {
+		t.Errorf("Got result %+v, want stack %d updated", updated, created.StackId)
+	}
+	if updated.Commit == created.Commit {
+		t.Errorf("Commit %s did not change after publishing again", created.Commit)
+	}
+	if updated.Webhook != created.Webhook {
+		t.Errorf("Webhook changed from %s to %s", created.Webhook, updated.Webhook)
+	}
+}
+
+func TestPublishGitToInlineStack(t *testing.T) {
+	srv := portainertest.New("test-key")
+	defer srv.Close()
+	added := srv.AddStack("demo", composeFile, nil)
+
+	app := gitApp(t, config.PortainerGit{URL: repoURL})
+	app.PortainerId = added.Id
+	_, err := manager.Publish(context.Background(), srv.Client(), app, portainer.DeployOptions{})
+	if !errors.Is(err, manager.ErrNotGitStack) {
+		t.Errorf("Expected ErrNotGitStack, got %v", err)
+	}
+}
+
+func TestRedeploy(t *testing.T) {
+	srv := portainertest.New("test-key")
+	defer srv.Close()
+	cli := srv.Client()
+	ctx := context.Background()
+
+	app := gitApp(t, config.PortainerGit{})
+	if _, err := manager.Redeploy(ctx, cli, app, portainer.DeployOptions{}); !errors.Is(err, manager.ErrNoGitConfig) {
+		t.Errorf("Expected ErrNoGitConfig, got %v", err)
+	}
+	app = gitApp(t, config.PortainerGit{URL: repoURL})
+	if _, err := manager.Redeploy(ctx, cli, app, portainer.DeployOptions{}); !errors.Is(err, manager.ErrNotPublished) {
+		t.Errorf("Expected ErrNotPublished, got %v", err)
+	}
+}
+
+func TestGitRepository(t *testing.T) {
+	t.Setenv("DEMO_GIT_TOKEN", "")
+	cfg := config.PortainerGit{URL: repoURL, Username: "deploy", PasswordEnv: "DEMO_GIT_TOKEN"}
+	if _, err := manager.GitRepository(cfg, nil); err == nil {
+		t.Errorf("Expected an error when the password variable is unset")
+	}
+
+	t.Setenv("DEMO_GIT_TOKEN", "token")
+	repo, err := manager.GitRepository(cfg, nil)
+	if err != nil {
+		t.Fatalf("GitRepository returned error: %s", err)
+	}
+	if repo.Password != "token" || repo.AutoUpdate != nil {
+		t.Errorf("Got repository %+v", repo)
+	}
+
+	cfg.AutoUpdate.Interval = "often"
+	if _, err := manager.GitRepository(cfg, nil); err == nil {
+		t.Errorf("Expected an error for an invalid interval")
+	}
+}
+
+func TestPlanPublish(t *testing.T) {
+	srv := portainertest.New("test-key")
+	defer srv.Close()
+	added := srv.AddStack("demo", composeFile, []portainer.EnvironmentVariable{
+		{Name: "KEEP", Value: "1"},
+		{Name: "CHANGE", Value: "old"},
+		{Name: "DROP", Value: "x"},
+	})
+	cli := srv.Client()
+	ctx := context.Background()
+
+	app := &manager.App{
+		ID:          "demo",
+		Path:        t.TempDir(),
+		ComposeFile: []byte(composeFile + "    restart: always\n"),
+		EnvFile:     []byte("KEEP=1\nCHANGE=new\nADD=y\n"),
+		PortainerId: added.Id,
+	}
+	plan, err := manager.PlanPublish(ctx, cli, app)
+	if err != nil {
+		t.Fatalf("PlanPublish returned error: %s", err)
+	}
+	wantCompose := "--- portainer/docker-compose.yml\n+++ demo/docker-compose.yml\n" +
+		"@@ -1,3 +1,4 @@\n services:\n   web:\n     image: nginx:alpine\n+    restart: always\n"
+	if plan.Create || plan.Compose != wantCompose {
+		t.Errorf("Got plan %+v with compose diff\n%s", plan, plan.Compose)
+	}
+	wantEnv := []diff.KeyChange{{Key: "ADD", Change: diff.Added}, {Key: "CHANGE", Change: diff.Changed}, {Key: "DROP", Change: diff.Removed}}
+	if !reflect.DeepEqual(plan.Env, wantEnv) {
+		t.Errorf("Got env changes %v, want %v", plan.Env, wantEnv)
+	}
+	if _, ok := srv.LastDeploy(added.Id); ok {
+		t.Error("PlanPublish redeployed the stack")
+	}
+
+	if _, err := manager.Publish(ctx, cli, app, portainer.DeployOptions{PullImage: true}); err != nil {
+		t.Fatalf("Publish returned error: %s", err)
+	}
+	if opts, _ := srv.LastDeploy(added.Id); opts.Prune || !opts.PullImage {
+		t.Errorf("Got deploy options %+v, want pull only", opts)
+	}
+	plan, err = manager.PlanPublish(ctx, cli, app)
+	if err != nil {
+		t.Fatalf("PlanPublish returned error: %s", err)
+	}
+	if !plan.Unchanged() {
+		t.Errorf("Got plan %+v after publishing, want no changes", plan)
+	}
+}
+
+func TestPlanPublishCreate(t *testing.T) {
+	srv := portainertest.New("test-key")
+	defer srv.Close()
+
+	app := &manager.App{ID: "demo", ComposeFile: []byte("services: {}\n"), EnvFile: []byte("A=1\n")}
+	plan, err := manager.PlanPublish(context.Background(), srv.Client(), app)
+	if err != nil {
+		t.Fatalf("PlanPublish returned error: %s", err)
+	}
+	if !plan.Create || !strings.Contains(plan.Compose, "@@ -0,0 +1 @@\n+services: {}\n") {
+		t.Errorf("Got plan %+v", plan)
+	}
+	if len(plan.Env) != 1 || plan.Env[0].Change != diff.Added {
+		t.Errorf("Got env changes %v, want A added", plan.Env)
+	}
+}
+
+func TestPublishPlanned(t *testing.T) {
+	srv := portainertest.New("test-key")
+	defer srv.Close()
+	added := srv.AddStack("demo", composeFile, nil)
+	cli := srv.Client()
+	ctx := context.Background()
+
+	app := &manager.App{ID: "demo", Path: t.TempDir(), ComposeFile: []byte(composeFile), EnvFile: []byte("A=1\n"), PortainerId: added.Id}
+	plan, err := manager.PlanPublish(ctx, cli, app)
+	if err != nil {
+		t.Fatalf("PlanPublish returned error: %s", err)
+	}
+
+	app.EnvFile = []byte("A=2\n")
+	_, err = manager.PublishPlanned(ctx, cli, app, plan.Digest, portainer.DeployOptions{})
+	if !errors.Is(err, manager.ErrPlanChanged) {
+		t.Errorf("Expected ErrPlanChanged after the env changed, got %v", err)
+	}
+	if _, ok := srv.LastDeploy(added.Id); ok {
+		t.Error("Published a stack that changed since the plan")
+	}
+
+	app.EnvFile = []byte("A=1\n")
+	if _, err := manager.PublishPlanned(ctx, cli, app, plan.Digest, portainer.DeployOptions{}); err != nil {
+		t.Errorf("PublishPlanned returned error: %s", err)
+	}
+}
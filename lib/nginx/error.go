@@ -0,0 +1,59 @@
+package nginx
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var messagePattern = regexp.MustCompile(`^nginx: \[(\w+)\] (.*?)(?: in (\S+):(\d+))?$`)
+
+// Message is a single diagnostic emitted by nginx, such as
+// `nginx: [emerg] unknown directive "foo" in /etc/nginx/x.conf:12`.
+type Message struct {
+	Level string
+	Text  string
+	File  string
+	Line  int
+}
+
+// TestError is returned when nginx rejects the configuration.
+type TestError struct {
+	Command  string
+	Output   string
+	Messages []Message
+	Err      error
+}
+
+func newTestError(command []string, output []byte, err error) *TestError {
+	testErr := &TestError{
+		Command:  strings.Join(command, " "),
+		Output:   string(output),
+		Messages: make([]Message, 0),
+		Err:      err,
+	}
+	for _, line := range strings.Split(testErr.Output, "\n") {
+		match := messagePattern.FindStringSubmatch(strings.TrimSpace(line))
+		if match == nil {
+			continue
+		}
+		msg := Message{Level: match[1], Text: match[2], File: match[3]}
+		msg.Line, _ = strconv.Atoi(match[4])
+		testErr.Messages = append(testErr.Messages, msg)
+	}
+	return testErr
+}
+
+func (e *TestError) Error() string {
+	for _, msg := range e.Messages {
+		if msg.Level == "emerg" || msg.Level == "alert" || msg.Level == "crit" {
+			return fmt.Sprintf("nginx config test failed: %s", msg.Text)
+		}
+	}
+	return fmt.Sprintf("nginx config test failed (%s): %s", e.Err, strings.TrimSpace(e.Output))
+}
+
+func (e *TestError) Unwrap() error {
+	return e.Err
+}
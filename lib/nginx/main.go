@@ -1,22 +1,23 @@
 package nginx
 
 import (
-	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"reflect"
 	"text/template"
 
 	_ "embed"
 
 	"github.com/mr55p-dev/app-utils/config"
+	"github.com/mr55p-dev/app-utils/lib/generate"
+	"github.com/mr55p-dev/app-utils/lib/runner"
 )
 
 type Status string
+type AuthStyle string
 type ConfigFn func(*Client)
 type Client struct {
 	dir            string
@@ -24,6 +25,13 @@ type Client struct {
 	sslCertPath    string
 	sslCertKeyPath string
 	enabledSSL     bool
+	testCommand    []string
+	reloadCommand  []string
+	domains        []string
+	authStyle      AuthStyle
+	authEndpoint   string
+	authLoginURL   string
+	runner         runner.Runner
 }
 
 //go:embed nginx.conf.tmpl
@@ -34,20 +42,16 @@ var (
 	StatusEnabled  Status = "Enabled"
 	StatusDisabled Status = "Disabled"
 
+	AuthVouch       AuthStyle = "vouch"
+	AuthOAuth2Proxy AuthStyle = "oauth2-proxy"
+	AuthAuthelia    AuthStyle = "authelia"
+
+	ErrUnitNotFound = errors.New("Unit not found")
+	ErrAuthRequired = errors.New("Protected host requires an auth proxy to be configured")
+
 	t = template.Must(template.New("nginx.conf.tmpl").Parse(tmpl))
 )
 
-func copyStructToMap(data any) map[string]any {
-	to := make(map[string]any)
-	dataType := reflect.TypeOf(data).Elem()
-	dataVal := reflect.ValueOf(data).Elem()
-	fields := reflect.VisibleFields(dataType)
-	for _, field := range fields {
-		to[field.Name] = dataVal.FieldByName(field.Name).Interface()
-	}
-	return to
-}
-
 func WithDir(dir string) ConfigFn {
 	return func(c *Client) { c.dir = dir }
 }
@@ -64,9 +68,43 @@ func WithDHParams(paramsPath string) ConfigFn {
 	return func(c *Client) { c.dhParamsPath = paramsPath }
 }
 
+// WithDomains sets the base domains units are served under.
+func WithDomains(domains ...string) ConfigFn {
+	return func(c *Client) { c.domains = domains }
+}
+
+// WithAuth enables auth_request protection for blocks marked Protected.
+// endpoint is the URL nginx checks each request against and loginURL is where
+// unauthenticated users are redirected.
+func WithAuth(style AuthStyle, endpoint, loginURL string) ConfigFn {
+	return func(c *Client) {
+		c.authStyle = style
+		c.authEndpoint = endpoint
+		c.authLoginURL = loginURL
+	}
+}
+
+// WithTestCommand sets the command used to validate the configuration after
+// a unit is installed. An empty command disables validation.
+func WithTestCommand(cmd ...string) ConfigFn {
+	return func(c *Client) { c.testCommand = cmd }
+}
+
+func WithReloadCommand(cmd ...string) ConfigFn {
+	return func(c *Client) { c.reloadCommand = cmd }
+}
+
+// WithRunner sets the runner used to execute the test and reload commands.
+func WithRunner(r runner.Runner) ConfigFn {
+	return func(c *Client) { c.runner = r }
+}
+
 func New(config ...ConfigFn) *Client {
 	cli := &Client{
-		dir: "/etc/nginx/sites-enabled",
+		dir:           "/etc/nginx/sites-enabled",
+		testCommand:   []string{"nginx", "-t"},
+		reloadCommand: []string{"nginx", "-s", "reload"},
+		runner:        runner.Exec{},
 	}
 	for _, fn := range config {
 		fn(cli)
@@ -102,72 +140,187 @@ func (c *Client) Status(name string) Status {
 	return StatusEnabled
 }
 
-func (c *Client) CreateUnit(w io.Writer, conf config.NginxBlock) error {
-	templateData := copyStructToMap(&conf)
+// Domains returns the base domains units are served under.
+func (c *Client) Domains() []string {
+	return c.domains
+}
+
+func (c *Client) templateOptions() []generate.NginxOption {
+	opts := []generate.NginxOption{
+		generate.WithDomains(c.domains...),
+		generate.WithData("AuthStyle", string(c.authStyle)),
+		generate.WithData("AuthEndpoint", c.authEndpoint),
+		generate.WithData("AuthLoginURL", c.authLoginURL),
+	}
 	if c.enabledSSL {
-		templateData["SSLEnabled"] = true
-		templateData["SSLCertPath"] = c.sslCertPath
-		templateData["SSLCertKeyPath"] = c.sslCertKeyPath
+		opts = append(opts,
+			generate.WithData("SSLEnabled", true),
+			generate.WithData("SSLCertPath", c.sslCertPath),
+			generate.WithData("SSLCertKeyPath", c.sslCertKeyPath),
+		)
 		if c.dhParamsPath != "" {
-			templateData["SSLDHParamPath"] = c.dhParamsPath
+			opts = append(opts, generate.WithData("SSLDHParamPath", c.dhParamsPath))
+		}
+	}
+	return opts
+}
+
+// ValidAuthStyle returns an error unless style is one of the supported auth
+// proxies.
+func ValidAuthStyle(style AuthStyle) error {
+	switch style {
+	case AuthVouch, AuthOAuth2Proxy, AuthAuthelia:
+		return nil
+	}
+	return fmt.Errorf("Unknown auth style %q, want %s, %s or %s", style, AuthVouch, AuthOAuth2Proxy, AuthAuthelia)
+}
+
+func (c *Client) checkAuth(blocks ...config.NginxBlock) error {
+	for _, block := range blocks {
+		if !block.Protected {
+			continue
+		}
+		if c.authStyle == "" {
+			return fmt.Errorf("%s: %w", block.ExternalHost, ErrAuthRequired)
+		}
+		if err := ValidAuthStyle(c.authStyle); err != nil {
+			return fmt.Errorf("%s: %w: %w", block.ExternalHost, ErrAuthRequired, err)
+		}
+		if c.authEndpoint == "" || c.authLoginURL == "" {
+			return fmt.Errorf("%s: %w: auth endpoint and login URL must both be set", block.ExternalHost, ErrAuthRequired)
 		}
 	}
+	return nil
+}
+
+func (c *Client) CreateUnit(w io.Writer, conf config.NginxBlock) error {
+	if err := c.checkAuth(conf); err != nil {
+		return err
+	}
+	return generate.NginxUnit(w, t, conf, c.templateOptions()...)
+}
+
+// run executes command, returning stdout and stderr together as nginx
+// reports its diagnostics on either.
+func (c *Client) run(ctx context.Context, command []string) ([]byte, error) {
+	stdout, stderr, err := c.runner.Run(ctx, runner.Cmd{
+		Name: command[0],
+		Args: command[1:],
+	})
+	return append(stdout, stderr...), err
+}
 
-	err := t.Execute(w, templateData)
+// Test validates the current nginx configuration, returning a *TestError
+// describing the problems if it is rejected. If ctx ends before nginx
+// answers the context's error is returned instead.
+func (c *Client) Test(ctx context.Context) error {
+	if len(c.testCommand) == 0 {
+		return nil
+	}
+	output, err := c.run(ctx, c.testCommand)
+	if err != nil && ctx.Err() != nil {
+		return fmt.Errorf("Failed to test nginx config: %w", err)
+	}
 	if err != nil {
-		return fmt.Errorf("Failed to exec template: %w", err)
+		return newTestError(c.testCommand, output, err)
 	}
 	return nil
 }
 
-func (c *Client) Reload() error {
-	buf := new(bytes.Buffer)
-	cmd := exec.Command("nginx", "-s", "reload")
-	cmd.Stdout = buf
-	cmd.Stderr = buf
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("Failed to reload nginx: %s", buf.String())
+// Reload validates the configuration and then reloads nginx. Nginx is left
+// untouched if validation fails.
+func (c *Client) Reload(ctx context.Context) error {
+	if err := c.Test(ctx); err != nil {
+		return fmt.Errorf("Refusing to reload nginx: %w", err)
+	}
+	return c.reload(ctx)
+}
+
+// reload reloads nginx without validating the configuration first, for
+// callers that just have.
+func (c *Client) reload(ctx context.Context) error {
+	if len(c.reloadCommand) == 0 {
+		return errors.New("No reload command configured")
+	}
+	output, err := c.run(ctx, c.reloadCommand)
+	if err != nil {
+		return fmt.Errorf("Failed to reload nginx: %w: %s", err, output)
 	}
 	return nil
 }
 
-func (c *Client) InstallUnit(r io.Reader, name string) error {
+// InstallUnit writes the unit for name and validates the resulting
+// configuration. If validation fails the previous unit is put back (or the
+// new one removed if there was none) and the *TestError is returned.
+func (c *Client) InstallUnit(ctx context.Context, r io.Reader, name string) error {
+	_, err := c.install(ctx, r, name)
+	return err
+}
+
+// install does the work of InstallUnit, returning a function that undoes it
+// when the unit was installed.
+func (c *Client) install(ctx context.Context, r io.Reader, name string) (func() error, error) {
 	data, err := io.ReadAll(r)
 	if err != nil {
-		return fmt.Errorf("Failed to read data: %w", err)
+		return nil, fmt.Errorf("Failed to read data: %w", err)
 	}
 
 	path := c.pathFromName(name)
+	previous, err := os.ReadFile(path)
+	existed := err == nil
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("Failed to read existing unit: %w", err)
+	}
+	restore := func() error {
+		if existed {
+			return os.WriteFile(path, previous, 0o660)
+		}
+		return os.Remove(path)
+	}
+
 	err = os.WriteFile(path, data, 0o660)
 	if err != nil {
-		return fmt.Errorf("Failed writing: %w", err)
+		return nil, fmt.Errorf("Failed writing: %w", err)
 	}
-	return nil
-}
 
-func (c *Client) CreateAndInstallUnits(id string, blocks []config.NginxBlock) error {
-	buf := new(bytes.Buffer)
-	fmt.Println("Will create units")
-	for _, block := range blocks {
-		err := c.CreateUnit(buf, block)
-		if err != nil {
-			return fmt.Errorf("Error creating unit: %w", err)
+	if testErr := c.Test(ctx); testErr != nil {
+		if err := restore(); err != nil {
+			return nil, fmt.Errorf("Failed to roll back unit after %w: %s", testErr, err)
 		}
-		fmt.Fprint(buf, "\n\n")
+		return nil, fmt.Errorf("Rolled back unit: %w", testErr)
 	}
-	fmt.Println("Created units, will install")
-	err := c.InstallUnit(buf, id)
+	return restore, nil
+}
+
+// CreateAndInstallUnits renders the blocks into the unit for id, installs it
+// and reloads nginx. The configuration is only tested once, by the install,
+// and the unit is rolled back if the reload fails.
+func (c *Client) CreateAndInstallUnits(ctx context.Context, id string, blocks []config.NginxBlock) error {
+	if err := c.checkAuth(blocks...); err != nil {
+		return fmt.Errorf("Error creating unit: %w", err)
+	}
+	units, err := generate.Nginx(t, blocks, c.templateOptions()...)
+	if err != nil {
+		return fmt.Errorf("Error creating unit: %w", err)
+	}
+	restore, err := c.install(ctx, units, id)
 	if err != nil {
 		return fmt.Errorf("Error installing unit: %w", err)
 	}
-	fmt.Println("Done with installing")
+	err = c.reload(ctx)
+	if err != nil {
+		if restoreErr := restore(); restoreErr != nil {
+			return fmt.Errorf("Error reloading: %w, failed to roll back unit: %s", err, restoreErr)
+		}
+		return fmt.Errorf("Error reloading, rolled back unit: %w", err)
+	}
 	return nil
 }
 
 func (c *Client) RemoveUnit(name string) error {
 	path := c.pathFromName(name)
 	if !FileExists(path) {
-		return errors.New("Unit not found")
+		return ErrUnitNotFound
 	}
 
 	err := os.Remove(path)
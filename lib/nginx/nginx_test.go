@@ -0,0 +1,322 @@
+package nginx
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mr55p-dev/app-utils/config"
+	"github.com/mr55p-dev/app-utils/lib/runner/runnertest"
+)
+
+var update = flag.Bool("update", false, "Update golden files")
+
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatalf("Failed to update golden file: %s", err)
+		}
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read golden file: %s", err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("Output does not match %s\n--- got ---\n%s\n--- want ---\n%s", path, got, want)
+	}
+}
+
+var (
+	publicBlock = config.NginxBlock{
+		ExternalHost: "public",
+		Protocol:     "http",
+		IPv4:         "10.0.0.2",
+		Port:         8080,
+	}
+	protectedBlock = config.NginxBlock{
+		ExternalHost: "private",
+		Protocol:     "http",
+		IPv4:         "10.0.0.3",
+		Port:         9000,
+		Protected:    true,
+	}
+)
+
+func TestCreateUnit(t *testing.T) {
+	tests := []struct {
+		name   string
+		golden string
+		opts   []ConfigFn
+		block  config.NginxBlock
+	}{
+		{
+			name:   "public",
+			golden: "public.conf.golden",
+			opts:   []ConfigFn{WithDomains("home.pagemail.io")},
+			block:  publicBlock,
+		},
+		{
+			name:   "public with ssl",
+			golden: "public-ssl.conf.golden",
+			opts: []ConfigFn{
+				WithDomains("home.pagemail.io"),
+				WithSSL("/etc/ssl/cert.pem", "/etc/ssl/key.pem"),
+				WithDHParams("/etc/ssl/dhparam.txt"),
+			},
+			block: publicBlock,
+		},
+		{
+			name:   "public ignores auth",
+			golden: "public.conf.golden",
+			opts: []ConfigFn{
+				WithDomains("home.pagemail.io"),
+				WithAuth(AuthVouch, "http://vouch.internal:9090/validate", "https://vouch.home.pagemail.io/login"),
+			},
+			block: publicBlock,
+		},
+		{
+			name:   "protected vouch",
+			golden: "protected-vouch.conf.golden",
+			opts: []ConfigFn{
+				WithDomains("home.pagemail.io"),
+				WithAuth(AuthVouch, "http://vouch.internal:9090/validate", "https://vouch.home.pagemail.io/login"),
+			},
+			block: protectedBlock,
+		},
+		{
+			name:   "protected oauth2-proxy",
+			golden: "protected-oauth2-proxy.conf.golden",
+			opts: []ConfigFn{
+				WithDomains("home.pagemail.io"),
+				WithAuth(AuthOAuth2Proxy, "http://oauth2-proxy.internal:4180/oauth2/auth", "https://auth.home.pagemail.io/oauth2/start"),
+			},
+			block: protectedBlock,
+		},
+		{
+			name:   "protected authelia",
+			golden: "protected-authelia.conf.golden",
+			opts: []ConfigFn{
+				WithDomains("home.pagemail.io"),
+				WithAuth(AuthAuthelia, "http://authelia.internal:9091/api/authz/auth-request", "https://auth.home.pagemail.io"),
+			},
+			block: protectedBlock,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			if err := New(tc.opts...).CreateUnit(buf, tc.block); err != nil {
+				t.Fatalf("CreateUnit returned error: %s", err)
+			}
+			checkGolden(t, tc.golden, buf.Bytes())
+		})
+	}
+}
+
+func TestCreateUnitProtectedBlocksGetAuthRequest(t *testing.T) {
+	for _, style := range []AuthStyle{AuthVouch, AuthOAuth2Proxy, AuthAuthelia} {
+		buf := new(bytes.Buffer)
+		cli := New(WithAuth(style, "http://auth.internal/check", "https://auth.example.com/login"))
+		if err := cli.CreateUnit(buf, protectedBlock); err != nil {
+			t.Fatalf("%s: CreateUnit returned error: %s", style, err)
+		}
+		if !strings.Contains(buf.String(), "auth_request ") {
+			t.Errorf("%s: protected unit is missing auth_request", style)
+		}
+		if !strings.Contains(buf.String(), "proxy_pass http://auth.internal/check;") {
+			t.Errorf("%s: protected unit does not use the auth endpoint", style)
+		}
+
+		buf.Reset()
+		if err := cli.CreateUnit(buf, publicBlock); err != nil {
+			t.Fatalf("%s: CreateUnit returned error: %s", style, err)
+		}
+		if strings.Contains(buf.String(), "auth_request") {
+			t.Errorf("%s: public unit has auth_request", style)
+		}
+	}
+}
+
+func TestCreateUnitProtectedWithoutAuth(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []ConfigFn
+	}{
+		{"no auth", nil},
+		{"unknown style", []ConfigFn{WithAuth("vuoch", "http://auth.internal/check", "https://auth.example.com/login")}},
+		{"no endpoint", []ConfigFn{WithAuth(AuthVouch, "", "https://auth.example.com/login")}},
+		{"no login url", []ConfigFn{WithAuth(AuthVouch, "http://auth.internal/check", "")}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := New(tc.opts...).CreateUnit(new(bytes.Buffer), protectedBlock)
+			if !errors.Is(err, ErrAuthRequired) {
+				t.Fatalf("Expected ErrAuthRequired, got %v", err)
+			}
+		})
+	}
+}
+
+func TestValidAuthStyle(t *testing.T) {
+	for _, style := range []AuthStyle{AuthVouch, AuthOAuth2Proxy, AuthAuthelia} {
+		if err := ValidAuthStyle(style); err != nil {
+			t.Errorf("%s: ValidAuthStyle returned error: %s", style, err)
+		}
+	}
+	for _, style := range []AuthStyle{"", "vuoch", "Vouch"} {
+		if err := ValidAuthStyle(style); err == nil {
+			t.Errorf("Expected an error for %q", style)
+		}
+	}
+}
+
+const testFailure = `nginx: [emerg] unknown directive "proxy_pas" in /etc/nginx/sites-enabled/demo.gold.nginx.conf:14
+nginx: configuration file /etc/nginx/nginx.conf test failed
+`
+
+func TestReload(t *testing.T) {
+	fake := runnertest.New()
+	if err := New(WithRunner(fake)).Reload(context.Background()); err != nil {
+		t.Fatalf("Reload returned error: %s", err)
+	}
+	want := []string{"nginx -t", "nginx -s reload"}
+	if got := fake.Commands(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Got %v, want %v", got, want)
+	}
+}
+
+func TestReloadTestFailure(t *testing.T) {
+	fake := runnertest.New().On("nginx -t", runnertest.Response{
+		Stderr: testFailure,
+		Err:    runnertest.ErrExit,
+	})
+	err := New(WithRunner(fake)).Reload(context.Background())
+
+	testErr := new(TestError)
+	if !errors.As(err, &testErr) {
+		t.Fatalf("Expected a TestError, got %v", err)
+	}
+	want := []Message{{
+		Level: "emerg",
+		Text:  `unknown directive "proxy_pas"`,
+		File:  "/etc/nginx/sites-enabled/demo.gold.nginx.conf",
+		Line:  14,
+	}}
+	if !reflect.DeepEqual(testErr.Messages, want) {
+		t.Errorf("Got messages %+v, want %+v", testErr.Messages, want)
+	}
+	if got := fake.Commands(); !reflect.DeepEqual(got, []string{"nginx -t"}) {
+		t.Errorf("Reload should not run after a failed test, got %v", got)
+	}
+}
+
+func TestReloadFailure(t *testing.T) {
+	fake := runnertest.New().On("nginx -s reload", runnertest.Response{
+		Stderr: "nginx: [error] invalid PID number \"\" in \"/run/nginx.pid\"",
+		Err:    runnertest.ErrExit,
+	})
+	err := New(WithRunner(fake)).Reload(context.Background())
+	if err == nil {
+		t.Fatal("Expected an error")
+	}
+	if !strings.Contains(err.Error(), "invalid PID number") {
+		t.Errorf("Error does not include nginx output: %s", err)
+	}
+}
+
+func TestInstallUnitRollback(t *testing.T) {
+	dir := t.TempDir()
+	fake := runnertest.New().On("nginx -t", runnertest.Response{
+		Stderr: testFailure,
+		Err:    runnertest.ErrExit,
+	})
+	cli := New(WithDir(dir), WithRunner(fake))
+
+	path := filepath.Join(dir, "demo.gold.nginx.conf")
+	if err := os.WriteFile(path, []byte("previous"), 0o660); err != nil {
+		t.Fatal(err)
+	}
+	err := cli.InstallUnit(context.Background(), strings.NewReader("broken"), "demo")
+	if !errors.As(err, new(*TestError)) {
+		t.Fatalf("Expected a TestError, got %v", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "previous" {
+		t.Errorf("Previous unit was not restored, got %q", data)
+	}
+
+	err = cli.InstallUnit(context.Background(), strings.NewReader("broken"), "fresh")
+	if !errors.As(err, new(*TestError)) {
+		t.Fatalf("Expected a TestError, got %v", err)
+	}
+	if cli.Status("fresh") != StatusDisabled {
+		t.Error("New unit was not removed after a failed test")
+	}
+}
+
+func TestInstallUnit(t *testing.T) {
+	dir := t.TempDir()
+	fake := runnertest.New()
+	cli := New(WithDir(dir), WithRunner(fake))
+	if err := cli.InstallUnit(context.Background(), strings.NewReader("server {}"), "demo"); err != nil {
+		t.Fatalf("InstallUnit returned error: %s", err)
+	}
+	if cli.Status("demo") != StatusEnabled {
+		t.Error("Unit was not installed")
+	}
+	if got := fake.Commands(); !reflect.DeepEqual(got, []string{"nginx -t"}) {
+		t.Errorf("Got %v, want the config to be tested", got)
+	}
+}
+
+func TestCreateAndInstallUnits(t *testing.T) {
+	dir := t.TempDir()
+	fake := runnertest.New()
+	cli := New(WithDir(dir), WithRunner(fake))
+	if err := cli.CreateAndInstallUnits(context.Background(), "demo", []config.NginxBlock{publicBlock}); err != nil {
+		t.Fatalf("CreateAndInstallUnits returned error: %s", err)
+	}
+	if cli.Status("demo") != StatusEnabled {
+		t.Error("Unit was not installed")
+	}
+	want := []string{"nginx -t", "nginx -s reload"}
+	if got := fake.Commands(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Got %v, want %v", got, want)
+	}
+}
+
+func TestCreateAndInstallUnitsReloadFailure(t *testing.T) {
+	dir := t.TempDir()
+	fake := runnertest.New().On("nginx -s reload", runnertest.Response{
+		Stderr: "nginx: [error] invalid PID number \"\" in \"/run/nginx.pid\"",
+		Err:    runnertest.ErrExit,
+	})
+	cli := New(WithDir(dir), WithRunner(fake))
+	blocks := []config.NginxBlock{publicBlock}
+
+	path := filepath.Join(dir, "demo.gold.nginx.conf")
+	if err := os.WriteFile(path, []byte("previous"), 0o660); err != nil {
+		t.Fatal(err)
+	}
+	if err := cli.CreateAndInstallUnits(context.Background(), "demo", blocks); err == nil {
+		t.Fatal("Expected an error")
+	}
+	if data, _ := os.ReadFile(path); string(data) != "previous" {
+		t.Errorf("Previous unit was not restored, got %q", data)
+	}
+
+	if err := cli.CreateAndInstallUnits(context.Background(), "fresh", blocks); err == nil {
+		t.Fatal("Expected an error")
+	}
+	if cli.Status("fresh") != StatusDisabled {
+		t.Error("New unit was not removed after a failed reload")
+	}
+}
@@ -0,0 +1,202 @@
+package portainer
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mr55p-dev/app-utils/lib/dotenv"
+)
+
+// tokenRefreshMargin is how long before it expires a token is replaced, so a
+// request is never sent with one about to lapse.
+const tokenRefreshMargin = time.Minute
+
+// Credentials are a portainer username and password, used to log in through
+// /api/auth where API keys are unavailable.
+type Credentials struct {
+	Username string
+	Password string
+}
+
+// LoadCredentials reads PORTAINER_USERNAME and PORTAINER_PASSWORD from the
+// env file at path, or from the environment when path is empty. It returns
+// nil when no username is set.
+func LoadCredentials(path string) (*Credentials, error) {
+	values := map[string]string{
+		"PORTAINER_USERNAME": os.Getenv("PORTAINER_USERNAME"),
+		"PORTAINER_PASSWORD": os.Getenv("PORTAINER_PASSWORD"),
+	}
+	if path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to open credentials file: %w", err)
+		}
+		defer f.Close()
+		entries, err := dotenv.Parse(f)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse credentials file: %w", err)
+		}
+		values = map[string]string{}
+		for _, entry := range entries {
+			values[entry.Key] = entry.Value
+		}
+	}
+
+	creds := &Credentials{
+		Username: values["PORTAINER_USERNAME"],
+		Password: values["PORTAINER_PASSWORD"],
+	}
+	if creds.Username == "" {
+		return nil, nil
+	}
+	if creds.Password == "" {
+		return nil, fmt.Errorf("No password set for portainer user %s", creds.Username)
+	}
+	return creds, nil
+}
+
+// tokenCache holds the JWT the client last logged in with.
+type tokenCache struct {
+	mu      sync.Mutex
+	jwt     string
+	expires time.Time
+	// rejected is set once portainer refuses the credentials, after which
+	// the API key is used without trying to log in again.
+	rejected error
+}
+
+// tokens returns the client's token cache.
+func (cli *Client) tokens() *tokenCache {
+	if cli.sharedAuth != nil {
+		return cli.sharedAuth
+	}
+	return &cli.auth
+}
+
+type authRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type authResponse struct {
+	JWT string `json:"jwt"`
+}
+
+// tokenExpiry reads the exp claim of a JWT. The signature is not checked,
+// portainer does that. A zero time is returned if there is no expiry, in
+// which case the token is used until portainer rejects it.
+func tokenExpiry(jwt string) time.Time {
+	parts := strings.Split(jwt, ".")
+	if len(parts) != 3 {
+		return time.Time{}
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Exp == 0 {
+		return time.Time{}
+	}
+	return time.Unix(claims.Exp, 0)
+}
+
+// login exchanges the client's credentials for a JWT.
+func (cli *Client) login(ctx context.Context) (string, error) {
+	body, err := json.Marshal(authRequest{
+		Username: cli.Credentials.Username,
+		Password: cli.Credentials.Password,
+	})
+	if err != nil {
+		return "", fmt.Errorf("Failed to marshal credentials: %w", err)
+	}
+	res := new(authResponse)
+	err = cli.doJSON(ctx, request{
+		method:      http.MethodPost,
+		url:         cli.newUrl("/api/auth"),
+		contentType: "application/json",
+		body:        body,
+		anonymous:   true,
+	}, res)
+	if err != nil {
+		return "", fmt.Errorf("Failed to log in to portainer as %s: %w", cli.Credentials.Username, err)
+	}
+	if res.JWT == "" {
+		return "", fmt.Errorf("Failed to log in to portainer as %s: no token returned", cli.Credentials.Username)
+	}
+	return res.JWT, nil
+}
+
+// token returns a JWT for the client's credentials, logging in again when
+// there is none cached or it is about to expire.
+func (cli *Client) token(ctx context.Context) (string, error) {
+	cache := cli.tokens()
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if cache.rejected != nil {
+		return "", cache.rejected
+	}
+	if cache.jwt != "" && (cache.expires.IsZero() || time.Until(cache.expires) > tokenRefreshMargin) {
+		return cache.jwt, nil
+	}
+
+	jwt, err := cli.login(ctx)
+	if err != nil {
+		apiErr := new(APIError)
+		if errors.As(err, &apiErr) && apiErr.StatusCode < http.StatusInternalServerError {
+			cache.rejected = err
+		}
+		return "", err
+	}
+	cache.jwt = jwt
+	cache.expires = tokenExpiry(jwt)
+	return jwt, nil
+}
+
+// invalidateToken drops the cached token after portainer rejected it, unless
+// it has already been replaced by another request. It reports whether there
+// was a token to drop.
+func (cli *Client) invalidateToken(header string) bool {
+	jwt, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok {
+		return false
+	}
+	cache := cli.tokens()
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if cache.jwt == jwt {
+		cache.jwt = ""
+		cache.expires = time.Time{}
+	}
+	return true
+}
+
+// authorize adds credentials to req. A JWT is used when the client has a
+// username and password, falling back to the API key if logging in fails.
+func (cli *Client) authorize(ctx context.Context, req *http.Request) error {
+	if cli.Credentials != nil {
+		jwt, err := cli.token(ctx)
+		if err == nil {
+			req.Header.Set("Authorization", "Bearer "+jwt)
+			return nil
+		}
+		if cli.ApiKey == "" || ctx.Err() != nil {
+			return err
+		}
+	}
+	if cli.ApiKey != "" {
+		req.Header.Set("X-Api-Key", cli.ApiKey)
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+package portainer_test
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mr55p-dev/app-utils/lib/portainer"
+	"github.com/mr55p-dev/app-utils/lib/portainer/portainertest"
+)
+
+// newLoginClient returns a client for a server without API keys that logs
+// in as admin.
+func newLoginClient(t *testing.T) (*portainertest.Server, *portainer.Client) {
+	t.Helper()
+	srv := portainertest.New("")
+	t.Cleanup(srv.Close)
+	srv.AddUser("admin", "hunter2")
+	cli := srv.Client()
+	cli.Credentials = &portainer.Credentials{Username: "admin", Password: "hunter2"}
+	return srv, cli
+}
+
+func authOf(srv *portainertest.Server, path string) []portainertest.Auth {
+	auths := make([]portainertest.Auth, 0)
+	for _, req := range srv.Requests() {
+		if req.Path == path {
+			auths = append(auths, req.Auth)
+		}
+	}
+	return auths
+}
+
+func TestLogin(t *testing.T) {
+	srv, cli := newLoginClient(t)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if _, err := cli.ListStacks(ctx); err != nil {
+			t.Fatalf("ListStacks returned error: %s", err)
+		}
+	}
+	if n := srv.Logins(); n != 1 {
+		t.Errorf("Logged in %d times, want the token to be cached", n)
+	}
+	for _, auth := range authOf(srv, "/api/stacks") {
+		if auth != portainertest.AuthJWT {
+			t.Errorf("Got request authenticated by %q, want jwt", auth)
+		}
+	}
+}
+
+func TestLoginRefreshesExpiringToken(t *testing.T) {
+	srv, cli := newLoginClient(t)
+	srv.TokenLifetime = 30 * time.Second
+
+	for i := 0; i < 2; i++ {
+		if _, err := cli.ListStacks(context.Background()); err != nil {
+			t.Fatalf("ListStacks returned error: %s", err)
+		}
+	}
+	if n := srv.Logins(); n != 2 {
+		t.Errorf("Logged in %d times, want a new token for each request", n)
+	}
+}
+
+func TestLoginRefreshesRejectedToken(t *testing.T) {
+	srv, cli := newLoginClient(t)
+	ctx := context.Background()
+
+	if _, err := cli.ListStacks(ctx); err != nil {
+		t.Fatalf("ListStacks returned error: %s", err)
+	}
+	srv.ExpireTokens()
+	if _, err := cli.ListStacks(ctx); err != nil {
+		t.Fatalf("ListStacks returned error after the token was revoked: %s", err)
+	}
+	if n := srv.Logins(); n != 2 {
+		t.Errorf("Logged in %d times, want 2", n)
+	}
+	want := []portainertest.Auth{portainertest.AuthJWT, portainertest.AuthNone, portainertest.AuthJWT}
+	if got := authOf(srv, "/api/stacks"); len(got) != len(want) || got[1] != want[1] || got[2] != want[2] {
+		t.Errorf("Got auth %q, want %q", got, want)
+	}
+}
+
+func TestLoginFallsBackToAPIKey(t *testing.T) {
+	srv := portainertest.New("test-key")
+	t.Cleanup(srv.Close)
+	srv.AddUser("admin", "hunter2")
+	cli := srv.Client()
+	cli.Credentials = &portainer.Credentials{Username: "admin", Password: "wrong"}
+
+	for i := 0; i < 2; i++ {
+		if _, err := cli.ListStacks(context.Background()); err != nil {
+			t.Fatalf("ListStacks returned error: %s", err)
+		}
+	}
+	if n := srv.Logins(); n != 1 {
+		t.Errorf("Logged in %d times, want rejected credentials not to be retried", n)
+	}
+	for _, auth := range authOf(srv, "/api/stacks") {
+		if auth != portainertest.AuthAPIKey {
+			t.Errorf("Got request authenticated by %q, want api-key", auth)
+		}
+	}
+}
+
+func TestLoginUnavailableFallsBackToAPIKey(t *testing.T) {
+	srv := portainertest.New("test-key")
+	t.Cleanup(srv.Close)
+	srv.AddUser("admin", "hunter2")
+	cli := srv.Client()
+	cli.Credentials = &portainer.Credentials{Username: "admin", Password: "hunter2"}
+
+	srv.FailNext(http.StatusBadGateway)
+	if _, err := cli.ListStacks(context.Background()); err != nil {
+		t.Fatalf("ListStacks returned error: %s", err)
+	}
+	if _, err := cli.ListStacks(context.Background()); err != nil {
+		t.Fatalf("ListStacks returned error: %s", err)
+	}
+	want := []portainertest.Auth{portainertest.AuthAPIKey, portainertest.AuthJWT}
+	got := authOf(srv, "/api/stacks")
+	if len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("Got auth %q, want %q", got, want)
+	}
+}
+
+func TestLoginRejected(t *testing.T) {
+	srv, cli := newLoginClient(t)
+	cli.Credentials.Password = "wrong"
+
+	_, err := cli.ListStacks(context.Background())
+	asAPIError(t, err, http.StatusUnprocessableEntity)
+	if n := len(authOf(srv, "/api/stacks")); n != 0 {
+		t.Errorf("Sent %d requests without credentials", n)
+	}
+}
+
+func TestLoadCredentials(t *testing.T) {
+	t.Setenv("PORTAINER_USERNAME", "")
+	t.Setenv("PORTAINER_PASSWORD", "")
+	creds, err := portainer.LoadCredentials("")
+	if err != nil || creds != nil {
+		t.Errorf("Got %v, %v without a username, want nothing", creds, err)
+	}
+
+	t.Setenv("PORTAINER_USERNAME", "admin")
+	if _, err := portainer.LoadCredentials(""); err == nil {
+		t.Error("Expected an error without a password")
+	}
+
+	t.Setenv("PORTAINER_PASSWORD", "from env")
+	creds, err = portainer.LoadCredentials("")
+	if err != nil {
+		t.Fatalf("LoadCredentials returned error: %s", err)
+	}
+	if *creds != (portainer.Credentials{Username: "admin", Password: "from env"}) {
+		t.Errorf("Got %+v from the environment", *creds)
+	}
+
+	path := filepath.Join(t.TempDir(), "portainer.env")
+	if err := os.WriteFile(path, []byte("PORTAINER_USERNAME=deploy\nPORTAINER_PASSWORD='s3cret #1'\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	creds, err = portainer.LoadCredentials(path)
+	if err != nil {
+		t.Fatalf("LoadCredentials returned error: %s", err)
+	}
+	if *creds != (portainer.Credentials{Username: "deploy", Password: "s3cret #1"}) {
+		t.Errorf("Got %+v from the file", *creds)
+	}
+
+	if _, err := portainer.LoadCredentials(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("Expected an error for a missing file")
+	}
+}
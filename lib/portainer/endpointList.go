@@ -0,0 +1,168 @@
+package portainer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+type EndpointType int
+type EndpointStatus int
+
+const (
+	EndpointTypeDocker          EndpointType = 1
+	EndpointTypeAgent           EndpointType = 2
+	EndpointTypeAzure           EndpointType = 3
+	EndpointTypeEdgeAgent       EndpointType = 4
+	EndpointTypeKubernetes      EndpointType = 5
+	EndpointTypeKubernetesAgent EndpointType = 6
+	EndpointTypeKubernetesEdge  EndpointType = 7
+
+	EndpointStatusUp   EndpointStatus = 1
+	EndpointStatusDown EndpointStatus = 2
+)
+
+var (
+	ErrEndpointNotFound = errors.New("Endpoint not found")
+	ErrWrongEndpoint    = errors.New("Stack is on a different endpoint")
+)
+
+func (t EndpointType) String() string {
+	switch t {
+	case EndpointTypeDocker:
+		return "docker"
+	case EndpointTypeAgent:
+		return "agent"
+	case EndpointTypeAzure:
+		return "azure"
+	case EndpointTypeEdgeAgent:
+		return "edge agent"
+	case EndpointTypeKubernetes:
+		return "kubernetes"
+	case EndpointTypeKubernetesAgent:
+		return "kubernetes agent"
+	case EndpointTypeKubernetesEdge:
+		return "kubernetes edge agent"
+	}
+	return "unknown"
+}
+
+func (s EndpointStatus) String() string {
+	switch s {
+	case EndpointStatusUp:
+		return "up"
+	case EndpointStatusDown:
+		return "down"
+	}
+	return "unknown"
+}
+
+// Endpoint is an environment, usually a docker host, managed by portainer.
+type Endpoint struct {
+	Id     int            `json:"Id"`
+	Name   string         `json:"Name"`
+	Type   EndpointType   `json:"Type"`
+	URL    string         `json:"URL"`
+	Status EndpointStatus `json:"Status"`
+}
+
+// ListEndpoints returns every endpoint the client's user can see.
+func (cli *Client) ListEndpoints(ctx context.Context) ([]Endpoint, error) {
+	endpoints := make([]Endpoint, 0)
+	err := cli.doJSON(ctx, request{method: http.MethodGet, url: cli.newUrl("/api/endpoints")}, &endpoints)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list endpoints: %w", err)
+	}
+	return endpoints, nil
+}
+
+// EndpointNames maps endpoint IDs to their names.
+func (cli *Client) EndpointNames(ctx context.Context) (map[int]string, error) {
+	endpoints, err := cli.ListEndpoints(ctx)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[int]string, len(endpoints))
+	for _, endpoint := range endpoints {
+		names[endpoint.Id] = endpoint.Name
+	}
+	return names, nil
+}
+
+// ResolveEndpoint finds the endpoint ref refers to, either by ID or by name.
+func (cli *Client) ResolveEndpoint(ctx context.Context, ref string) (*Endpoint, error) {
+	endpoints, err := cli.ListEndpoints(ctx)
+	if err != nil {
+		return nil, err
+	}
+	id, idErr := strconv.Atoi(ref)
+	for _, endpoint := range endpoints {
+		if idErr == nil && endpoint.Id == id {
+			return &endpoint, nil
+		}
+	}
+	for _, endpoint := range endpoints {
+		if endpoint.Name == ref {
+			return &endpoint, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrEndpointNotFound, ref)
+}
+
+// OnEndpoint returns a client for the same portainer targeting endpointId,
+// which shares cli's login. An endpointId of 0 targets no endpoint, so that
+// ListStacks returns the stacks on every endpoint.
+func (cli *Client) OnEndpoint(endpointId int) *Client {
+	target := &Client{
+		Scheme:       cli.Scheme,
+		Host:         cli.Host,
+		ApiKey:       cli.ApiKey,
+		Credentials:  cli.Credentials,
+		HTTPClient:   cli.HTTPClient,
+		Timeout:      cli.Timeout,
+		Retries:      cli.Retries,
+		RetryBackoff: cli.RetryBackoff,
+		sharedAuth:   cli.tokens(),
+	}
+	if endpointId != 0 {
+		target.EndpointId = strconv.Itoa(endpointId)
+	}
+	return target
+}
+
+// ForEndpoint returns a client targeting the endpoint ref names, by ID or
+// name. An empty ref keeps the client's own endpoint and returns cli.
+func (cli *Client) ForEndpoint(ctx context.Context, ref string) (*Client, error) {
+	if ref == "" {
+		return cli, nil
+	}
+	endpoint, err := cli.ResolveEndpoint(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	return cli.OnEndpoint(endpoint.Id), nil
+}
+
+// ForStack returns a client targeting the endpoint stackId is deployed on,
+// which is where portainer expects changes to the stack to be sent. When ref
+// is set the stack must be on that endpoint, otherwise ErrWrongEndpoint is
+// returned.
+func (cli *Client) ForStack(ctx context.Context, stackId int, ref string) (*Client, error) {
+	stack, err := cli.GetStack(ctx, stackId)
+	if err != nil {
+		return nil, err
+	}
+	if ref != "" {
+		endpoint, err := cli.ResolveEndpoint(ctx, ref)
+		if err != nil {
+			return nil, err
+		}
+		if endpoint.Id != stack.EndpointId {
+			return nil, fmt.Errorf("%w: stack %d is on endpoint %d, not %s",
+				ErrWrongEndpoint, stackId, stack.EndpointId, endpoint.Name)
+		}
+	}
+	return cli.OnEndpoint(stack.EndpointId), nil
+}
@@ -0,0 +1,154 @@
+package portainer_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/mr55p-dev/app-utils/lib/portainer"
+	"github.com/mr55p-dev/app-utils/lib/portainer/portainertest"
+)
+
+func TestListEndpoints(t *testing.T) {
+	srv := newServer(t)
+	srv.AddEndpoint("edge")
+	cli := srv.Client()
+
+	endpoints, err := cli.ListEndpoints(context.Background())
+	if err != nil {
+		t.Fatalf("ListEndpoints returned error: %s", err)
+	}
+	if len(endpoints) != 2 || endpoints[0].Name != "local" || endpoints[1].Name != "edge" {
+		t.Fatalf("Got endpoints %+v", endpoints)
+	}
+	if endpoints[1].Type != portainer.EndpointTypeAgent || endpoints[1].Status != portainer.EndpointStatusUp {
+		t.Errorf("Got %s endpoint which is %s", endpoints[1].Type, endpoints[1].Status)
+	}
+
+	names, err := cli.EndpointNames(context.Background())
+	if err != nil {
+		t.Fatalf("EndpointNames returned error: %s", err)
+	}
+	if names[portainertest.EndpointId] != "local" || names[endpoints[1].Id] != "edge" {
+		t.Errorf("Got names %v", names)
+	}
+}
+
+func TestResolveEndpoint(t *testing.T) {
+	srv := newServer(t)
+	edge := srv.AddEndpoint("edge")
+	// An endpoint named like another's ID must not shadow it.
+	srv.AddEndpoint("1")
+	cli := srv.Client()
+
+	tests := []struct {
+		ref  string
+		want int
+	}{
+		{"edge", edge.Id},
+		{"local", portainertest.EndpointId},
+		{"2", edge.Id},
+		{"1", portainertest.EndpointId},
+	}
+	for _, tc := range tests {
+		t.Run(tc.ref, func(t *testing.T) {
+			endpoint, err := cli.ResolveEndpoint(context.Background(), tc.ref)
+			if err != nil {
+				t.Fatalf("ResolveEndpoint returned error: %s", err)
+			}
+			if endpoint.Id != tc.want {
+				t.Errorf("Got endpoint %d, want %d", endpoint.Id, tc.want)
+			}
+		})
+	}
+
+	_, err := cli.ResolveEndpoint(context.Background(), "missing")
+	if !errors.Is(err, portainer.ErrEndpointNotFound) {
+		t.Errorf("Expected ErrEndpointNotFound, got %v", err)
+	}
+}
+
+func TestForEndpoint(t *testing.T) {
+	srv := newServer(t)
+	edge := srv.AddEndpoint("edge")
+	cli := srv.Client()
+	ctx := context.Background()
+
+	same, err := cli.ForEndpoint(ctx, "")
+	if err != nil || same != cli {
+		t.Errorf("Expected an empty ref to keep the client, got %v, %v", same, err)
+	}
+
+	target, err := cli.ForEndpoint(ctx, "edge")
+	if err != nil {
+		t.Fatalf("ForEndpoint returned error: %s", err)
+	}
+	res, err := target.CreateStack(ctx, "demo", strings.NewReader(composeFile), nil)
+	if err != nil {
+		t.Fatalf("CreateStack returned error: %s", err)
+	}
+	stack, _, _ := srv.Stack(res.Id)
+	if stack.EndpointId != edge.Id {
+		t.Errorf("Created stack on endpoint %d, want %d", stack.EndpointId, edge.Id)
+	}
+
+	stacks, err := cli.ListStacks(ctx)
+	if err != nil {
+		t.Fatalf("ListStacks returned error: %s", err)
+	}
+	if len(stacks) != 0 {
+		t.Errorf("Listed %d stacks on the default endpoint, want none", len(stacks))
+	}
+	stacks, err = cli.OnEndpoint(0).ListStacks(ctx)
+	if err != nil {
+		t.Fatalf("ListStacks returned error: %s", err)
+	}
+	if len(stacks) != 1 {
+		t.Errorf("Listed %d stacks on every endpoint, want 1", len(stacks))
+	}
+}
+
+func TestForStack(t *testing.T) {
+	srv := newServer(t)
+	edge := srv.AddEndpoint("edge")
+	added := srv.AddStackOn(edge.Id, "demo", composeFile, nil)
+	cli := srv.Client()
+	ctx := context.Background()
+
+	_, err := cli.StopStack(ctx, added.Id)
+	asAPIError(t, err, http.StatusBadRequest)
+
+	target, err := cli.ForStack(ctx, added.Id, "")
+	if err != nil {
+		t.Fatalf("ForStack returned error: %s", err)
+	}
+	if _, err := target.StopStack(ctx, added.Id); err != nil {
+		t.Errorf("StopStack returned error on the stack's endpoint: %s", err)
+	}
+
+	if _, err := cli.ForStack(ctx, added.Id, "edge"); err != nil {
+		t.Errorf("ForStack returned error for the stack's endpoint: %s", err)
+	}
+	_, err = cli.ForStack(ctx, added.Id, "local")
+	if !errors.Is(err, portainer.ErrWrongEndpoint) {
+		t.Errorf("Expected ErrWrongEndpoint, got %v", err)
+	}
+}
+
+func TestOnEndpointSharesLogin(t *testing.T) {
+	srv, cli := newLoginClient(t)
+	edge := srv.AddEndpoint("edge")
+	ctx := context.Background()
+
+	if _, err := cli.ListStacks(ctx); err != nil {
+		t.Fatalf("ListStacks returned error: %s", err)
+	}
+	if _, err := cli.OnEndpoint(edge.Id).ListStacks(ctx); err != nil {
+		t.Fatalf("ListStacks returned error: %s", err)
+	}
+	if n := srv.Logins(); n != 1 {
+		t.Errorf("Logged in %d times, want the token to be shared", n)
+	}
+}
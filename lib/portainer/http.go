@@ -0,0 +1,223 @@
+package portainer
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const defaultRetryBackoff = 500 * time.Millisecond
+
+// APIError is returned when portainer responds with a non-success status.
+// Message holds portainer's explanation when the body was a JSON error,
+// otherwise Body holds whatever was returned.
+type APIError struct {
+	StatusCode int
+	Message    string
+	Details    string
+	Body       string
+}
+
+func (e *APIError) Error() string {
+	status := fmt.Sprintf("%d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	switch {
+	case e.Message != "" && e.Details != "":
+		return fmt.Sprintf("Portainer returned %s: %s: %s", status, e.Message, e.Details)
+	case e.Message != "":
+		return fmt.Sprintf("Portainer returned %s: %s", status, e.Message)
+	case e.Body != "":
+		return fmt.Sprintf("Portainer returned %s: %s", status, e.Body)
+	}
+	return fmt.Sprintf("Portainer returned %s", status)
+}
+
+func newAPIError(res *http.Response, body []byte) *APIError {
+	apiErr := &APIError{StatusCode: res.StatusCode}
+	var parsed struct {
+		Message string `json:"message"`
+		Details string `json:"details"`
+	}
+	if err := json.Unmarshal(body, &parsed); err == nil && parsed.Message != "" {
+		apiErr.Message = parsed.Message
+		apiErr.Details = parsed.Details
+		return apiErr
+	}
+	apiErr.Body = strings.TrimSpace(string(body))
+	return apiErr
+}
+
+// NewHTTPClient returns a client for talking to a portainer instance over
+// TLS. caBundle is the path to a PEM file of certificates trusted in addition
+// to the system pool and may be empty. insecureSkipVerify disables
+// certificate verification altogether.
+func NewHTTPClient(caBundle string, insecureSkipVerify bool) (*http.Client, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: insecureSkipVerify}
+	if caBundle != "" {
+		pem, err := os.ReadFile(caBundle)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read CA bundle: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No certificates found in %s", caBundle)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &http.Client{Transport: transport}, nil
+}
+
+func (cli *Client) httpClient() *http.Client {
+	if cli.HTTPClient != nil {
+		return cli.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+// request describes a call to the portainer API. The body is held in memory
+// so the request can be sent again when retried. Anonymous requests are sent
+// without credentials.
+type request struct {
+	method      string
+	url         *url.URL
+	contentType string
+	body        []byte
+	anonymous   bool
+}
+
+// unsentError is returned by send when the request failed before any of it
+// was written, so portainer cannot have acted on it.
+type unsentError struct {
+	error
+}
+
+func (e unsentError) Unwrap() error {
+	return e.error
+}
+
+// retryable reports whether a failed attempt at r is worth repeating, but
+// not once the caller's context has ended. GET, PUT and DELETE requests are
+// retried after network errors (no status) and 5xx responses. Other methods
+// are not idempotent, so they are only retried when nothing was sent.
+func retryable(ctx context.Context, r request, status int, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	switch r.method {
+	case http.MethodGet, http.MethodPut, http.MethodDelete:
+		return status == 0 || status >= http.StatusInternalServerError
+	}
+	return status == 0 && errors.As(err, new(unsentError))
+}
+
+// send performs a single attempt at r, bounded by the client's timeout.
+func (cli *Client) send(ctx context.Context, r request) (*http.Response, []byte, error) {
+	if cli.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cli.Timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, r.method, r.url.String(), bytes.NewReader(r.body))
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to create request: %w", err)
+	}
+	if !r.anonymous {
+		if err := cli.authorize(ctx, req); err != nil {
+			return nil, nil, err
+		}
+	}
+	if r.contentType != "" {
+		req.Header.Add("Content-Type", r.contentType)
+	}
+
+	// The transport writes the request on its own goroutine.
+	var wrote atomic.Bool
+	trace := &httptrace.ClientTrace{WroteHeaders: func() { wrote.Store(true) }}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+
+	res, err := cli.httpClient().Do(req)
+	if err != nil {
+		err = fmt.Errorf("Error making request: %w", err)
+		if !wrote.Load() {
+			err = unsentError{err}
+		}
+		return nil, nil, err
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to read body: %w", err)
+	}
+	return res, body, nil
+}
+
+// do sends r, retrying network errors and 5xx responses with exponential
+// backoff where retryable allows, and returns the body of the first
+// successful response. A rejected JWT is renewed and the request sent again
+// straight away. Any other status is returned as an *APIError.
+func (cli *Client) do(ctx context.Context, r request) ([]byte, error) {
+	backoff := cli.RetryBackoff
+	if backoff <= 0 {
+		backoff = defaultRetryBackoff
+	}
+
+	refreshed := false
+	for attempt := 0; ; attempt++ {
+		res, body, err := cli.send(ctx, r)
+		status := 0
+		if err == nil {
+			status = res.StatusCode
+			if status >= 200 && status < 300 {
+				return body, nil
+			}
+			err = newAPIError(res, body)
+		}
+		if status == http.StatusUnauthorized && !refreshed &&
+			cli.invalidateToken(res.Request.Header.Get("Authorization")) {
+			refreshed = true
+			attempt--
+			continue
+		}
+		if attempt >= cli.Retries || !retryable(ctx, r, status, err) {
+			return nil, err
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, fmt.Errorf("%w (%s)", ctx.Err(), err)
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
+
+// doJSON sends r and decodes the response body into out.
+func (cli *Client) doJSON(ctx context.Context, r request, out any) error {
+	body, err := cli.do(ctx, r)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("Failed to unmarshal response: %w", err)
+	}
+	return nil
+}
@@ -1,21 +1,45 @@
 package portainer
 
 import (
-	"bufio"
 	"encoding/json"
 	"fmt"
 	"io"
+	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
-	"strings"
+	"time"
+
+	"github.com/mr55p-dev/app-utils/lib/dotenv"
 )
 
 type Client struct {
-	Scheme     string
-	Host       string
-	ApiKey     string
-	EndpointId string
+	Scheme string
+	Host   string
+	// ApiKey is sent as X-Api-Key, unless Credentials are set in which case
+	// it is only used if logging in fails.
+	ApiKey string
+	// Credentials log in through /api/auth for a JWT, which is cached and
+	// renewed when it expires or is rejected. See LoadCredentials.
+	Credentials *Credentials
+	EndpointId  string
+	// HTTPClient sends the requests, http.DefaultClient is used when nil.
+	// See NewHTTPClient for trusting a self-signed certificate.
+	HTTPClient *http.Client
+	// Timeout bounds each attempt at a request when non-zero.
+	Timeout time.Duration
+	// Retries is how many more times a request is attempted after a network
+	// error or 5xx response. POST requests are only attempted again when the
+	// connection failed before anything was sent.
+	Retries int
+	// RetryBackoff is the delay before the first retry, doubled for each one
+	// after. It defaults to half a second.
+	RetryBackoff time.Duration
+
+	auth tokenCache
+	// sharedAuth is the token cache of the client this one was derived
+	// from by OnEndpoint, so they log in once between them.
+	sharedAuth *tokenCache
 }
 
 type EnvironmentVariable struct {
@@ -39,6 +63,29 @@ func GetStackId(path string) (int, error) {
 	return d.Id, nil
 }
 
+// WriteStackId records the portainer stack an app is deployed as in its
+// .stack file.
+func WriteStackId(path string, stackId int) error {
+	data, err := json.Marshal(StackDataFile{Id: stackId})
+	if err != nil {
+		return fmt.Errorf("Failed to marshal .stack file: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(path, ".stack"), data, 0o644); err != nil {
+		return fmt.Errorf("Failed to write .stack file: %w", err)
+	}
+	return nil
+}
+
+// RemoveStackId removes the .stack file once the app's stack no longer
+// exists. It is not an error if there is no .stack file.
+func RemoveStackId(path string) error {
+	err := os.Remove(filepath.Join(path, ".stack"))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Failed to remove .stack file: %w", err)
+	}
+	return nil
+}
+
 func (cli *Client) newUrl(path string, query ...string) *url.URL {
 	if len(query)%2 != 0 {
 		panic("Bad use of client.newUrl: variadic args should be even")
@@ -53,24 +100,26 @@ func (cli *Client) newUrl(path string, query ...string) *url.URL {
 	u.Path = path
 
 	v := url.Values{}
-	for i := 0; i < len(query)/2; i += 2 {
+	for i := 0; i < len(query); i += 2 {
 		v.Add(query[i], query[i+1])
 	}
 	u.RawQuery = v.Encode()
 	return u
 }
 
+// ReadEnvironment parses an env file into the variables sent to portainer.
+// When a key is repeated the last value wins, as it would for compose.
 func ReadEnvironment(envFile io.Reader) ([]EnvironmentVariable, error) {
-	scanner := bufio.NewScanner(envFile)
-	kvs := make([]EnvironmentVariable, 0)
-	for scanner.Scan() {
-		keyVal := strings.Split(scanner.Text(), "=")
-		if len(keyVal) != 2 {
-			return nil, fmt.Errorf("Encountered invalid key val pair: %v", keyVal)
-		}
+	entries, err := dotenv.Parse(envFile)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse env file: %w", err)
+	}
+	entries = dotenv.Dedupe(entries)
+	kvs := make([]EnvironmentVariable, 0, len(entries))
+	for _, entry := range entries {
 		kvs = append(kvs, EnvironmentVariable{
-			Name:  keyVal[0],
-			Value: keyVal[1],
+			Name:  entry.Key,
+			Value: entry.Value,
 		})
 	}
 	return kvs, nil
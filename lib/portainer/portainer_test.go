@@ -0,0 +1,408 @@
+package portainer_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/mr55p-dev/app-utils/lib/portainer"
+	"github.com/mr55p-dev/app-utils/lib/portainer/portainertest"
+)
+
+const composeFile = "services:\n  web:\n    image: nginx:alpine\n"
+
+func newServer(t *testing.T) *portainertest.Server {
+	t.Helper()
+	srv := portainertest.New("test-key")
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func env(kvs ...string) []portainer.EnvironmentVariable {
+	out := make([]portainer.EnvironmentVariable, 0, len(kvs)/2)
+	for i := 0; i < len(kvs); i += 2 {
+		out = append(out, portainer.EnvironmentVariable{Name: kvs[i], Value: kvs[i+1]})
+	}
+	return out
+}
+
+func asAPIError(t *testing.T, err error, status int) *portainer.APIError {
+	t.Helper()
+	apiErr := new(portainer.APIError)
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("Expected an APIError, got %v", err)
+	}
+	if apiErr.StatusCode != status {
+		t.Errorf("Got status %d, want %d: %s", apiErr.StatusCode, status, err)
+	}
+	return apiErr
+}
+
+func TestCreateStack(t *testing.T) {
+	srv := newServer(t)
+	cli := srv.Client()
+
+	res, err := cli.CreateStack(context.Background(), "demo", strings.NewReader(composeFile), env("A", "1", "B", "two words"))
+	if err != nil {
+		t.Fatalf("CreateStack returned error: %s", err)
+	}
+	stack, file, ok := srv.Stack(res.Id)
+	if !ok {
+		t.Fatalf("Stack %d was not created", res.Id)
+	}
+	if stack.Name != "demo" {
+		t.Errorf("Got name %q, want demo", stack.Name)
+	}
+	if file != composeFile {
+		t.Errorf("Got compose file %q, want %q", file, composeFile)
+	}
+	if want := env("A", "1", "B", "two words"); !reflect.DeepEqual(stack.Env, want) {
+		t.Errorf("Got env %v, want %v", stack.Env, want)
+	}
+
+	query := srv.Requests()[0].Query
+	if query.Get("endpointId") != "1" || query.Get("name") != "demo" {
+		t.Errorf("Got query %v, want endpointId and name", query)
+	}
+}
+
+func TestCreateStackConflict(t *testing.T) {
+	srv := newServer(t)
+	srv.AddStack("demo", composeFile, nil)
+
+	_, err := srv.Client().CreateStack(context.Background(), "demo", strings.NewReader(composeFile), nil)
+	apiErr := asAPIError(t, err, http.StatusConflict)
+	if !strings.Contains(apiErr.Message, "already exists") {
+		t.Errorf("Got message %q", apiErr.Message)
+	}
+}
+
+func TestUpdateStack(t *testing.T) {
+	srv := newServer(t)
+	existing := srv.AddStack("demo", "old", env("A", "1"))
+
+	updated := composeFile + "  db:\n    image: postgres:16\n"
+	res, err := srv.Client().UpdateStack(context.Background(), existing.Id, strings.NewReader(updated), env("B", "2"), portainer.DeployOptions{Prune: true})
+	if err != nil {
+		t.Fatalf("UpdateStack returned error: %s", err)
+	}
+	if res.Id != existing.Id {
+		t.Errorf("Got id %d, want %d", res.Id, existing.Id)
+	}
+	stack, file, _ := srv.Stack(existing.Id)
+	if file != updated {
+		t.Errorf("Got compose file %q, want %q", file, updated)
+	}
+	if want := env("B", "2"); !reflect.DeepEqual(stack.Env, want) {
+		t.Errorf("Got env %v, want %v", stack.Env, want)
+	}
+	if stack.UpdateDate == 0 {
+		t.Error("Expected the update date to be set")
+	}
+	if opts, _ := srv.LastDeploy(existing.Id); !opts.Prune || opts.PullImage {
+		t.Errorf("Got deploy options %+v, want prune only", opts)
+	}
+}
+
+func TestUpdateStackNotFound(t *testing.T) {
+	srv := newServer(t)
+	_, err := srv.Client().UpdateStack(context.Background(), 42, strings.NewReader(composeFile), nil, portainer.DeployOptions{})
+	asAPIError(t, err, http.StatusNotFound)
+}
+
+func TestDeleteStack(t *testing.T) {
+	srv := newServer(t)
+	stack := srv.AddStack("demo", composeFile, nil)
+	cli := srv.Client()
+
+	if err := cli.DeleteStack(context.Background(), stack.Id); err != nil {
+		t.Fatalf("DeleteStack returned error: %s", err)
+	}
+	if _, _, ok := srv.Stack(stack.Id); ok {
+		t.Error("Stack was not deleted")
+	}
+
+	err := cli.DeleteStack(context.Background(), stack.Id)
+	asAPIError(t, err, http.StatusNotFound)
+}
+
+func TestListStacks(t *testing.T) {
+	srv := newServer(t)
+	srv.AddStack("one", composeFile, nil)
+	srv.AddStack("two", composeFile, env("A", "1"))
+	cli := srv.Client()
+
+	stacks, err := cli.ListStacks(context.Background())
+	if err != nil {
+		t.Fatalf("ListStacks returned error: %s", err)
+	}
+	names := make([]string, 0, len(stacks))
+	for _, stack := range stacks {
+		names = append(names, stack.Name)
+		if stack.Type != portainer.StackTypeCompose || stack.Status != portainer.StackStatusActive {
+			t.Errorf("Got %s stack %s, want active compose", stack.Status, stack.Type)
+		}
+	}
+	if want := []string{"one", "two"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("Got stacks %v, want %v", names, want)
+	}
+	if filters := srv.Requests()[0].Query.Get("filters"); filters != `{"EndpointID":1}` {
+		t.Errorf("Got filters %q", filters)
+	}
+}
+
+func TestListStacksEmpty(t *testing.T) {
+	srv := newServer(t)
+	stacks, err := srv.Client().ListStacks(context.Background())
+	if err != nil {
+		t.Fatalf("ListStacks returned error: %s", err)
+	}
+	if len(stacks) != 0 {
+		t.Errorf("Got %d stacks, want none", len(stacks))
+	}
+}
+
+func TestGetStack(t *testing.T) {
+	srv := newServer(t)
+	added := srv.AddStack("demo", composeFile, env("A", "1"))
+	cli := srv.Client()
+
+	stack, err := cli.GetStack(context.Background(), added.Id)
+	if err != nil {
+		t.Fatalf("GetStack returned error: %s", err)
+	}
+	if !reflect.DeepEqual(*stack, added) {
+		t.Errorf("Got %+v, want %+v", *stack, added)
+	}
+	if !stack.Created().Equal(time.Unix(added.CreationDate, 0)) {
+		t.Errorf("Got created %s", stack.Created())
+	}
+
+	_, err = cli.GetStack(context.Background(), added.Id+1)
+	asAPIError(t, err, http.StatusNotFound)
+}
+
+func TestGetStackFile(t *testing.T) {
+	srv := newServer(t)
+	stack := srv.AddStack("demo", composeFile, nil)
+
+	file, err := srv.Client().GetStackFile(context.Background(), stack.Id)
+	if err != nil {
+		t.Fatalf("GetStackFile returned error: %s", err)
+	}
+	if file != composeFile {
+		t.Errorf("Got %q, want %q", file, composeFile)
+	}
+}
+
+func TestStartStopStack(t *testing.T) {
+	srv := newServer(t)
+	added := srv.AddStack("demo", composeFile, nil)
+	cli := srv.Client()
+	ctx := context.Background()
+
+	stack, err := cli.StopStack(ctx, added.Id)
+	if err != nil {
+		t.Fatalf("StopStack returned error: %s", err)
+	}
+	if stack.Status != portainer.StackStatusInactive {
+		t.Errorf("Got status %s after stop", stack.Status)
+	}
+	_, err = cli.StopStack(ctx, added.Id)
+	asAPIError(t, err, http.StatusBadRequest)
+
+	stack, err = cli.StartStack(ctx, added.Id)
+	if err != nil {
+		t.Fatalf("StartStack returned error: %s", err)
+	}
+	if stack.Status != portainer.StackStatusActive {
+		t.Errorf("Got status %s after start", stack.Status)
+	}
+}
+
+func TestUnauthorized(t *testing.T) {
+	srv := newServer(t)
+	cli := srv.Client()
+	cli.ApiKey = "wrong"
+
+	_, err := cli.ListStacks(context.Background())
+	apiErr := asAPIError(t, err, http.StatusUnauthorized)
+	if apiErr.Message != "Unauthorized" || apiErr.Details == "" {
+		t.Errorf("Got message %q details %q", apiErr.Message, apiErr.Details)
+	}
+}
+
+func TestMissingEndpoint(t *testing.T) {
+	srv := newServer(t)
+	stack := srv.AddStack("demo", composeFile, nil)
+	cli := srv.Client()
+	cli.EndpointId = "7"
+
+	err := cli.DeleteStack(context.Background(), stack.Id)
+	asAPIError(t, err, http.StatusNotFound)
+}
+
+func TestRetry(t *testing.T) {
+	srv := newServer(t)
+	cli := srv.Client()
+	cli.Retries = 2
+
+	srv.FailNext(http.StatusBadGateway, http.StatusServiceUnavailable)
+	if _, err := cli.ListStacks(context.Background()); err != nil {
+		t.Fatalf("ListStacks returned error after retrying: %s", err)
+	}
+	if n := len(srv.Requests()); n != 3 {
+		t.Errorf("Got %d requests, want 3", n)
+	}
+}
+
+func TestRetryExhausted(t *testing.T) {
+	srv := newServer(t)
+	cli := srv.Client()
+	cli.Retries = 1
+
+	srv.FailNext(http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway)
+	_, err := cli.ListStacks(context.Background())
+	apiErr := asAPIError(t, err, http.StatusBadGateway)
+	if !strings.Contains(apiErr.Body, "502 Bad Gateway") {
+		t.Errorf("Expected the raw body to be kept, got %q", apiErr.Body)
+	}
+	if n := len(srv.Requests()); n != 2 {
+		t.Errorf("Got %d requests, want 2", n)
+	}
+}
+
+// roundTripFunc lets a function stand in for the client's transport.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestNoRetryOnPost(t *testing.T) {
+	srv := newServer(t)
+	stack := srv.AddStack("demo", composeFile, nil)
+	cli := srv.Client()
+	cli.Retries = 3
+
+	srv.FailNext(http.StatusBadGateway)
+	_, err := cli.StartStack(context.Background(), stack.Id)
+	asAPIError(t, err, http.StatusBadGateway)
+	if n := len(srv.Requests()); n != 1 {
+		t.Errorf("Got %d requests, want 1", n)
+	}
+}
+
+func TestRetryPostNotSent(t *testing.T) {
+	srv := newServer(t)
+	stack := srv.AddStack("demo", composeFile, nil)
+	cli := srv.Client()
+	cli.Retries = 1
+	transport := cli.HTTPClient.Transport
+	refused := false
+	cli.HTTPClient = &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		if !refused {
+			refused = true
+			return nil, syscall.ECONNREFUSED
+		}
+		return transport.RoundTrip(req)
+	})}
+
+	if _, err := cli.StopStack(context.Background(), stack.Id); err != nil {
+		t.Fatalf("StopStack returned error after retrying: %s", err)
+	}
+	if n := len(srv.Requests()); n != 1 {
+		t.Errorf("Got %d requests, want 1", n)
+	}
+}
+
+func TestNoRetryOnClientError(t *testing.T) {
+	srv := newServer(t)
+	cli := srv.Client()
+	cli.Retries = 3
+
+	srv.FailNext(http.StatusBadRequest)
+	_, err := cli.ListStacks(context.Background())
+	asAPIError(t, err, http.StatusBadRequest)
+	if n := len(srv.Requests()); n != 1 {
+		t.Errorf("Got %d requests, want 1", n)
+	}
+}
+
+func TestCancelled(t *testing.T) {
+	srv := newServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := srv.Client().ListStacks(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
+
+func TestTLS(t *testing.T) {
+	srv := portainertest.NewTLS("test-key")
+	t.Cleanup(srv.Close)
+	cli := srv.Client()
+
+	if cli.Scheme != "https" {
+		t.Errorf("Got scheme %q, want https", cli.Scheme)
+	}
+	if _, err := cli.ListStacks(context.Background()); err != nil {
+		t.Fatalf("ListStacks returned error: %s", err)
+	}
+
+	cli.HTTPClient = nil
+	if _, err := cli.ListStacks(context.Background()); err == nil {
+		t.Error("Expected an error for an untrusted certificate")
+	}
+}
+
+func TestReadEnvironment(t *testing.T) {
+	got, err := portainer.ReadEnvironment(strings.NewReader("A=1\n# comment\nB='two words'\nA=3\n"))
+	if err != nil {
+		t.Fatalf("ReadEnvironment returned error: %s", err)
+	}
+	if want := env("A", "3", "B", "two words"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Got %v, want %v", got, want)
+	}
+
+	if _, err := portainer.ReadEnvironment(strings.NewReader("NOVALUE\n")); err == nil {
+		t.Error("Expected an error for a malformed env file")
+	}
+}
+
+func TestStackId(t *testing.T) {
+	dir := t.TempDir()
+
+	if _, err := portainer.GetStackId(dir); err == nil {
+		t.Error("Expected an error without a .stack file")
+	}
+	if err := portainer.WriteStackId(dir, 12); err != nil {
+		t.Fatalf("WriteStackId returned error: %s", err)
+	}
+	id, err := portainer.GetStackId(dir)
+	if err != nil {
+		t.Fatalf("GetStackId returned error: %s", err)
+	}
+	if id != 12 {
+		t.Errorf("Got id %d, want 12", id)
+	}
+
+	if err := portainer.RemoveStackId(dir); err != nil {
+		t.Fatalf("RemoveStackId returned error: %s", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".stack")); !os.IsNotExist(err) {
+		t.Errorf("Expected .stack to be removed, got %v", err)
+	}
+	if err := portainer.RemoveStackId(dir); err != nil {
+		t.Errorf("RemoveStackId returned error without a .stack file: %s", err)
+	}
+}
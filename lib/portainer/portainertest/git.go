@@ -0,0 +1,279 @@
+package portainertest
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/mr55p-dev/app-utils/lib/portainer"
+)
+
+// DefaultRef is the branch git stacks are deployed from when the request
+// names none.
+const DefaultRef = "refs/heads/main"
+
+// repository is a git repository git stacks can be deployed from, holding
+// the files at the head of each ref.
+type repository struct {
+	username string
+	password string
+	refs     map[string]map[string]string
+}
+
+// commit returns a stand-in commit hash for content.
+func commit(ref, content string) string {
+	sum := sha1.Sum([]byte(ref + "\x00" + content))
+	return hex.EncodeToString(sum[:])
+}
+
+// SetRepositoryFile sets the contents of path at the head of ref in the
+// repository at url, creating the repository if needed. Stacks deployed from
+// it see the change when they are next redeployed.
+func (s *Server) SetRepositoryFile(url, ref, path, content string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	repo, ok := s.repos[url]
+	if !ok {
+		repo = &repository{refs: make(map[string]map[string]string)}
+		s.repos[url] = repo
+	}
+	if repo.refs[ref] == nil {
+		repo.refs[ref] = make(map[string]string)
+	}
+	repo.refs[ref][path] = content
+}
+
+// RequireRepositoryAuth makes the repository at url private, readable only
+// with username and password.
+func (s *Server) RequireRepositoryAuth(url, username, password string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if repo, ok := s.repos[url]; ok {
+		repo.username = username
+		repo.password = password
+	}
+}
+
+type gitAuth struct {
+	RepositoryAuthentication bool   `json:"repositoryAuthentication"`
+	RepositoryUsername       string `json:"repositoryUsername"`
+	RepositoryPassword       string `json:"repositoryPassword"`
+}
+
+// clone reads path at ref of the repository at url, as portainer would when
+// deploying. The lock must be held.
+func (s *Server) clone(w http.ResponseWriter, url, ref, path string, auth gitAuth) (string, bool) {
+	repo, ok := s.repos[url]
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "Unable to clone git repository", "repository not found")
+		return "", false
+	}
+	if repo.username != "" || repo.password != "" {
+		if !auth.RepositoryAuthentication || auth.RepositoryUsername != repo.username || auth.RepositoryPassword != repo.password {
+			writeError(w, http.StatusInternalServerError, "Unable to clone git repository", "authentication required")
+			return "", false
+		}
+	}
+	content, ok := repo.refs[ref][path]
+	if !ok {
+		writeError(w, http.StatusBadRequest, "Invalid Compose file path", fmt.Sprintf("%s not found at %s", path, ref))
+		return "", false
+	}
+	return content, true
+}
+
+// setWebhook records the stack's webhook, failing if another stack has it.
+// The lock must be held.
+func (s *Server) setWebhook(w http.ResponseWriter, stack *portainer.Stack, autoUpdate *portainer.AutoUpdate) bool {
+	if autoUpdate != nil && autoUpdate.Webhook != "" {
+		if id, ok := s.webhooks[autoUpdate.Webhook]; ok && id != stack.Id {
+			writeError(w, http.StatusConflict, "A webhook with the same ID already exists", "")
+			return false
+		}
+	}
+	if stack.AutoUpdate != nil {
+		delete(s.webhooks, stack.AutoUpdate.Webhook)
+	}
+	if autoUpdate != nil && autoUpdate.Webhook != "" {
+		s.webhooks[autoUpdate.Webhook] = stack.Id
+	}
+	stack.AutoUpdate = autoUpdate
+	return true
+}
+
+// deploy replaces the stack's compose file with content from its
+// repository. The lock must be held.
+func (s *Server) deploy(stack *portainer.Stack, content string) {
+	stack.GitConfig.ConfigHash = commit(stack.GitConfig.ReferenceName, content)
+	stack.UpdateDate = time.Now().Unix()
+	s.files[stack.Id] = content
+}
+
+// gitStack returns the git stack named by the id path value. The lock must
+// be held.
+func (s *Server) gitStack(w http.ResponseWriter, r *http.Request) (*portainer.Stack, bool) {
+	stack, ok := s.lookupOn(w, r)
+	if !ok {
+		return nil, false
+	}
+	if stack.GitConfig == nil {
+		writeError(w, http.StatusBadRequest, "Stack is not created from git", "")
+		return nil, false
+	}
+	return stack, true
+}
+
+func (s *Server) createGitStack(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		gitAuth
+		Name                    string                          `json:"name"`
+		RepositoryURL           string                          `json:"repositoryURL"`
+		RepositoryReferenceName string                          `json:"repositoryReferenceName"`
+		ComposeFile             string                          `json:"composeFile"`
+		Env                     []portainer.EnvironmentVariable `json:"env"`
+		AutoUpdate              *portainer.AutoUpdate           `json:"autoUpdate"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request payload", err.Error())
+		return
+	}
+	if req.Name == "" {
+		writeError(w, http.StatusBadRequest, "Invalid stack name", "")
+		return
+	}
+	if req.RepositoryURL == "" {
+		writeError(w, http.StatusBadRequest, "Invalid repository URL. Must correspond to a valid URL format", "")
+		return
+	}
+	if req.RepositoryReferenceName == "" {
+		req.RepositoryReferenceName = DefaultRef
+	}
+	if req.ComposeFile == "" {
+		req.ComposeFile = "docker-compose.yml"
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	endpointId, ok := s.endpoint(w, r)
+	if !ok {
+		return
+	}
+	for _, stack := range s.stacks {
+		if stack.Name == req.Name && stack.EndpointId == endpointId {
+			writeError(w, http.StatusConflict, fmt.Sprintf("A stack with the name '%s' already exists", req.Name), "")
+			return
+		}
+	}
+	if req.AutoUpdate != nil && req.AutoUpdate.Webhook != "" {
+		if _, ok := s.webhooks[req.AutoUpdate.Webhook]; ok {
+			writeError(w, http.StatusConflict, "A webhook with the same ID already exists", "")
+			return
+		}
+	}
+	content, ok := s.clone(w, req.RepositoryURL, req.RepositoryReferenceName, req.ComposeFile, req.gitAuth)
+	if !ok {
+		return
+	}
+
+	stack := s.add(endpointId, req.Name, content, req.Env)
+	stack.EntryPoint = req.ComposeFile
+	stack.GitConfig = &portainer.GitConfig{
+		URL:            req.RepositoryURL,
+		ReferenceName:  req.RepositoryReferenceName,
+		ConfigFilePath: req.ComposeFile,
+		ConfigHash:     commit(req.RepositoryReferenceName, content),
+	}
+	s.setWebhook(w, stack, req.AutoUpdate)
+	writeJSON(w, http.StatusOK, stack)
+}
+
+func (s *Server) updateGitStack(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		gitAuth
+		RepositoryReferenceName string                          `json:"repositoryReferenceName"`
+		Env                     []portainer.EnvironmentVariable `json:"env"`
+		AutoUpdate              *portainer.AutoUpdate           `json:"autoUpdate"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request payload", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stack, ok := s.gitStack(w, r)
+	if !ok {
+		return
+	}
+	if !s.setWebhook(w, stack, req.AutoUpdate) {
+		return
+	}
+	if req.RepositoryReferenceName != "" {
+		stack.GitConfig.ReferenceName = req.RepositoryReferenceName
+	}
+	if req.Env == nil {
+		req.Env = []portainer.EnvironmentVariable{}
+	}
+	stack.Env = req.Env
+	writeJSON(w, http.StatusOK, stack)
+}
+
+func (s *Server) redeployGitStack(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		gitAuth
+		portainer.DeployOptions
+		RepositoryReferenceName string                          `json:"repositoryReferenceName"`
+		Env                     []portainer.EnvironmentVariable `json:"env"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request payload", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stack, ok := s.gitStack(w, r)
+	if !ok {
+		return
+	}
+	ref := stack.GitConfig.ReferenceName
+	if req.RepositoryReferenceName != "" {
+		ref = req.RepositoryReferenceName
+	}
+	content, ok := s.clone(w, stack.GitConfig.URL, ref, stack.GitConfig.ConfigFilePath, req.gitAuth)
+	if !ok {
+		return
+	}
+	stack.GitConfig.ReferenceName = ref
+	if req.Env == nil {
+		req.Env = []portainer.EnvironmentVariable{}
+	}
+	stack.Env = req.Env
+	s.deploy(stack, content)
+	s.deploys[stack.Id] = req.DeployOptions
+	writeJSON(w, http.StatusOK, stack)
+}
+
+// webhook redeploys the stack registered for the webhook. Like portainer it
+// needs no credentials, the webhook ID is the secret. Private repositories
+// are read with the credentials the stack was created with, which the fake
+// does not keep, so only public ones can be redeployed this way.
+func (s *Server) webhook(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id, ok := s.webhooks[r.PathValue("webhook")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "Unable to find the stack by webhook ID", "")
+		return
+	}
+	stack := s.stacks[id]
+	content, ok := s.clone(w, stack.GitConfig.URL, stack.GitConfig.ReferenceName, stack.GitConfig.ConfigFilePath, gitAuth{})
+	if !ok {
+		return
+	}
+	s.deploy(stack, content)
+	w.WriteHeader(http.StatusNoContent)
+}
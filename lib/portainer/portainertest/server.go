@@ -0,0 +1,535 @@
+// Package portainertest provides an in-memory portainer server for testing
+// portainer.Client and the code built on it.
+package portainertest
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mr55p-dev/app-utils/lib/portainer"
+)
+
+// EndpointId is the endpoint every server starts with, named "local".
+const EndpointId = 1
+
+// Auth is how a request was authenticated.
+type Auth string
+
+const (
+	AuthNone   Auth = ""
+	AuthAPIKey Auth = "api-key"
+	AuthJWT    Auth = "jwt"
+)
+
+// Request is a request received by the server.
+type Request struct {
+	Method string
+	Path   string
+	Query  url.Values
+	Auth   Auth
+}
+
+// Server fakes the stack endpoints of the portainer API. Every request must
+// carry the server's API key in X-Api-Key, or a JWT from /api/auth as a
+// bearer token. An empty APIKey disables API keys.
+type Server struct {
+	*httptest.Server
+	APIKey string
+	// TokenLifetime is how long the JWTs handed out by /api/auth last.
+	TokenLifetime time.Duration
+
+	mu        sync.Mutex
+	nextId    int
+	stacks    map[int]*portainer.Stack
+	files     map[int]string
+	endpoints map[int]*portainer.Endpoint
+	users     map[string]string
+	tokens    map[string]time.Time
+	repos     map[string]*repository
+	webhooks  map[string]int
+	deploys   map[int]portainer.DeployOptions
+	failures  []int
+	requests  []Request
+}
+
+func newServer(apiKey string) *Server {
+	s := &Server{
+		APIKey:        apiKey,
+		TokenLifetime: 8 * time.Hour,
+		nextId:        1,
+		stacks:        make(map[int]*portainer.Stack),
+		files:         make(map[int]string),
+		endpoints: map[int]*portainer.Endpoint{
+			EndpointId: {Id: EndpointId, Name: "local", Type: portainer.EndpointTypeDocker, URL: "unix:///var/run/docker.sock", Status: portainer.EndpointStatusUp},
+		},
+		users:    make(map[string]string),
+		tokens:   make(map[string]time.Time),
+		repos:    make(map[string]*repository),
+		webhooks: make(map[string]int),
+		deploys:  make(map[int]portainer.DeployOptions),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/auth", s.login)
+	mux.HandleFunc("GET /api/endpoints", s.listEndpoints)
+	mux.HandleFunc("POST /api/stacks/create/standalone/file", s.createStack)
+	mux.HandleFunc("GET /api/stacks", s.listStacks)
+	mux.HandleFunc("GET /api/stacks/{id}", s.getStack)
+	mux.HandleFunc("GET /api/stacks/{id}/file", s.getStackFile)
+	mux.HandleFunc("PUT /api/stacks/{id}", s.updateStack)
+	mux.HandleFunc("DELETE /api/stacks/{id}", s.deleteStack)
+	mux.HandleFunc("POST /api/stacks/{id}/start", s.setStatus(portainer.StackStatusActive))
+	mux.HandleFunc("POST /api/stacks/{id}/stop", s.setStatus(portainer.StackStatusInactive))
+	mux.HandleFunc("POST /api/stacks/create/standalone/repository", s.createGitStack)
+	mux.HandleFunc("POST /api/stacks/{id}/git", s.updateGitStack)
+	mux.HandleFunc("PUT /api/stacks/{id}/git/redeploy", s.redeployGitStack)
+	// Webhook IDs would clash with stack IDs in the patterns above.
+	root := http.NewServeMux()
+	root.HandleFunc("POST /api/stacks/webhooks/{webhook}", s.webhook)
+	root.Handle("/", mux)
+	s.Server = httptest.NewUnstartedServer(s.middleware(root))
+	return s
+}
+
+// New starts a fake portainer server over plain http. It should be closed
+// when the test ends.
+func New(apiKey string) *Server {
+	s := newServer(apiKey)
+	s.Start()
+	return s
+}
+
+// NewTLS starts a fake portainer server over https with a self-signed
+// certificate, see Certificate.
+func NewTLS(apiKey string) *Server {
+	s := newServer(apiKey)
+	s.StartTLS()
+	return s
+}
+
+// Client returns a portainer.Client configured for the server, which retries
+// quickly so tests of failures stay fast.
+func (s *Server) Client() *portainer.Client {
+	u, _ := url.Parse(s.URL)
+	return &portainer.Client{
+		Scheme:       u.Scheme,
+		Host:         u.Host,
+		ApiKey:       s.APIKey,
+		EndpointId:   strconv.Itoa(EndpointId),
+		HTTPClient:   s.Server.Client(),
+		RetryBackoff: time.Millisecond,
+	}
+}
+
+// AddUser lets username log in with password through /api/auth.
+func (s *Server) AddUser(username, password string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[username] = password
+}
+
+// ExpireTokens revokes every JWT handed out so far, as a restart of
+// portainer would.
+func (s *Server) ExpireTokens() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	clear(s.tokens)
+}
+
+// Logins returns how many times a user has logged in.
+func (s *Server) Logins() int {
+	n := 0
+	for _, req := range s.Requests() {
+		if req.Path == "/api/auth" {
+			n++
+		}
+	}
+	return n
+}
+
+// AddEndpoint adds a docker agent endpoint named name.
+func (s *Server) AddEndpoint(name string) portainer.Endpoint {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := len(s.endpoints) + 1
+	endpoint := &portainer.Endpoint{
+		Id:     id,
+		Name:   name,
+		Type:   portainer.EndpointTypeAgent,
+		URL:    fmt.Sprintf("tcp://%s:9001", name),
+		Status: portainer.EndpointStatusUp,
+	}
+	s.endpoints[id] = endpoint
+	return *endpoint
+}
+
+// AddStack creates a stack on the default endpoint directly, as if it had
+// been made in the portainer UI.
+func (s *Server) AddStack(name, composeFile string, env []portainer.EnvironmentVariable) portainer.Stack {
+	return s.AddStackOn(EndpointId, name, composeFile, env)
+}
+
+// AddStackOn creates a stack on endpointId directly.
+func (s *Server) AddStackOn(endpointId int, name, composeFile string, env []portainer.EnvironmentVariable) portainer.Stack {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return *s.add(endpointId, name, composeFile, env)
+}
+
+func (s *Server) add(endpointId int, name, composeFile string, env []portainer.EnvironmentVariable) *portainer.Stack {
+	if env == nil {
+		env = []portainer.EnvironmentVariable{}
+	}
+	stack := &portainer.Stack{
+		Id:           s.nextId,
+		Name:         name,
+		Type:         portainer.StackTypeCompose,
+		EndpointId:   endpointId,
+		Status:       portainer.StackStatusActive,
+		EntryPoint:   "docker-compose.yml",
+		Env:          env,
+		CreatedBy:    "admin",
+		CreationDate: time.Now().Unix(),
+	}
+	s.nextId++
+	s.stacks[stack.Id] = stack
+	s.files[stack.Id] = composeFile
+	return stack
+}
+
+// Stack returns the stack with id and its compose file.
+func (s *Server) Stack(id int) (portainer.Stack, string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stack, ok := s.stacks[id]
+	if !ok {
+		return portainer.Stack{}, "", false
+	}
+	return *stack, s.files[id], true
+}
+
+// LastDeploy returns the options the stack was last updated or redeployed
+// with through the API.
+func (s *Server) LastDeploy(id int) (portainer.DeployOptions, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	opts, ok := s.deploys[id]
+	return opts, ok
+}
+
+// FailNext makes the next len(statuses) requests fail with the given
+// statuses, in order, before they are handled.
+func (s *Server) FailNext(statuses ...int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = append(s.failures, statuses...)
+}
+
+// Requests returns the requests received so far, in order.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]Request, len(s.requests))
+	copy(out, s.requests)
+	return out
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, message, details string) {
+	writeJSON(w, status, map[string]string{"message": message, "details": details})
+}
+
+// authenticate reports how r was authenticated. The lock must be held.
+func (s *Server) authenticate(r *http.Request) Auth {
+	if jwt, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		if expires, ok := s.tokens[jwt]; ok && time.Now().Before(expires) {
+			return AuthJWT
+		}
+		return AuthNone
+	}
+	if key := r.Header.Get("X-Api-Key"); key != "" && key == s.APIKey {
+		return AuthAPIKey
+	}
+	return AuthNone
+}
+
+func (s *Server) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		auth := s.authenticate(r)
+		s.requests = append(s.requests, Request{r.Method, r.URL.Path, r.URL.Query(), auth})
+		status := 0
+		if len(s.failures) > 0 {
+			status, s.failures = s.failures[0], s.failures[1:]
+		}
+		s.mu.Unlock()
+
+		if status != 0 {
+			w.WriteHeader(status)
+			fmt.Fprintf(w, "<html><body>%d %s</body></html>", status, http.StatusText(status))
+			return
+		}
+		if auth == AuthNone && !public(r) {
+			writeError(w, http.StatusUnauthorized, "Unauthorized", "A valid authorisation token is missing")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// public reports whether r may be made without credentials.
+func public(r *http.Request) bool {
+	return r.URL.Path == "/api/auth" || strings.HasPrefix(r.URL.Path, "/api/stacks/webhooks/")
+}
+
+// newToken returns a JWT shaped token expiring at expires. It is only
+// meaningful to this server.
+func newToken(username string, expires time.Time) string {
+	encode := func(v any) string {
+		data, _ := json.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	sig := make([]byte, 16)
+	rand.Read(sig)
+	return strings.Join([]string{
+		encode(map[string]string{"alg": "HS256", "typ": "JWT"}),
+		encode(map[string]any{"username": username, "exp": expires.Unix()}),
+		base64.RawURLEncoding.EncodeToString(sig),
+	}, ".")
+}
+
+func (s *Server) login(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request payload", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	password, ok := s.users[req.Username]
+	if !ok || password != req.Password {
+		writeError(w, http.StatusUnprocessableEntity, "Invalid credentials", "Unauthorized")
+		return
+	}
+	expires := time.Now().Add(s.TokenLifetime)
+	jwt := newToken(req.Username, expires)
+	s.tokens[jwt] = expires
+	writeJSON(w, http.StatusOK, map[string]string{"jwt": jwt})
+}
+
+// endpoint returns the endpoint in the endpointId query parameter required
+// by the mutating stack endpoints. The lock must be held.
+func (s *Server) endpoint(w http.ResponseWriter, r *http.Request) (int, bool) {
+	endpointId, err := strconv.Atoi(r.URL.Query().Get("endpointId"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid query parameter: endpointId", "Missing query parameter")
+		return 0, false
+	}
+	if _, ok := s.endpoints[endpointId]; !ok {
+		writeError(w, http.StatusNotFound, "Unable to find an environment with the specified identifier inside the database", "")
+		return 0, false
+	}
+	return endpointId, true
+}
+
+// lookupOn returns the stack named by the id path value, which must be on
+// the endpoint in the endpointId query parameter. The lock must be held.
+func (s *Server) lookupOn(w http.ResponseWriter, r *http.Request) (*portainer.Stack, bool) {
+	endpointId, ok := s.endpoint(w, r)
+	if !ok {
+		return nil, false
+	}
+	stack, ok := s.lookup(w, r)
+	if !ok {
+		return nil, false
+	}
+	if stack.EndpointId != endpointId {
+		writeError(w, http.StatusBadRequest, "Stack is not deployed on the specified environment", "")
+		return nil, false
+	}
+	return stack, true
+}
+
+// lookup returns the stack named by the id path value. The lock must be
+// held.
+func (s *Server) lookup(w http.ResponseWriter, r *http.Request) (*portainer.Stack, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid stack identifier route variable", err.Error())
+		return nil, false
+	}
+	stack, ok := s.stacks[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "Unable to find a stack with the specified identifier inside the database", "")
+		return nil, false
+	}
+	return stack, true
+}
+
+func (s *Server) listEndpoints(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	endpoints := make([]portainer.Endpoint, 0, len(s.endpoints))
+	for id := 1; id <= len(s.endpoints); id++ {
+		endpoints = append(endpoints, *s.endpoints[id])
+	}
+	writeJSON(w, http.StatusOK, endpoints)
+}
+
+func (s *Server) createStack(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		writeError(w, http.StatusBadRequest, "Invalid stack name", "")
+		return
+	}
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid Compose file", err.Error())
+		return
+	}
+	defer file.Close()
+	composeFile, err := io.ReadAll(file)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid Compose file", err.Error())
+		return
+	}
+	env := make([]portainer.EnvironmentVariable, 0)
+	if raw := r.FormValue("Env"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &env); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid Env parameter", err.Error())
+			return
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	endpointId, ok := s.endpoint(w, r)
+	if !ok {
+		return
+	}
+	for _, stack := range s.stacks {
+		if stack.Name == name && stack.EndpointId == endpointId {
+			writeError(w, http.StatusConflict, fmt.Sprintf("A stack with the name '%s' already exists", name), "")
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, s.add(endpointId, name, string(composeFile), env))
+}
+
+func (s *Server) listStacks(w http.ResponseWriter, r *http.Request) {
+	var filters struct {
+		EndpointID int `json:"EndpointID"`
+	}
+	if raw := r.URL.Query().Get("filters"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &filters); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid query parameter: filters", err.Error())
+			return
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stacks := make([]portainer.Stack, 0, len(s.stacks))
+	for _, stack := range s.stacks {
+		if filters.EndpointID == 0 || stack.EndpointId == filters.EndpointID {
+			stacks = append(stacks, *stack)
+		}
+	}
+	sort.Slice(stacks, func(i, j int) bool { return stacks[i].Id < stacks[j].Id })
+	writeJSON(w, http.StatusOK, stacks)
+}
+
+func (s *Server) getStack(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if stack, ok := s.lookup(w, r); ok {
+		writeJSON(w, http.StatusOK, stack)
+	}
+}
+
+func (s *Server) getStackFile(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if stack, ok := s.lookup(w, r); ok {
+		writeJSON(w, http.StatusOK, map[string]string{"StackFileContent": s.files[stack.Id]})
+	}
+}
+
+func (s *Server) updateStack(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		portainer.DeployOptions
+		Env       []portainer.EnvironmentVariable `json:"env"`
+		StackFile string                          `json:"stackFileContent"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request payload", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stack, ok := s.lookupOn(w, r)
+	if !ok {
+		return
+	}
+	if req.Env == nil {
+		req.Env = []portainer.EnvironmentVariable{}
+	}
+	stack.Env = req.Env
+	stack.UpdateDate = time.Now().Unix()
+	s.files[stack.Id] = req.StackFile
+	s.deploys[stack.Id] = req.DeployOptions
+	writeJSON(w, http.StatusOK, stack)
+}
+
+func (s *Server) deleteStack(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stack, ok := s.lookupOn(w, r)
+	if !ok {
+		return
+	}
+	if stack.AutoUpdate != nil {
+		delete(s.webhooks, stack.AutoUpdate.Webhook)
+	}
+	delete(s.stacks, stack.Id)
+	delete(s.files, stack.Id)
+	delete(s.deploys, stack.Id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) setStatus(status portainer.StackStatus) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		stack, ok := s.lookupOn(w, r)
+		if !ok {
+			return
+		}
+		if stack.Status == status {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("Stack is already %s", status), "")
+			return
+		}
+		stack.Status = status
+		writeJSON(w, http.StatusOK, stack)
+	}
+}
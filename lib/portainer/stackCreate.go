@@ -2,6 +2,7 @@ package portainer
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -14,7 +15,7 @@ type StackCreateResponse struct {
 	Message string `json:"Message"`
 }
 
-func (cli *Client) CreateStack(name string, composeFile io.Reader, environment []EnvironmentVariable) (*StackCreateResponse, error) {
+func (cli *Client) CreateStack(ctx context.Context, name string, composeFile io.Reader, environment []EnvironmentVariable) (*StackCreateResponse, error) {
 	buf := new(bytes.Buffer)
 	fw := multipart.NewWriter(buf)
 	formComposeFile, err := fw.CreateFormFile("file", "docker-compose.yml")
@@ -42,25 +43,15 @@ func (cli *Client) CreateStack(name string, composeFile io.Reader, environment [
 		"name", name,
 	)
 
-	req, err := http.NewRequest(http.MethodPost, createUrl.String(), buf)
-	if err != nil {
-		return nil, fmt.Errorf("Failed to create request: %w", err)
-	}
-	req.Header.Add("X-Api-Key", cli.ApiKey)
-	req.Header.Add("Content-Type", fw.FormDataContentType())
-
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("Request error: %w", err)
-	}
-	defer res.Body.Close()
 	resValues := new(StackCreateResponse)
-	body, err := io.ReadAll(res.Body)
+	err = cli.doJSON(ctx, request{
+		method:      http.MethodPost,
+		url:         createUrl,
+		contentType: fw.FormDataContentType(),
+		body:        buf.Bytes(),
+	}, resValues)
 	if err != nil {
-		return nil, fmt.Errorf("Failed to read body: %w", err)
-	}
-	if err := json.Unmarshal(body, resValues); err != nil {
-		return nil, fmt.Errorf("Failed to unmarshal response: %w", err)
+		return nil, fmt.Errorf("Failed to create stack: %w", err)
 	}
 	if resValues.Id == 0 {
 		return nil, fmt.Errorf("Failed with message:\n%s", resValues.Message)
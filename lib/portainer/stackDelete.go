@@ -0,0 +1,21 @@
+package portainer
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// DeleteStack removes the stack and its containers from portainer. Callers
+// tracking the stack in a .stack file should remove it with RemoveStackId.
+func (cli *Client) DeleteStack(ctx context.Context, stackId int) error {
+	u := cli.newUrl(fmt.Sprintf("/api/stacks/%d", stackId),
+		"endpointId", cli.EndpointId,
+	)
+
+	_, err := cli.do(ctx, request{method: http.MethodDelete, url: u})
+	if err != nil {
+		return fmt.Errorf("Failed to delete stack: %w", err)
+	}
+	return nil
+}
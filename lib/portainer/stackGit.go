@@ -0,0 +1,173 @@
+package portainer
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// GitConfig describes the repository a git stack is deployed from.
+type GitConfig struct {
+	URL            string `json:"URL"`
+	ReferenceName  string `json:"ReferenceName"`
+	ConfigFilePath string `json:"ConfigFilePath"`
+	// ConfigHash is the commit last deployed.
+	ConfigHash string `json:"ConfigHash"`
+}
+
+// AutoUpdate controls how portainer keeps a git stack up to date: by polling
+// the repository every Interval (such as "5m"), and/or when Webhook, an ID
+// from NewWebhookId, is called. See Client.WebhookURL.
+type AutoUpdate struct {
+	Interval       string `json:"interval,omitempty"`
+	Webhook        string `json:"webhook,omitempty"`
+	ForceUpdate    bool   `json:"forceUpdate"`
+	ForcePullImage bool   `json:"forcePullImage"`
+}
+
+// GitRepository is where a git stack's compose file is read from. Username
+// and Password are only needed for private repositories, Password may be a
+// personal access token.
+type GitRepository struct {
+	URL         string
+	Reference   string
+	ComposePath string
+	Username    string
+	Password    string
+	// AutoUpdate is nil to only deploy when asked to.
+	AutoUpdate *AutoUpdate
+}
+
+func (r GitRepository) authenticated() bool {
+	return r.Username != "" || r.Password != ""
+}
+
+type gitStackCreateRequest struct {
+	Name                     string                `json:"name"`
+	RepositoryURL            string                `json:"repositoryURL"`
+	RepositoryReferenceName  string                `json:"repositoryReferenceName,omitempty"`
+	ComposeFile              string                `json:"composeFile,omitempty"`
+	RepositoryAuthentication bool                  `json:"repositoryAuthentication"`
+	RepositoryUsername       string                `json:"repositoryUsername,omitempty"`
+	RepositoryPassword       string                `json:"repositoryPassword,omitempty"`
+	Env                      []EnvironmentVariable `json:"env"`
+	AutoUpdate               *AutoUpdate           `json:"autoUpdate,omitempty"`
+}
+
+type gitStackUpdateRequest struct {
+	RepositoryReferenceName  string                `json:"repositoryReferenceName,omitempty"`
+	RepositoryAuthentication bool                  `json:"repositoryAuthentication"`
+	RepositoryUsername       string                `json:"repositoryUsername,omitempty"`
+	RepositoryPassword       string                `json:"repositoryPassword,omitempty"`
+	Env                      []EnvironmentVariable `json:"env"`
+	Prune                    bool                  `json:"prune"`
+	AutoUpdate               *AutoUpdate           `json:"autoUpdate"`
+}
+
+type gitStackRedeployRequest struct {
+	RepositoryReferenceName  string                `json:"repositoryReferenceName,omitempty"`
+	RepositoryAuthentication bool                  `json:"repositoryAuthentication"`
+	RepositoryUsername       string                `json:"repositoryUsername,omitempty"`
+	RepositoryPassword       string                `json:"repositoryPassword,omitempty"`
+	Env                      []EnvironmentVariable `json:"env"`
+	Prune                    bool                  `json:"prune"`
+	PullImage                bool                  `json:"pullImage"`
+}
+
+// NewWebhookId returns a random ID for AutoUpdate.Webhook.
+func NewWebhookId() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("Failed to generate webhook ID: %w", err)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// WebhookURL returns the URL that redeploys the stack with the given webhook
+// ID when POSTed to. It needs no credentials.
+func (cli *Client) WebhookURL(webhook string) string {
+	return cli.newUrl("/api/stacks/webhooks/" + webhook).String()
+}
+
+func (cli *Client) sendJSON(ctx context.Context, method string, path string, body any, out any) error {
+	buf, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("Failed to marshal request: %w", err)
+	}
+	return cli.doJSON(ctx, request{
+		method:      method,
+		url:         cli.newUrl(path, "endpointId", cli.EndpointId),
+		contentType: "application/json",
+		body:        buf,
+	}, out)
+}
+
+// CreateGitStack creates a stack deployed from the compose file in repo.
+func (cli *Client) CreateGitStack(ctx context.Context, name string, repo GitRepository, environment []EnvironmentVariable) (*StackCreateResponse, error) {
+	req := gitStackCreateRequest{
+		Name:                     name,
+		RepositoryURL:            repo.URL,
+		RepositoryReferenceName:  repo.Reference,
+		ComposeFile:              repo.ComposePath,
+		RepositoryAuthentication: repo.authenticated(),
+		RepositoryUsername:       repo.Username,
+		RepositoryPassword:       repo.Password,
+		Env:                      environment,
+		AutoUpdate:               repo.AutoUpdate,
+	}
+	resValues := new(StackCreateResponse)
+	err := cli.sendJSON(ctx, http.MethodPost, "/api/stacks/create/standalone/repository", req, resValues)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create git stack: %w", err)
+	}
+	if resValues.Id == 0 {
+		return nil, fmt.Errorf("Failed with message:\n%s", resValues.Message)
+	}
+	return resValues, nil
+}
+
+// UpdateGitStack changes the reference, credentials, auto update settings
+// and env of a git stack without redeploying it. The repository URL and
+// compose path of a stack cannot be changed. Only opts.Prune applies, to
+// later automatic updates.
+func (cli *Client) UpdateGitStack(ctx context.Context, stackId int, repo GitRepository, environment []EnvironmentVariable, opts DeployOptions) (*Stack, error) {
+	req := gitStackUpdateRequest{
+		RepositoryReferenceName:  repo.Reference,
+		RepositoryAuthentication: repo.authenticated(),
+		RepositoryUsername:       repo.Username,
+		RepositoryPassword:       repo.Password,
+		Env:                      environment,
+		Prune:                    opts.Prune,
+		AutoUpdate:               repo.AutoUpdate,
+	}
+	stack := new(Stack)
+	err := cli.sendJSON(ctx, http.MethodPost, fmt.Sprintf("/api/stacks/%d/git", stackId), req, stack)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to update git stack: %w", err)
+	}
+	return stack, nil
+}
+
+// RedeployGitStack pulls the latest commit of repo's reference and
+// redeploys the stack from it.
+func (cli *Client) RedeployGitStack(ctx context.Context, stackId int, repo GitRepository, environment []EnvironmentVariable, opts DeployOptions) (*Stack, error) {
+	req := gitStackRedeployRequest{
+		RepositoryReferenceName:  repo.Reference,
+		RepositoryAuthentication: repo.authenticated(),
+		RepositoryUsername:       repo.Username,
+		RepositoryPassword:       repo.Password,
+		Env:                      environment,
+		Prune:                    opts.Prune,
+		PullImage:                opts.PullImage,
+	}
+	stack := new(Stack)
+	err := cli.sendJSON(ctx, http.MethodPut, fmt.Sprintf("/api/stacks/%d/git/redeploy", stackId), req, stack)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to redeploy git stack: %w", err)
+	}
+	return stack, nil
+}
@@ -0,0 +1,204 @@
+package portainer_test
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/mr55p-dev/app-utils/lib/portainer"
+	"github.com/mr55p-dev/app-utils/lib/portainer/portainertest"
+)
+
+const repoURL = "https://git.example.com/apps/demo.git"
+
+func TestCreateGitStack(t *testing.T) {
+	srv := newServer(t)
+	srv.SetRepositoryFile(repoURL, "refs/heads/prod", "deploy/compose.yml", composeFile)
+	cli := srv.Client()
+
+	repo := portainer.GitRepository{
+		URL:         repoURL,
+		Reference:   "refs/heads/prod",
+		ComposePath: "deploy/compose.yml",
+	}
+	res, err := cli.CreateGitStack(context.Background(), "demo", repo, env("A", "1"))
+	if err != nil {
+		t.Fatalf("CreateGitStack returned error: %s", err)
+	}
+	stack, file, ok := srv.Stack(res.Id)
+	if !ok {
+		t.Fatalf("Stack %d was not created", res.Id)
+	}
+	if file != composeFile {
+		t.Errorf("Got compose file %q, want %q", file, composeFile)
+	}
+	if stack.GitConfig == nil || stack.GitConfig.ReferenceName != "refs/heads/prod" || stack.GitConfig.ConfigFilePath != "deploy/compose.yml" {
+		t.Errorf("Got git config %+v", stack.GitConfig)
+	}
+	if want := env("A", "1"); !reflect.DeepEqual(stack.Env, want) {
+		t.Errorf("Got env %v, want %v", stack.Env, want)
+	}
+	if query := srv.Requests()[0].Query; query.Get("endpointId") != "1" {
+		t.Errorf("Got query %v, want endpointId", query)
+	}
+}
+
+func TestCreateGitStackDefaults(t *testing.T) {
+	srv := newServer(t)
+	srv.SetRepositoryFile(repoURL, portainertest.DefaultRef, "docker-compose.yml", composeFile)
+
+	res, err := srv.Client().CreateGitStack(context.Background(), "demo", portainer.GitRepository{URL: repoURL}, nil)
+	if err != nil {
+		t.Fatalf("CreateGitStack returned error: %s", err)
+	}
+	stack, _, _ := srv.Stack(res.Id)
+	if stack.GitConfig.ReferenceName != portainertest.DefaultRef {
+		t.Errorf("Got ref %q, want %q", stack.GitConfig.ReferenceName, portainertest.DefaultRef)
+	}
+	if stack.AutoUpdate != nil {
+		t.Errorf("Got auto update %+v, want none", stack.AutoUpdate)
+	}
+}
+
+func TestCreateGitStackPrivate(t *testing.T) {
+	srv := newServer(t)
+	srv.SetRepositoryFile(repoURL, portainertest.DefaultRef, "docker-compose.yml", composeFile)
+	srv.RequireRepositoryAuth(repoURL, "deploy", "token")
+	cli := srv.Client()
+	ctx := context.Background()
+
+	_, err := cli.CreateGitStack(ctx, "demo", portainer.GitRepository{URL: repoURL}, nil)
+	asAPIError(t, err, http.StatusInternalServerError)
+
+	repo := portainer.GitRepository{URL: repoURL, Username: "deploy", Password: "token"}
+	if _, err := cli.CreateGitStack(ctx, "demo", repo, nil); err != nil {
+		t.Errorf("CreateGitStack returned error with credentials: %s", err)
+	}
+}
+
+func TestCreateGitStackMissingFile(t *testing.T) {
+	srv := newServer(t)
+	srv.SetRepositoryFile(repoURL, portainertest.DefaultRef, "docker-compose.yml", composeFile)
+
+	repo := portainer.GitRepository{URL: repoURL, ComposePath: "compose.yml"}
+	_, err := srv.Client().CreateGitStack(context.Background(), "demo", repo, nil)
+	asAPIError(t, err, http.StatusBadRequest)
+}
+
+func TestUpdateGitStack(t *testing.T) {
+	srv := newServer(t)
+	srv.SetRepositoryFile(repoURL, portainertest.DefaultRef, "docker-compose.yml", composeFile)
+	cli := srv.Client()
+	ctx := context.Background()
+
+	res, err := cli.CreateGitStack(ctx, "demo", portainer.GitRepository{URL: repoURL}, nil)
+	if err != nil {
+		t.Fatalf("CreateGitStack returned error: %s", err)
+	}
+	repo := portainer.GitRepository{
+		URL:        repoURL,
+		Reference:  "refs/heads/prod",
+		AutoUpdate: &portainer.AutoUpdate{Interval: "5m", Webhook: newWebhookId(t)},
+	}
+	stack, err := cli.UpdateGitStack(ctx, res.Id, repo, env("A", "2"), portainer.DeployOptions{})
+	if err != nil {
+		t.Fatalf("UpdateGitStack returned error: %s", err)
+	}
+	if stack.GitConfig.ReferenceName != "refs/heads/prod" {
+		t.Errorf("Got ref %q, want refs/heads/prod", stack.GitConfig.ReferenceName)
+	}
+	if !reflect.DeepEqual(stack.AutoUpdate, repo.AutoUpdate) {
+		t.Errorf("Got auto update %+v, want %+v", stack.AutoUpdate, repo.AutoUpdate)
+	}
+	if want := env("A", "2"); !reflect.DeepEqual(stack.Env, want) {
+		t.Errorf("Got env %v, want %v", stack.Env, want)
+	}
+}
+
+func TestRedeployGitStack(t *testing.T) {
+	srv := newServer(t)
+	srv.SetRepositoryFile(repoURL, portainertest.DefaultRef, "docker-compose.yml", composeFile)
+	cli := srv.Client()
+	ctx := context.Background()
+
+	repo := portainer.GitRepository{URL: repoURL}
+	res, err := cli.CreateGitStack(ctx, "demo", repo, nil)
+	if err != nil {
+		t.Fatalf("CreateGitStack returned error: %s", err)
+	}
+	before, _, _ := srv.Stack(res.Id)
+	deployed := before.GitConfig.ConfigHash
+
+	changed := composeFile + "    restart: always\n"
+	srv.SetRepositoryFile(repoURL, portainertest.DefaultRef, "docker-compose.yml", changed)
+	stack, err := cli.RedeployGitStack(ctx, res.Id, repo, nil, portainer.DeployOptions{Prune: true, PullImage: true})
+	if err != nil {
+		t.Fatalf("RedeployGitStack returned error: %s", err)
+	}
+	if stack.GitConfig.ConfigHash == deployed {
+		t.Errorf("Commit %s did not change after redeploying", deployed)
+	}
+	if _, file, _ := srv.Stack(res.Id); file != changed {
+		t.Errorf("Got compose file %q, want %q", file, changed)
+	}
+	if opts, _ := srv.LastDeploy(res.Id); !opts.Prune || !opts.PullImage {
+		t.Errorf("Got deploy options %+v, want prune and pull", opts)
+	}
+}
+
+func TestRedeployGitStackNotGit(t *testing.T) {
+	srv := newServer(t)
+	added := srv.AddStack("demo", composeFile, nil)
+
+	_, err := srv.Client().RedeployGitStack(context.Background(), added.Id, portainer.GitRepository{URL: repoURL}, nil, portainer.DeployOptions{})
+	asAPIError(t, err, http.StatusBadRequest)
+}
+
+func TestWebhook(t *testing.T) {
+	srv := newServer(t)
+	srv.SetRepositoryFile(repoURL, portainertest.DefaultRef, "docker-compose.yml", composeFile)
+	cli := srv.Client()
+
+	webhook := newWebhookId(t)
+	repo := portainer.GitRepository{URL: repoURL, AutoUpdate: &portainer.AutoUpdate{Webhook: webhook}}
+	res, err := cli.CreateGitStack(context.Background(), "demo", repo, nil)
+	if err != nil {
+		t.Fatalf("CreateGitStack returned error: %s", err)
+	}
+
+	changed := composeFile + "    restart: always\n"
+	srv.SetRepositoryFile(repoURL, portainertest.DefaultRef, "docker-compose.yml", changed)
+	resp, err := http.Post(cli.WebhookURL(webhook), "", nil)
+	if err != nil {
+		t.Fatalf("Calling the webhook returned error: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("Webhook returned status %d", resp.StatusCode)
+	}
+	if _, file, _ := srv.Stack(res.Id); file != changed {
+		t.Errorf("Got compose file %q, want %q", file, changed)
+	}
+}
+
+func newWebhookId(t *testing.T) string {
+	t.Helper()
+	webhook, err := portainer.NewWebhookId()
+	if err != nil {
+		t.Fatalf("NewWebhookId returned error: %s", err)
+	}
+	return webhook
+}
+
+func TestNewWebhookId(t *testing.T) {
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	a, b := newWebhookId(t), newWebhookId(t)
+	if !uuid.MatchString(a) {
+		t.Errorf("Got webhook ID %q, want a UUID", a)
+	}
+	if a == b {
+		t.Errorf("Got the same webhook ID twice: %s", a)
+	}
+}
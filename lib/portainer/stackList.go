@@ -0,0 +1,114 @@
+package portainer
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type StackType int
+type StackStatus int
+
+const (
+	StackTypeSwarm      StackType = 1
+	StackTypeCompose    StackType = 2
+	StackTypeKubernetes StackType = 3
+
+	StackStatusActive   StackStatus = 1
+	StackStatusInactive StackStatus = 2
+)
+
+func (t StackType) String() string {
+	switch t {
+	case StackTypeSwarm:
+		return "swarm"
+	case StackTypeCompose:
+		return "compose"
+	case StackTypeKubernetes:
+		return "kubernetes"
+	}
+	return "unknown"
+}
+
+func (s StackStatus) String() string {
+	switch s {
+	case StackStatusActive:
+		return "active"
+	case StackStatusInactive:
+		return "inactive"
+	}
+	return "unknown"
+}
+
+// Stack is a stack as described by the portainer API.
+type Stack struct {
+	Id           int                   `json:"Id"`
+	Name         string                `json:"Name"`
+	Type         StackType             `json:"Type"`
+	EndpointId   int                   `json:"EndpointId"`
+	Status       StackStatus           `json:"Status"`
+	EntryPoint   string                `json:"EntryPoint"`
+	Env          []EnvironmentVariable `json:"Env"`
+	CreatedBy    string                `json:"CreatedBy"`
+	CreationDate int64                 `json:"CreationDate"`
+	UpdateDate   int64                 `json:"UpdateDate"`
+	// GitConfig is set for stacks deployed from a git repository.
+	GitConfig  *GitConfig  `json:"GitConfig,omitempty"`
+	AutoUpdate *AutoUpdate `json:"AutoUpdate,omitempty"`
+}
+
+func (s Stack) Created() time.Time {
+	return time.Unix(s.CreationDate, 0)
+}
+
+func (s Stack) Updated() time.Time {
+	if s.UpdateDate == 0 {
+		return s.Created()
+	}
+	return time.Unix(s.UpdateDate, 0)
+}
+
+type stackFileResponse struct {
+	StackFileContent string `json:"StackFileContent"`
+}
+
+// ListStacks returns the stacks on the client's endpoint, or every stack
+// when no endpoint is set.
+func (cli *Client) ListStacks(ctx context.Context) ([]Stack, error) {
+	query := []string{}
+	if cli.EndpointId != "" {
+		endpointId, err := strconv.Atoi(cli.EndpointId)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid endpoint ID %q: %w", cli.EndpointId, err)
+		}
+		query = append(query, "filters", fmt.Sprintf(`{"EndpointID":%d}`, endpointId))
+	}
+
+	stacks := make([]Stack, 0)
+	err := cli.doJSON(ctx, request{method: http.MethodGet, url: cli.newUrl("/api/stacks", query...)}, &stacks)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list stacks: %w", err)
+	}
+	return stacks, nil
+}
+
+func (cli *Client) GetStack(ctx context.Context, stackId int) (*Stack, error) {
+	stack := new(Stack)
+	u := cli.newUrl(fmt.Sprintf("/api/stacks/%d", stackId))
+	if err := cli.doJSON(ctx, request{method: http.MethodGet, url: u}, stack); err != nil {
+		return nil, fmt.Errorf("Failed to get stack %d: %w", stackId, err)
+	}
+	return stack, nil
+}
+
+// GetStackFile returns the contents of the stack's compose file.
+func (cli *Client) GetStackFile(ctx context.Context, stackId int) (string, error) {
+	res := new(stackFileResponse)
+	u := cli.newUrl(fmt.Sprintf("/api/stacks/%d/file", stackId))
+	if err := cli.doJSON(ctx, request{method: http.MethodGet, url: u}, res); err != nil {
+		return "", fmt.Errorf("Failed to get stack file for %d: %w", stackId, err)
+	}
+	return res.StackFileContent, nil
+}
@@ -0,0 +1,29 @@
+package portainer
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+func (cli *Client) setStackState(ctx context.Context, stackId int, action string) (*Stack, error) {
+	u := cli.newUrl(fmt.Sprintf("/api/stacks/%d/%s", stackId, action),
+		"endpointId", cli.EndpointId,
+	)
+	stack := new(Stack)
+	if err := cli.doJSON(ctx, request{method: http.MethodPost, url: u}, stack); err != nil {
+		return nil, fmt.Errorf("Failed to %s stack %d: %w", action, stackId, err)
+	}
+	return stack, nil
+}
+
+// StartStack starts the containers of a stopped stack.
+func (cli *Client) StartStack(ctx context.Context, stackId int) (*Stack, error) {
+	return cli.setStackState(ctx, stackId, "start")
+}
+
+// StopStack stops the containers of a stack, keeping its definition in
+// portainer.
+func (cli *Client) StopStack(ctx context.Context, stackId int) (*Stack, error) {
+	return cli.setStackState(ctx, stackId, "stop")
+}
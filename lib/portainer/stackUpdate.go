@@ -1,7 +1,7 @@
 package portainer
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -16,20 +16,28 @@ type updateStackRequest struct {
 	StackFile string                `json:"stackFileContent"`
 }
 
+// DeployOptions control how portainer redeploys a stack.
+type DeployOptions struct {
+	// Prune removes services no longer in the compose file.
+	Prune bool `json:"prune"`
+	// PullImage pulls the latest images even if they are already present.
+	PullImage bool `json:"pullImage"`
+}
+
 type StackUpdateResponse struct {
 	Id      int    `json:"Id"`
 	Message string `json:"Message"`
 }
 
-func (cli *Client) UpdateStack(stackId int, composeFile io.Reader, environment []EnvironmentVariable) (*StackUpdateResponse, error) {
+func (cli *Client) UpdateStack(ctx context.Context, stackId int, composeFile io.Reader, environment []EnvironmentVariable, opts DeployOptions) (*StackUpdateResponse, error) {
 	b := strings.Builder{}
 	if _, err := io.Copy(&b, composeFile); err != nil {
 		return nil, fmt.Errorf("Failed to copy compose file: %w", err)
 	}
 	reqData := updateStackRequest{
 		Env:       environment,
-		Prune:     true,
-		PullImage: false,
+		Prune:     opts.Prune,
+		PullImage: opts.PullImage,
 		StackFile: b.String(),
 	}
 	buf, err := json.Marshal(reqData)
@@ -41,25 +49,15 @@ func (cli *Client) UpdateStack(stackId int, composeFile io.Reader, environment [
 		"endpointId", cli.EndpointId,
 	)
 
-	req, err := http.NewRequest(http.MethodPut, u.String(), bytes.NewReader(buf))
-	if err != nil {
-		return nil, fmt.Errorf("Failed to create request: %w", err)
-	}
-	req.Header.Add("X-Api-Key", cli.ApiKey)
-	req.Header.Add("Content-Type", "application/json")
-
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("Error making request: %w", err)
-	}
-	defer res.Body.Close()
 	resValues := new(StackUpdateResponse)
-	body, err := io.ReadAll(res.Body)
+	err = cli.doJSON(ctx, request{
+		method:      http.MethodPut,
+		url:         u,
+		contentType: "application/json",
+		body:        buf,
+	}, resValues)
 	if err != nil {
-		return nil, fmt.Errorf("Failed to read body: %w", err)
-	}
-	if err := json.Unmarshal(body, resValues); err != nil {
-		return nil, fmt.Errorf("Failed to unmarshal response: %w", err)
+		return nil, fmt.Errorf("Failed to update stack: %w", err)
 	}
 	if resValues.Id == 0 {
 		return nil, fmt.Errorf("Failed to create stack with message:\n%s", resValues.Message)
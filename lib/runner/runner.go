@@ -0,0 +1,133 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cmd describes a command to run.
+type Cmd struct {
+	Name string
+	Args []string
+	Dir  string
+}
+
+func (c Cmd) String() string {
+	return strings.TrimSpace(c.Name + " " + strings.Join(c.Args, " "))
+}
+
+// Runner executes commands on behalf of the clients in lib, so they can be
+// swapped for a fake in tests.
+type Runner interface {
+	// Run runs cmd to completion and returns what it wrote to stdout and
+	// stderr. A non-nil error is returned if the command could not be
+	// started, exited unsuccessfully or ctx ended first.
+	Run(ctx context.Context, cmd Cmd) (stdout []byte, stderr []byte, err error)
+	// Start starts cmd and returns its stdout. Closing the stream stops the
+	// command and reports how it exited.
+	Start(ctx context.Context, cmd Cmd) (Stream, error)
+}
+
+// Stream is the output of a running command.
+type Stream interface {
+	io.ReadCloser
+	// Stderr returns what the command has written to stderr so far.
+	Stderr() string
+}
+
+// Exec runs commands with os/exec. Timeout, when set, bounds each call to
+// Run but not the lifetime of a Stream.
+type Exec struct {
+	Timeout time.Duration
+}
+
+// waitDelay bounds how long a killed command's output is drained, as children
+// it started can otherwise hold the pipes open indefinitely.
+const waitDelay = time.Second
+
+// contextError prefers the context's error when the command failed because
+// ctx ended, so callers can tell cancellation apart from failure.
+func contextError(ctx context.Context, err error) error {
+	ctxErr := ctx.Err()
+	if ctxErr == nil {
+		return err
+	}
+	return fmt.Errorf("%w (%s)", ctxErr, err)
+}
+
+func (e Exec) Run(ctx context.Context, cmd Cmd) ([]byte, []byte, error) {
+	if e.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.Timeout)
+		defer cancel()
+	}
+
+	outBytes := new(bytes.Buffer)
+	errBytes := new(bytes.Buffer)
+	c := exec.CommandContext(ctx, cmd.Name, cmd.Args...)
+	c.Dir = cmd.Dir
+	c.WaitDelay = waitDelay
+	c.Stdout = outBytes
+	c.Stderr = errBytes
+	if err := c.Run(); err != nil {
+		return outBytes.Bytes(), errBytes.Bytes(), contextError(ctx, err)
+	}
+	return outBytes.Bytes(), errBytes.Bytes(), nil
+}
+
+type execStream struct {
+	io.ReadCloser
+	ctx    context.Context
+	cmd    *exec.Cmd
+	stderr *bytes.Buffer
+	once   sync.Once
+	err    error
+}
+
+func (s *execStream) Stderr() string {
+	return s.stderr.String()
+}
+
+// Close stops the command and waits for it. It is safe to call more than
+// once, and concurrently, each call reporting the same result.
+func (s *execStream) Close() error {
+	s.once.Do(func() {
+		s.err = s.wait()
+	})
+	return s.err
+}
+
+func (s *execStream) wait() error {
+	s.cmd.Process.Kill()
+	err := s.cmd.Wait()
+	if err != nil && s.cmd.ProcessState != nil && !s.cmd.ProcessState.Exited() {
+		// Killed by Close or ctx rather than failing by itself.
+		return nil
+	}
+	if err != nil {
+		return contextError(s.ctx, err)
+	}
+	return nil
+}
+
+func (e Exec) Start(ctx context.Context, cmd Cmd) (Stream, error) {
+	errBytes := new(bytes.Buffer)
+	c := exec.CommandContext(ctx, cmd.Name, cmd.Args...)
+	c.Dir = cmd.Dir
+	c.WaitDelay = waitDelay
+	c.Stderr = errBytes
+	stdout, err := c.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open stdout: %w", err)
+	}
+	if err := c.Start(); err != nil {
+		return nil, contextError(ctx, err)
+	}
+	return &execStream{ReadCloser: stdout, ctx: ctx, cmd: c, stderr: errBytes}, nil
+}
@@ -0,0 +1,110 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestExecRun(t *testing.T) {
+	stdout, stderr, err := Exec{}.Run(context.Background(), Cmd{
+		Name: "sh",
+		Args: []string{"-c", "echo out; echo err >&2"},
+	})
+	if err != nil {
+		t.Fatalf("Run returned error: %s", err)
+	}
+	if string(stdout) != "out\n" || string(stderr) != "err\n" {
+		t.Errorf("Got stdout %q and stderr %q", stdout, stderr)
+	}
+}
+
+func TestExecRunFailure(t *testing.T) {
+	_, stderr, err := Exec{}.Run(context.Background(), Cmd{
+		Name: "sh",
+		Args: []string{"-c", "echo broken >&2; exit 3"},
+	})
+	if err == nil {
+		t.Fatal("Expected an error")
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Failure should not look like a context error: %s", err)
+	}
+	if string(stderr) != "broken\n" {
+		t.Errorf("Got stderr %q", stderr)
+	}
+}
+
+func TestExecRunContext(t *testing.T) {
+	sleep := Cmd{Name: "sleep", Args: []string{"10"}}
+
+	_, _, err := Exec{Timeout: 50 * time.Millisecond}.Run(context.Background(), sleep)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected a deadline error from the timeout, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	_, _, err = Exec{}.Run(ctx, sleep)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected a cancellation error, got %v", err)
+	}
+}
+
+func TestExecStart(t *testing.T) {
+	stream, err := Exec{}.Start(context.Background(), Cmd{
+		Name: "sh",
+		Args: []string{"-c", "echo warning >&2; echo line; exec sleep 10"},
+	})
+	if err != nil {
+		t.Fatalf("Start returned error: %s", err)
+	}
+	buf := make([]byte, 5)
+	if _, err := stream.Read(buf); err != nil || string(buf) != "line\n" {
+		t.Fatalf("Read %q, %v", buf, err)
+	}
+	if err := stream.Close(); err != nil {
+		t.Errorf("Closing a running stream should not fail: %s", err)
+	}
+	if !strings.Contains(stream.Stderr(), "warning") {
+		t.Errorf("Got stderr %q", stream.Stderr())
+	}
+}
+
+func TestExecStreamConcurrentClose(t *testing.T) {
+	stream, err := Exec{}.Start(context.Background(), Cmd{Name: "sleep", Args: []string{"10"}})
+	if err != nil {
+		t.Fatalf("Start returned error: %s", err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := stream.Close(); err != nil {
+				t.Errorf("Close returned error: %s", err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestExecStreamContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := Exec{}.Start(ctx, Cmd{Name: "sleep", Args: []string{"10"}})
+	if err != nil {
+		t.Fatalf("Start returned error: %s", err)
+	}
+	time.AfterFunc(50*time.Millisecond, cancel)
+	// Reading ends once ctx is cancelled, without Close being called.
+	if _, err := io.ReadAll(stream); err != nil {
+		t.Errorf("Read returned error: %s", err)
+	}
+	if err := stream.Close(); err != nil {
+		t.Errorf("Close returned error: %s", err)
+	}
+}
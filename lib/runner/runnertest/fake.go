@@ -0,0 +1,109 @@
+// Package runnertest provides a fake runner.Runner for tests.
+package runnertest
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/mr55p-dev/app-utils/lib/runner"
+)
+
+// Response is the scripted outcome of a command.
+type Response struct {
+	Stdout string
+	Stderr string
+	Err    error
+}
+
+type rule struct {
+	prefix   string
+	response Response
+}
+
+// Fake records every command it is asked to run and replies with the first
+// scripted response whose prefix matches the command line. Commands with no
+// matching response succeed with no output.
+type Fake struct {
+	mu          sync.Mutex
+	rules       []rule
+	invocations []runner.Cmd
+}
+
+func New() *Fake {
+	return new(Fake)
+}
+
+// On scripts the response for commands whose command line, the name and
+// arguments joined by spaces, starts with prefix.
+func (f *Fake) On(prefix string, res Response) *Fake {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rules = append(f.rules, rule{prefix, res})
+	return f
+}
+
+// Invocations returns the commands run so far, in order.
+func (f *Fake) Invocations() []runner.Cmd {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := make([]runner.Cmd, len(f.invocations))
+	copy(out, f.invocations)
+	return out
+}
+
+// Commands returns the command lines run so far, in order.
+func (f *Fake) Commands() []string {
+	invocations := f.Invocations()
+	out := make([]string, len(invocations))
+	for i, cmd := range invocations {
+		out[i] = cmd.String()
+	}
+	return out
+}
+
+func (f *Fake) respond(cmd runner.Cmd) Response {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.invocations = append(f.invocations, cmd)
+	line := cmd.String()
+	for _, r := range f.rules {
+		if strings.HasPrefix(line, r.prefix) {
+			return r.response
+		}
+	}
+	return Response{}
+}
+
+func (f *Fake) Run(ctx context.Context, cmd runner.Cmd) ([]byte, []byte, error) {
+	res := f.respond(cmd)
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	return []byte(res.Stdout), []byte(res.Stderr), res.Err
+}
+
+type stream struct {
+	io.Reader
+	stderr string
+	err    error
+}
+
+func (s *stream) Close() error   { return s.err }
+func (s *stream) Stderr() string { return s.stderr }
+
+// Start returns the scripted stdout as the stream. The scripted error is
+// returned when the stream is closed, as it would be for a command that
+// exited unsuccessfully.
+func (f *Fake) Start(ctx context.Context, cmd runner.Cmd) (runner.Stream, error) {
+	res := f.respond(cmd)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return &stream{Reader: strings.NewReader(res.Stdout), stderr: res.Stderr, err: res.Err}, nil
+}
+
+// ErrExit stands in for the error returned when a command exits non-zero.
+var ErrExit = errors.New("exit status 1")